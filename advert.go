@@ -0,0 +1,101 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	advHeaderSize = 8  // flags, firmware version and reserved bytes
+	advDataSize   = 13 // same layout than the uuidReadAll characteristic
+)
+
+// ParseAdvertisement decodes the measurements an Aranet4 device broadcasts
+// in its BLE manufacturer specific data, when its "Smart Home integration"
+// is enabled.
+//
+// p holds the manufacturer specific data, without the leading SAF Tehnika
+// company identifier (0x0702).
+// ParseAdvertisement returns an error wrapping ErrNoData if the
+// advertisement carries no measurements, i.e. when the integration is
+// disabled.
+// The status broadcast by the device is recorded in the Status field of
+// the returned sample: its air quality is left for the caller to assess
+// with a Policy.
+func ParseAdvertisement(p []byte) (Data, error) {
+	return ParseAdvertisementAt(p, time.Now())
+}
+
+// ParseAdvertisementAt is like ParseAdvertisement, but computes the
+// time-stamp of the returned sample relative to now, the time the
+// advertisement was received.
+func ParseAdvertisementAt(p []byte, now time.Time) (Data, error) {
+	var data Data
+	switch {
+	case len(p) < advHeaderSize:
+		return data, protocolError("decode advertisement", p, io.ErrUnexpectedEOF)
+	case len(p) < advHeaderSize+advDataSize:
+		return data, fmt.Errorf("aranet4: advertisement without measurements (integration disabled?): %w", ErrNoData)
+	}
+
+	dec := newDecoderAt(p[advHeaderSize:], now)
+	err := dec.readCurrent(&data)
+	if err != nil {
+		return data, protocolError("decode advertisement", p, err)
+	}
+	return data, nil
+}
+
+// Listen passively listens for the measurements broadcast by nearby
+// Aranet4 devices, using the default Bluetooth adapter.
+// No connection is established with the devices.
+//
+// f is called with the address of the device and the decoded measurement,
+// once per new measurement.
+// f may connect to a device, e.g. to download its history: the scan is
+// paused while the connection holds the adapter.
+// Only devices with their "Smart Home integration" enabled broadcast
+// their measurements.
+//
+// Listen runs until the context is done.
+func Listen(ctx context.Context, f func(addr string, data Data)) error {
+	return listen(ctx, btScanner{}, f, time.Now)
+}
+
+func listen(ctx context.Context, sc scanner, f func(addr string, data Data), now func() time.Time) error {
+	last := make(map[string]Data)
+	err := sc.scan(ctx, func(adv advertisement) {
+		if adv.mfr == nil {
+			return
+		}
+		data, err := ParseAdvertisementAt(adv.mfr, now())
+		if err != nil {
+			return
+		}
+		if prev, ok := last[adv.addr]; ok && !isNewMeasurement(prev, data) {
+			return
+		}
+		last[adv.addr] = data
+		f(adv.addr, data)
+	})
+	if err != nil && err != ctx.Err() {
+		return fmt.Errorf("could not listen for advertisements: %w", err)
+	}
+	return nil
+}
+
+// isNewMeasurement returns whether cur is a different measurement than prev.
+// Devices broadcast the same measurement repeatedly, until the next one.
+func isNewMeasurement(prev, cur Data) bool {
+	dt := cur.Time.Sub(prev.Time)
+	if dt < 0 {
+		dt = -dt
+	}
+	return dt > cur.Interval/2
+}
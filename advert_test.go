@@ -0,0 +1,123 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// advPayload is an Aranet4 advertisement, with integrations enabled.
+var advPayload = []byte{
+	0x22, 0x13, 0x04, 0x01, 0x00, 0x0c, 0x0f, 0x01, // header
+	0x3a, 0x02, // CO2: 570 ppm
+	0x8f, 0x01, // T: 19.95°C
+	0x4d, 0x26, // P: 980.5 hPa
+	0x1d,       // H: 29%
+	0x60,       // battery: 96%
+	0x01,       // quality: green
+	0x2c, 0x01, // interval: 300s
+	0x78, 0x00, // ago: 120s
+	0x2a, // counter
+}
+
+func TestParseAdvertisement(t *testing.T) {
+	beg := time.Now().UTC()
+	got, err := ParseAdvertisement(advPayload)
+	if err != nil {
+		t.Fatalf("could not parse advertisement: %+v", err)
+	}
+	end := time.Now().UTC()
+
+	want := Data{
+		H: 29, P: 980.5, T: 19.95,
+		CO2:      570,
+		Battery:  96,
+		Status:   1,
+		Interval: 5 * time.Minute,
+		Model:    ModelAranet4,
+	}
+	if got.Time.Before(beg.Add(-2*time.Minute)) || got.Time.After(end.Add(-2*time.Minute)) {
+		t.Fatalf("invalid time-stamp: %v", got.Time)
+	}
+	got.Time = time.Time{}
+	if got != want {
+		t.Fatalf("invalid data:\ngot:\n%vwant:\n%v", got, want)
+	}
+
+	now := time.Date(2022, 1, 20, 15, 50, 28, 0, time.UTC)
+	got, err = ParseAdvertisementAt(advPayload, now)
+	if err != nil {
+		t.Fatalf("could not parse advertisement: %+v", err)
+	}
+	if got, want := got.Time, now.Add(-2*time.Minute); !got.Equal(want) {
+		t.Fatalf("invalid time-stamp: got=%v, want=%v", got, want)
+	}
+
+	_, err = ParseAdvertisement(advPayload[:advHeaderSize])
+	if !errors.Is(err, ErrNoData) {
+		t.Fatalf("invalid error for advertisement without measurements: %+v", err)
+	}
+
+	for _, n := range []int{3, advHeaderSize - 1} {
+		_, err = ParseAdvertisement(advPayload[:n])
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("invalid error for truncated advertisement (%d bytes): %+v", n, err)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	next := func(ago, co2 byte) []byte {
+		p := append([]byte(nil), advPayload...)
+		p[8] = co2
+		p[19] = ago
+		return p
+	}
+
+	sc := replayScanner{
+		{addr: "F5:6C:BE:D5:61:47", name: "Aranet4 1234A", mfr: next(200, 0x10)},
+		{addr: "F5:6C:BE:D5:61:47", mfr: next(201, 0x10)}, // same measurement
+		{addr: "C0:FF:EE:00:00:01", name: "Aranet4 0042B", mfr: advPayload[:advHeaderSize]},
+		{addr: "C0:FF:EE:00:00:02", name: "Aranet4 0042C", mfr: next(10, 0x20)},
+		{addr: "00:11:22:33:44:55", name: "headphones"},
+		{addr: "F5:6C:BE:D5:61:47", mfr: next(202, 0x10)}, // same measurement
+		{addr: "F5:6C:BE:D5:61:47", mfr: next(0, 0x11)},   // new measurement
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	type reading struct {
+		addr string
+		co2  int
+		time time.Time
+	}
+	now := time.Date(2022, 1, 20, 15, 50, 28, 0, time.UTC)
+	var got []reading
+	err := listen(ctx, sc, func(addr string, data Data) {
+		got = append(got, reading{addr, data.CO2, data.Time})
+	}, func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("could not listen: %+v", err)
+	}
+
+	want := []reading{
+		{"F5:6C:BE:D5:61:47", 0x210, now.Add(-200 * time.Second)},
+		{"C0:FF:EE:00:00:02", 0x220, now.Add(-10 * time.Second)},
+		{"F5:6C:BE:D5:61:47", 0x211, now},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("invalid readings:\ngot= %v\nwant=%v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("invalid reading %d:\ngot= %v\nwant=%v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package analysis extracts higher level information from series of data
+// samples, such as the ventilation rate of a room.
+package analysis // import "sbinet.org/x/aranet4/analysis"
+
+import (
+	"math"
+	"time"
+
+	"gonum.org/v1/gonum/stat/distuv"
+	"sbinet.org/x/aranet4"
+)
+
+// DefaultBaseline is the default outdoor CO2 concentration, in ppm.
+const DefaultBaseline = 420
+
+// DecayOptions configures the search for CO2 decay episodes.
+type DecayOptions struct {
+	// Baseline is the outdoor CO2 concentration, in ppm, toward which
+	// indoor concentrations decay (default: DefaultBaseline).
+	Baseline float64
+
+	// MinDrop is the minimum decrease of the CO2 concentration, in ppm,
+	// over an episode (default: 200 ppm).
+	MinDrop float64
+
+	// MinDuration is the minimum duration of an episode
+	// (default: 30 minutes).
+	MinDuration time.Duration
+
+	// MinExcess is the concentration above the baseline, in ppm, below
+	// which an episode ends, as the remaining excess is dominated by the
+	// noise of the sensor and by the uncertainty on the baseline
+	// (default: 50 ppm).
+	MinExcess float64
+
+	// Noise is the increase of the CO2 concentration, in ppm, tolerated
+	// within an episode (default: 15 ppm).
+	Noise float64
+
+	// Confidence is the level of the confidence intervals on the air
+	// change rates (default: 0.95).
+	Confidence float64
+}
+
+func (opts *DecayOptions) defaults() {
+	if opts.Baseline <= 0 {
+		opts.Baseline = DefaultBaseline
+	}
+	if opts.MinDrop <= 0 {
+		opts.MinDrop = 200
+	}
+	if opts.MinDuration <= 0 {
+		opts.MinDuration = 30 * time.Minute
+	}
+	if opts.MinExcess <= 0 {
+		opts.MinExcess = 50
+	}
+	if opts.Noise <= 0 {
+		opts.Noise = 15
+	}
+	if opts.Confidence <= 0 || opts.Confidence >= 1 {
+		opts.Confidence = 0.95
+	}
+}
+
+// Decay describes an episode of exponential decay of the CO2
+// concentration, e.g. after people left a meeting room.
+//
+// The concentration is modeled as:
+//
+//	C(t) = Cb + (C0 - Cb) exp(-ACH t)
+//
+// with Cb the outdoor baseline, C0 the concentration at the start of the
+// episode, t the time in hours and ACH the number of air changes per
+// hour.
+type Decay struct {
+	Beg, End time.Time // time-stamps of the first and last samples of the episode
+	From, To int       // CO2 concentrations at the start and end of the episode, in ppm
+	Samples  int       // number of samples of the episode
+
+	Baseline float64 // outdoor CO2 concentration, in ppm
+	ACH      float64 // air changes per hour
+	StdErr   float64 // standard error on ACH
+	Lo, Hi   float64 // confidence interval on ACH
+	R2       float64 // coefficient of determination of the fit
+}
+
+// Duration returns the duration of the episode.
+func (d Decay) Duration() time.Duration {
+	return d.End.Sub(d.Beg)
+}
+
+// Decays finds the episodes of decay of the CO2 concentration in the
+// provided samples, sorted by time, and fits the number of air changes
+// per hour of each of them.
+//
+// Samples with a missing CO2 concentration are skipped, and episodes do
+// not span gaps in the series.
+func Decays(data []aranet4.Data, opts DecayOptions) []Decay {
+	opts.defaults()
+
+	var (
+		out []Decay
+		pts = points(data)
+	)
+	for i := 0; i < len(pts); {
+		j := decayEnd(pts, i, opts)
+		k := decayStart(pts, i, j, opts)
+		if j-k+1 >= 3 {
+			d, ok := fitDecay(pts[k:j+1], opts)
+			if ok {
+				out = append(out, d)
+			}
+		}
+		if j == i {
+			j++
+		}
+		i = j
+	}
+	return out
+}
+
+type point struct {
+	t     time.Time
+	co2   int
+	delta time.Duration // measurement interval
+}
+
+// points returns the samples with a valid CO2 concentration.
+func points(data []aranet4.Data) []point {
+	pts := make([]point, 0, len(data))
+	for _, v := range data {
+		if !v.Valid(aranet4.FieldCO2) {
+			continue
+		}
+		pts = append(pts, point{t: v.Time, co2: v.CO2, delta: v.Interval})
+	}
+	return pts
+}
+
+// decayEnd returns the index of the last sample of the decay starting at
+// index i.
+func decayEnd(pts []point, i int, opts DecayOptions) int {
+	var (
+		end = i
+		low = pts[i].co2 // lowest concentration so far
+	)
+	for j := i + 1; j < len(pts); j++ {
+		if gap(pts[j-1], pts[j]) {
+			break
+		}
+		c := pts[j].co2
+		if float64(c-low) > opts.Noise {
+			break
+		}
+		if float64(c)-opts.Baseline < opts.MinExcess {
+			break
+		}
+		if c < low {
+			low = c
+			end = j
+		}
+	}
+	return end
+}
+
+// decayStart returns the index of the first sample of the decay spanning
+// the samples i to j, skipping the plateau that may precede it.
+func decayStart(pts []point, i, j int, opts DecayOptions) int {
+	var (
+		beg  = i
+		peak = pts[i].co2
+	)
+	for k := i + 1; k <= j; k++ {
+		c := pts[k].co2
+		if c > peak {
+			peak = c
+		}
+		if float64(peak-c) <= opts.Noise {
+			beg = k
+		}
+	}
+	return beg
+}
+
+// gap returns whether samples are missing between a and b.
+func gap(a, b point) bool {
+	dt := b.t.Sub(a.t)
+	if a.delta <= 0 {
+		return dt <= 0
+	}
+	return dt <= 0 || dt > a.delta+a.delta/2
+}
+
+// fitDecay fits the exponential decay of the provided samples, with a
+// linear regression of the logarithm of the excess concentration.
+func fitDecay(pts []point, opts DecayOptions) (Decay, bool) {
+	var (
+		n   = len(pts)
+		beg = pts[0]
+		end = pts[n-1]
+	)
+	if float64(beg.co2-end.co2) < opts.MinDrop {
+		return Decay{}, false
+	}
+	if end.t.Sub(beg.t) < opts.MinDuration {
+		return Decay{}, false
+	}
+
+	xs := make([]float64, n)
+	ys := make([]float64, n)
+	for i, p := range pts {
+		excess := float64(p.co2) - opts.Baseline
+		if excess <= 0 {
+			return Decay{}, false
+		}
+		xs[i] = p.t.Sub(beg.t).Hours()
+		ys[i] = math.Log(excess)
+	}
+
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= float64(n)
+	my /= float64(n)
+
+	var sxx, sxy, syy float64
+	for i := range xs {
+		dx := xs[i] - mx
+		dy := ys[i] - my
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		return Decay{}, false
+	}
+	slope := sxy / sxx
+
+	var ssr float64
+	for i := range xs {
+		r := ys[i] - (my + slope*(xs[i]-mx))
+		ssr += r * r
+	}
+
+	var (
+		dof  = float64(n - 2)
+		serr = math.Sqrt(ssr / dof / sxx)
+		q    = distuv.StudentsT{Mu: 0, Sigma: 1, Nu: dof}.Quantile(0.5 + opts.Confidence/2)
+		ach  = -slope
+		r2   = 1.0
+	)
+	if syy > 0 {
+		r2 = 1 - ssr/syy
+	}
+
+	return Decay{
+		Beg:      beg.t,
+		End:      end.t,
+		From:     beg.co2,
+		To:       end.co2,
+		Samples:  n,
+		Baseline: opts.Baseline,
+		ACH:      ach,
+		StdErr:   serr,
+		Lo:       ach - q*serr,
+		Hi:       ach + q*serr,
+		R2:       r2,
+	}, true
+}
+
+// MeanACH returns the mean number of air changes per hour of the provided
+// episodes, weighted by the inverse of their variance, with its standard
+// error and its confidence interval at the provided level.
+//
+// Episodes fitted without any residual, e.g. from noiseless data, are
+// weighted equally.
+// MeanACH returns NaN values when no episode is provided.
+func MeanACH(ds []Decay, confidence float64) (ach, stderr, lo, hi float64) {
+	if len(ds) == 0 {
+		nan := math.NaN()
+		return nan, nan, nan, nan
+	}
+
+	exact := false
+	for _, d := range ds {
+		if d.StdErr == 0 {
+			exact = true
+			break
+		}
+	}
+
+	var sumw, sum float64
+	for _, d := range ds {
+		w := 1.0
+		if !exact {
+			w = 1 / (d.StdErr * d.StdErr)
+		}
+		sumw += w
+		sum += w * d.ACH
+	}
+	ach = sum / sumw
+	if !exact {
+		stderr = math.Sqrt(1 / sumw)
+	}
+
+	z := distuv.UnitNormal.Quantile(0.5 + confidence/2)
+	return ach, stderr, ach - z*stderr, ach + z*stderr
+}
@@ -0,0 +1,253 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+var t0 = time.Date(2022, 1, 18, 8, 0, 0, 0, time.UTC)
+
+// meeting returns a series of samples of a meeting room, measured every
+// 5 minutes: the CO2 concentration rises from 500 ppm to 1400 ppm in 2
+// hours, then decays toward 420 ppm at the rate of ach air changes per
+// hour, during 4 hours.
+// The concentrations are smeared with a gaussian noise of width sigma.
+func meeting(ach, sigma float64) []aranet4.Data {
+	return meetingWithSeed(ach, sigma, 1234)
+}
+
+func meetingWithSeed(ach, sigma float64, seed int64) []aranet4.Data {
+	const delta = 5 * time.Minute
+	var (
+		rnd = rand.New(rand.NewSource(seed))
+		out []aranet4.Data
+	)
+	for i := 0; i <= 6*12; i++ {
+		var (
+			t = time.Duration(i) * delta
+			c float64
+		)
+		switch h := t.Hours(); {
+		case h <= 2:
+			c = 500 + 900*h/2
+		default:
+			c = 420 + 980*math.Exp(-ach*(h-2))
+		}
+		c += sigma * rnd.NormFloat64()
+		out = append(out, aranet4.Data{
+			Model:    aranet4.ModelAranet4,
+			CO2:      int(math.Round(c)),
+			T:        21,
+			H:        40,
+			P:        1000,
+			Interval: delta,
+			Time:     t0.Add(t),
+		})
+	}
+	return out
+}
+
+func TestDecays(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		data  []aranet4.Data
+		ach   float64
+		tol   float64
+		beg   time.Time
+		nevts int
+	}{
+		{
+			name:  "noiseless",
+			data:  meeting(1.5, 0),
+			ach:   1.5,
+			tol:   0.02,
+			beg:   t0.Add(2 * time.Hour),
+			nevts: 1,
+		},
+		{
+			name:  "noisy",
+			data:  meeting(1.5, 5),
+			ach:   1.5,
+			tol:   0.1,
+			nevts: 1,
+		},
+		{
+			name:  "slow",
+			data:  meeting(0.5, 5),
+			ach:   0.5,
+			tol:   0.05,
+			nevts: 1,
+		},
+		{
+			name: "gap",
+			data: func() []aranet4.Data {
+				vs := meeting(0.5, 0)
+				// drop 30 minutes of samples, 1h after the peak.
+				i := 3 * 12
+				return append(vs[:i:i], vs[i+6:]...)
+			}(),
+			ach:   0.5,
+			tol:   0.02,
+			nevts: 2,
+		},
+		{
+			name: "missing",
+			data: func() []aranet4.Data {
+				vs := meeting(0.5, 0)
+				vs[3*12].Missing = aranet4.FieldCO2
+				vs[3*12].CO2 = 0
+				return vs
+			}(),
+			ach:   0.5,
+			tol:   0.02,
+			nevts: 2,
+		},
+		{
+			name: "plateau",
+			data: func() []aranet4.Data {
+				vs := meeting(1.5, 0)
+				for i := 12; i < 2*12; i++ {
+					vs[i].CO2 = 1400
+				}
+				return vs
+			}(),
+			ach:   1.5,
+			tol:   0.02,
+			beg:   t0.Add(2 * time.Hour),
+			nevts: 1,
+		},
+		{
+			name:  "rising",
+			data:  meeting(1.5, 0)[:2*12],
+			nevts: 0,
+		},
+		{
+			name: "too-small",
+			data: func() []aranet4.Data {
+				vs := meeting(1.5, 0)
+				for i := range vs {
+					vs[i].CO2 = 420 + (vs[i].CO2-420)/10
+				}
+				return vs
+			}(),
+			nevts: 0,
+		},
+		{
+			name:  "too-short",
+			data:  meeting(1.5, 0)[:2*12+5],
+			nevts: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Decays(tc.data, DecayOptions{})
+			if got, want := len(got), tc.nevts; got != want {
+				t.Fatalf("invalid number of episodes: got=%d, want=%d", got, want)
+			}
+			for _, d := range got {
+				if math.Abs(d.ACH-tc.ach) > tc.tol {
+					t.Fatalf("invalid ACH: got=%v, want=%v±%v", d.ACH, tc.ach, tc.tol)
+				}
+				if !(d.Lo <= d.ACH && d.ACH <= d.Hi) {
+					t.Fatalf("invalid confidence interval: got=[%v, %v], ach=%v", d.Lo, d.Hi, d.ACH)
+				}
+				if d.R2 < 0.99 {
+					t.Fatalf("invalid R2: got=%v", d.R2)
+				}
+				if d.From <= d.To {
+					t.Fatalf("invalid episode: from=%d, to=%d", d.From, d.To)
+				}
+				if float64(d.To)-d.Baseline < 50 {
+					t.Fatalf("episode ended too close to baseline: to=%d", d.To)
+				}
+			}
+			if !tc.beg.IsZero() {
+				if got, want := got[0].Beg, tc.beg; !got.Equal(want) {
+					t.Fatalf("invalid episode start: got=%v, want=%v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecaysCoverage(t *testing.T) {
+	const (
+		ach = 1.5
+		n   = 200
+	)
+	opts := DecayOptions{
+		// stay away from the baseline, where the noise of the logarithm
+		// of the excess concentration is no longer gaussian.
+		MinExcess:  200,
+		Confidence: 0.9,
+	}
+
+	var in int
+	for i := 0; i < n; i++ {
+		ds := Decays(meetingWithSeed(ach, 5, int64(i)), opts)
+		if got, want := len(ds), 1; got != want {
+			t.Fatalf("seed=%d: invalid number of episodes: got=%d, want=%d", i, got, want)
+		}
+		if ds[0].Lo <= ach && ach <= ds[0].Hi {
+			in++
+		}
+	}
+	if got := float64(in) / n; got < 0.8 || got > 0.97 {
+		t.Fatalf("invalid coverage of confidence intervals: got=%v, want=0.9", got)
+	}
+}
+
+func TestDecaysBaseline(t *testing.T) {
+	data := meeting(1.5, 0)
+
+	// a wrong baseline biases the fit.
+	ds := Decays(data, DecayOptions{Baseline: 300})
+	if got, want := len(ds), 1; got != want {
+		t.Fatalf("invalid number of episodes: got=%d, want=%d", got, want)
+	}
+	if got := ds[0].ACH; got >= 1.4 {
+		t.Fatalf("invalid ACH with low baseline: got=%v, want<1.4", got)
+	}
+	if got, want := ds[0].Baseline, 300.0; got != want {
+		t.Fatalf("invalid baseline: got=%v, want=%v", got, want)
+	}
+}
+
+func TestMeanACH(t *testing.T) {
+	ach, serr, lo, hi := MeanACH([]Decay{
+		{ACH: 1, StdErr: 0.1},
+		{ACH: 2, StdErr: 0.2},
+	}, 0.95)
+	if got, want := ach, 1.2; math.Abs(got-want) > 1e-12 {
+		t.Fatalf("invalid mean: got=%v, want=%v", got, want)
+	}
+	if got, want := serr, math.Sqrt(1.0/125); math.Abs(got-want) > 1e-12 {
+		t.Fatalf("invalid standard error: got=%v, want=%v", got, want)
+	}
+	if got, want := hi-ach, 1.959964*serr; math.Abs(got-want) > 1e-6 {
+		t.Fatalf("invalid upper bound: got=%v, want=%v", got, want)
+	}
+	if got, want := ach-lo, 1.959964*serr; math.Abs(got-want) > 1e-6 {
+		t.Fatalf("invalid lower bound: got=%v, want=%v", got, want)
+	}
+
+	ach, serr, _, _ = MeanACH([]Decay{{ACH: 1}, {ACH: 2}}, 0.95)
+	if got, want := ach, 1.5; got != want {
+		t.Fatalf("invalid mean: got=%v, want=%v", got, want)
+	}
+	if got, want := serr, 0.0; got != want {
+		t.Fatalf("invalid standard error: got=%v, want=%v", got, want)
+	}
+
+	ach, _, _, _ = MeanACH(nil, 0.95)
+	if !math.IsNaN(ach) {
+		t.Fatalf("invalid mean of no episode: got=%v, want=NaN", ach)
+	}
+}
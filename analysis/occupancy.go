@@ -0,0 +1,109 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+// Indicative CO2 generation rates of one person, in L/s, in the range of
+// those tabulated by Persily and de Jonge (2017).
+const (
+	GenerationSeated = 0.0043 // adult seated, quiet (1.0 met)
+	GenerationOffice = 0.0052 // adult doing office work (1.2 met)
+	GenerationLight  = 0.0080 // adult standing, light activity (2.0 met)
+	GenerationChild  = 0.0030 // child aged 6 to 11, seated (1.2 met)
+)
+
+// OccupancyOptions configures the estimation of the occupancy of a room.
+type OccupancyOptions struct {
+	// Volume is the volume of the room, in m³.
+	Volume float64
+
+	// ACH is the ventilation rate of the room, in air changes per hour.
+	// It can be estimated with Decays and MeanACH.
+	ACH float64
+
+	// Baseline is the outdoor CO2 concentration, in ppm, of the air
+	// supplied to the room (default: DefaultBaseline).
+	Baseline float64
+
+	// Generation is the CO2 generation rate of one person, in L/s
+	// (default: GenerationOffice).
+	Generation float64
+}
+
+func (opts *OccupancyOptions) defaults() {
+	if opts.Baseline <= 0 {
+		opts.Baseline = DefaultBaseline
+	}
+	if opts.Generation <= 0 {
+		opts.Generation = GenerationOffice
+	}
+}
+
+// Occupancy is an estimation of the number of people in a room.
+type Occupancy struct {
+	Time time.Time     // end of the interval of the estimation
+	Span time.Duration // duration of the interval of the estimation
+	N    float64       // mean number of occupants over the interval
+}
+
+// EstimateOccupancy estimates the number of occupants of a room over time,
+// from the CO2 concentrations of the provided samples, sorted by time.
+//
+// It uses a mass-balance model of the room, well mixed, where the CO2
+// generated by N occupants is removed by the ventilation:
+//
+//	V dC/dt = N G - Q (C - Cb)
+//
+// with V the volume of the room, C the CO2 concentration, G the
+// generation rate of one person, Q = ACH V the air flow of the
+// ventilation and Cb the outdoor baseline.
+// Assuming N constant between two consecutive samples, the model is
+// solved exactly over that interval, giving one estimation per interval.
+//
+// Intervals spanning missing samples are skipped.
+// Negative estimations, due to the noise of the sensor, are reported as
+// zero occupants.
+func EstimateOccupancy(data []aranet4.Data, opts OccupancyOptions) ([]Occupancy, error) {
+	opts.defaults()
+	switch {
+	case opts.Volume <= 0 || math.IsInf(opts.Volume, 0) || math.IsNaN(opts.Volume):
+		return nil, fmt.Errorf("analysis: invalid room volume %v", opts.Volume)
+	case opts.ACH <= 0 || math.IsInf(opts.ACH, 0) || math.IsNaN(opts.ACH):
+		return nil, fmt.Errorf("analysis: invalid ventilation rate %v", opts.ACH)
+	}
+
+	var (
+		pts = points(data)
+		out = make([]Occupancy, 0, len(pts))
+		q   = opts.ACH * opts.Volume        // air flow, in m³/h
+		g   = opts.Generation * 3600 * 1e-3 // generation rate, in m³/h
+	)
+	for i := 1; i < len(pts); i++ {
+		p0, p1 := pts[i-1], pts[i]
+		if gap(p0, p1) {
+			continue
+		}
+		var (
+			dt = p1.t.Sub(p0.t)
+			x  = math.Exp(-opts.ACH * dt.Hours())
+			// steady-state concentration reached with the occupants of
+			// the interval.
+			css = (float64(p1.co2) - float64(p0.co2)*x) / (1 - x)
+			n   = (css - opts.Baseline) * 1e-6 * q / g
+		)
+		if n < 0 {
+			n = 0
+		}
+		out = append(out, Occupancy{Time: p1.t, Span: dt, N: n})
+	}
+	return out, nil
+}
@@ -0,0 +1,150 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+// room returns the samples of a room of volume v, ventilated at ach air
+// changes per hour, with the number of occupants given by occ for each
+// 5 minutes interval.
+func room(v, ach float64, occ []float64) []aranet4.Data {
+	const delta = 5 * time.Minute
+	var (
+		c   = float64(DefaultBaseline)
+		g   = GenerationOffice * 3600 * 1e-3
+		x   = math.Exp(-ach * delta.Hours())
+		out = make([]aranet4.Data, 0, len(occ)+1)
+	)
+	add := func(t time.Time) {
+		out = append(out, aranet4.Data{
+			Model:    aranet4.ModelAranet4,
+			CO2:      int(math.Round(c)),
+			Interval: delta,
+			Time:     t,
+		})
+	}
+	add(t0)
+	for i, n := range occ {
+		css := DefaultBaseline + 1e6*n*g/(ach*v)
+		c = css + (c-css)*x
+		add(t0.Add(time.Duration(i+1) * delta))
+	}
+	return out
+}
+
+func TestEstimateOccupancy(t *testing.T) {
+	var occ []float64
+	for _, v := range []struct {
+		n   float64
+		dur time.Duration
+	}{
+		{0, 1 * time.Hour},
+		{6, 2 * time.Hour},
+		{2, 1 * time.Hour},
+		{0, 2 * time.Hour},
+	} {
+		for i := 0; i < int(v.dur/(5*time.Minute)); i++ {
+			occ = append(occ, v.n)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []aranet4.Data
+		want []float64
+	}{
+		{
+			name: "full",
+			data: room(50, 2, occ),
+			want: occ,
+		},
+		{
+			name: "gap",
+			data: func() []aranet4.Data {
+				vs := room(50, 2, occ)
+				return append(vs[:20:20], vs[21:]...)
+			}(),
+			want: append(occ[:19:19], occ[21:]...),
+		},
+		{
+			name: "missing",
+			data: func() []aranet4.Data {
+				vs := room(50, 2, occ)
+				vs[20].Missing = aranet4.FieldCO2
+				return vs
+			}(),
+			want: append(occ[:19:19], occ[21:]...),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := EstimateOccupancy(tc.data, OccupancyOptions{Volume: 50, ACH: 2})
+			if err != nil {
+				t.Fatalf("could not estimate occupancy: %+v", err)
+			}
+			if got, want := len(got), len(tc.want); got != want {
+				t.Fatalf("invalid number of estimations: got=%d, want=%d", got, want)
+			}
+			for i, v := range got {
+				if math.Abs(v.N-tc.want[i]) > 0.1 {
+					t.Fatalf("invalid occupancy at %v: got=%v, want=%v", v.Time, v.N, tc.want[i])
+				}
+				if v.N < 0 {
+					t.Fatalf("negative occupancy at %v: %v", v.Time, v.N)
+				}
+				if got, want := v.Span, 5*time.Minute; got != want {
+					t.Fatalf("invalid span: got=%v, want=%v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEstimateOccupancyGeneration(t *testing.T) {
+	data := room(50, 2, []float64{4, 4, 4, 4})
+
+	// children generate less CO2: more of them are needed for the same rise.
+	got, err := EstimateOccupancy(data, OccupancyOptions{
+		Volume:     50,
+		ACH:        2,
+		Generation: GenerationChild,
+	})
+	if err != nil {
+		t.Fatalf("could not estimate occupancy: %+v", err)
+	}
+	want := 4 * GenerationOffice / GenerationChild
+	for _, v := range got {
+		if math.Abs(v.N-want) > 0.2 {
+			t.Fatalf("invalid occupancy: got=%v, want=%v", v.N, want)
+		}
+	}
+}
+
+func TestEstimateOccupancyErrors(t *testing.T) {
+	for _, tc := range []struct {
+		opts OccupancyOptions
+		want string
+	}{
+		{OccupancyOptions{ACH: 1}, "analysis: invalid room volume 0"},
+		{OccupancyOptions{Volume: -1, ACH: 1}, "analysis: invalid room volume -1"},
+		{OccupancyOptions{Volume: 10}, "analysis: invalid ventilation rate 0"},
+		{OccupancyOptions{Volume: 10, ACH: math.NaN()}, "analysis: invalid ventilation rate NaN"},
+	} {
+		t.Run(tc.want, func(t *testing.T) {
+			_, err := EstimateOccupancy(nil, tc.opts)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.want; got != want {
+				t.Fatalf("invalid error: got=%q, want=%q", got, want)
+			}
+		})
+	}
+}
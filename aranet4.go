@@ -17,15 +17,18 @@ const (
 
 const (
 	uuidDeviceService          = "f0cd1400-95da-4f4b-9ac8-aa55d312af0c"
+	uuidReadSettings           = "f0cd1401-95da-4f4b-9ac8-aa55d312af0c"
 	uuidWriteCmd               = "f0cd1402-95da-4f4b-9ac8-aa55d312af0c"
 	uuidReadSample             = "f0cd1503-95da-4f4b-9ac8-aa55d312af0c"
 	uuidReadAll                = "f0cd3001-95da-4f4b-9ac8-aa55d312af0c"
+	uuidReadAllDetailed        = "f0cd3003-95da-4f4b-9ac8-aa55d312af0c"
 	uuidReadInterval           = "f0cd2002-95da-4f4b-9ac8-aa55d312af0c"
 	uuidReadTimeSeries         = "f0cd2003-95da-4f4b-9ac8-aa55d312af0c"
 	uuidReadSecondsSinceUpdate = "f0cd2004-95da-4f4b-9ac8-aa55d312af0c"
 	uuidReadTotalReadings      = "f0cd2001-95da-4f4b-9ac8-aa55d312af0c"
 
-	uuidGenericService = "00001800-0000-1000-8000-00805f9b34fb"
+	uuidGenericService        = "00001800-0000-1000-8000-00805f9b34fb"
+	uuidGenericReadDeviceName = "00002a00-0000-1000-8000-00805f9b34fb"
 
 	uuidCommonService              = "0000180a-0000-1000-8000-00805f9b34fb"
 	uuidCommonReadManufacturerName = "00002a29-0000-1000-8000-00805f9b34fb"
@@ -33,16 +36,48 @@ const (
 	uuidCommonReadSerialNumber     = "00002a25-0000-1000-8000-00805f9b34fb"
 	uuidCommonReadHWRevision       = "00002a27-0000-1000-8000-00805f9b34fb"
 	uuidCommonReadSWRevision       = "00002a28-0000-1000-8000-00805f9b34fb"
-	uuidCommonReadBattery          = "00002a19-0000-1000-8000-00805f9b34fb"
+
+	uuidBatteryService    = "0000180f-0000-1000-8000-00805f9b34fb"
+	uuidCommonReadBattery = "00002a19-0000-1000-8000-00805f9b34fb"
 )
 
+// Param identifies a measured quantity in the history of a device.
+type Param uint8
+
 const (
-	paramT   = 1
-	paramH   = 2
-	paramP   = 3
-	paramCO2 = 4
+	ParamT        Param = 1  // temperature
+	ParamH        Param = 2  // relative humidity, in 1% steps (Aranet4)
+	ParamP        Param = 3  // atmospheric pressure
+	ParamCO2      Param = 4  // CO2 level
+	ParamH2       Param = 5  // relative humidity, in 0.1% steps
+	ParamDoseRate Param = 8  // radiation dose rate
+	ParamDose     Param = 9  // total radiation dose
+	ParamRadon    Param = 10 // radon concentration
 )
 
+func (p Param) String() string {
+	switch p {
+	case ParamT:
+		return "T"
+	case ParamH:
+		return "H"
+	case ParamP:
+		return "P"
+	case ParamCO2:
+		return "CO2"
+	case ParamH2:
+		return "H2"
+	case ParamDoseRate:
+		return "DoseRate"
+	case ParamDose:
+		return "Dose"
+	case ParamRadon:
+		return "Radon"
+	default:
+		return fmt.Sprintf("Param(%d)", uint8(p))
+	}
+}
+
 var (
 	// ErrNoData indicates a missing data point.
 	// This may happen during sensor calibration.
@@ -50,55 +85,149 @@ var (
 )
 
 // Quality gives a general assessment of air quality (green/yellow/red).
-//  - green:  [   0 - 1000) ppm
-//  - yellow: [1000 - 1400) ppm
-//  - red:    [1400 -  ...) ppm
+// The zero Quality denotes an unknown assessment.
+//
+// Samples are assessed with a Policy.
+// Devices also report a status for their current readings, assessed with
+// the fixed thresholds of their firmware.
 type Quality int
 
+// Levels of air quality.
+const (
+	QualityGreen  Quality = 1 // good
+	QualityYellow Quality = 2 // acceptable
+	QualityRed    Quality = 3 // poor
+)
+
 func (st Quality) String() string {
 	switch st {
-	case 1:
+	case QualityGreen:
 		return "green"
-	case 2:
+	case QualityYellow:
 		return "yellow"
-	case 3:
+	case QualityRed:
 		return "red"
 	default:
 		return fmt.Sprintf("Quality(%d)", int(st))
 	}
 }
 
-func qualityFrom(co2 int) Quality {
-	switch {
-	case co2 < 1000:
-		return 1
-	case co2 < 1400:
-		return 2
-	default:
-		return 3
+// Field identifies a measured quantity of a data sample.
+// Fields may be combined into a bit set.
+type Field uint8
+
+const (
+	FieldT        Field = 1 << iota // temperature
+	FieldH                          // relative humidity
+	FieldP                          // atmospheric pressure
+	FieldCO2                        // CO2 level
+	FieldRadon                      // radon concentration
+	FieldDoseRate                   // radiation dose rate
+	FieldDose                       // total radiation dose
+)
+
+func (f Field) String() string {
+	var names []string
+	for _, v := range []struct {
+		f    Field
+		name string
+	}{
+		{FieldT, "T"},
+		{FieldH, "H"},
+		{FieldP, "P"},
+		{FieldCO2, "CO2"},
+		{FieldRadon, "Radon"},
+		{FieldDoseRate, "DoseRate"},
+		{FieldDose, "Dose"},
+	} {
+		if f&v.f != 0 {
+			names = append(names, v.name)
+			f &^= v.f
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("Field(0x%x)", uint8(f)))
 	}
+	return strings.Join(names, "|")
 }
 
-// Data holds measured data samples provided by Aranet4.
+// Data holds measured data samples provided by Aranet devices.
+// The quantities measured by each model of device are given by
+// Model.Fields.
 type Data struct {
 	H, P, T float64
 	CO2     int
 	Battery int
-	Quality Quality
+	Quality Quality // air quality, assessed with a Policy
+	Status  Quality // air quality reported by the device (zero if not reported)
+
+	Radon    int     // radon concentration, in Bq/m³
+	DoseRate float64 // radiation dose rate, in µSv/h
+	Dose     float64 // total radiation dose, in mSv
+
+	Model Model // model of the device
 
 	Interval time.Duration
 	Time     time.Time
+
+	// Missing holds the fields the device flagged as not available,
+	// e.g. during sensor calibration.
+	// The values of missing fields are zero.
+	Missing Field
+}
+
+// Valid returns whether all the provided fields hold a measured value.
+// Fields that are not measured by the model of the device are never
+// valid.
+func (data Data) Valid(f Field) bool {
+	return data.Model.Fields()&f == f && data.Missing&f == 0
 }
 
 func (data Data) String() string {
-	var o strings.Builder
-	fmt.Fprintf(&o, "CO2:         %d ppm\n", data.CO2)
-	fmt.Fprintf(&o, "temperature: %g°C\n", data.T)
-	fmt.Fprintf(&o, "pressure:    %g hPa\n", data.P)
-	fmt.Fprintf(&o, "humidity:    %g%%\n", data.H)
-	fmt.Fprintf(&o, "quality:     %v\n", data.Quality)
+	var (
+		o      strings.Builder
+		fields = data.Model.Fields()
+	)
+	for _, v := range []struct {
+		f      Field
+		label  string
+		format string
+		value  interface{}
+	}{
+		{FieldCO2, "CO2:        ", "%d ppm", data.CO2},
+		{FieldRadon, "radon:      ", "%d Bq/m³", data.Radon},
+		{FieldDoseRate, "dose rate:  ", "%g µSv/h", data.DoseRate},
+		{FieldDose, "total dose: ", "%g mSv", data.Dose},
+		{FieldT, "temperature:", "%g°C", data.T},
+		{FieldP, "pressure:   ", "%g hPa", data.P},
+		{FieldH, "humidity:   ", "%g%%", data.H},
+	} {
+		switch {
+		case fields&v.f == 0:
+			continue
+		case !data.Valid(v.f):
+			fmt.Fprintf(&o, "%s n/a\n", v.label)
+		default:
+			fmt.Fprintf(&o, "%s "+v.format+"\n", v.label, v.value)
+		}
+	}
+	if fields&(FieldCO2|FieldRadon) != 0 {
+		switch data.Quality {
+		case 0:
+			fmt.Fprintf(&o, "quality:     n/a\n")
+		default:
+			fmt.Fprintf(&o, "quality:     %v\n", data.Quality)
+		}
+	}
 	fmt.Fprintf(&o, "battery:     %d%%\n", data.Battery)
 	fmt.Fprintf(&o, "interval:    %v\n", data.Interval)
 	fmt.Fprintf(&o, "time-stamp:  %v\n", data.Time.UTC().Format(timeFmt))
 	return o.String()
 }
+
+// Describe is like String, but assesses the air quality of the sample
+// with the provided policy.
+func (data Data) Describe(p Policy) string {
+	data.Quality = p.Classify(data)
+	return data.String()
+}
@@ -0,0 +1,381 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// btTransport is a Transport backed by tinygo.org/x/bluetooth.
+type btTransport struct {
+	dev  *bluetooth.Device
+	name string
+}
+
+var (
+	// adapterLock serializes the scans and connection attempts on the
+	// default Bluetooth adapter, which can only run one of them at a time.
+	adapterLock = make(chan struct{}, 1)
+
+	// adapterYield asks the scan holding the default adapter, if any, to
+	// release it for a connection attempt.
+	adapterYield = make(chan struct{}, 1)
+)
+
+// acquireAdapter waits until the default adapter is available, and
+// returns the function releasing it.
+// An on-going scan is paused until the adapter is released, so that
+// connection attempts do not wait for the end of the scan.
+func acquireAdapter(ctx context.Context) (func(), error) {
+	select {
+	case adapterLock <- struct{}{}:
+		return releaseAdapter, nil
+	default:
+	}
+	select {
+	case adapterYield <- struct{}{}:
+	default:
+	}
+	return lockAdapter(ctx)
+}
+
+// lockAdapter waits until the default adapter is available, without
+// interrupting an on-going scan, and returns the function releasing it.
+func lockAdapter(ctx context.Context) (func(), error) {
+	select {
+	case adapterLock <- struct{}{}:
+		return releaseAdapter, nil
+	case <-ctx.Done():
+		return nil, ctxError(ctx)
+	}
+}
+
+func releaseAdapter() { <-adapterLock }
+
+func dialBluetooth(ctx context.Context, addr string) (*btTransport, error) {
+	release, err := acquireAdapter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+
+	ad := bluetooth.DefaultAdapter
+	err = ad.Enable()
+	if err != nil {
+		return nil, fmt.Errorf("could not set default adapter power: %w", err)
+	}
+
+	var (
+		found bluetooth.ScanResult
+		once  sync.Once
+		stop  = func() {
+			once.Do(func() { _ = ad.StopScan() })
+		}
+		errc = make(chan error, 1)
+	)
+	go func() {
+		errc <- ad.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
+			if result.Address.String() != addr {
+				return
+			}
+			found = result
+			// Stop the scan.
+			stop()
+		})
+	}()
+
+	select {
+	case err = <-errc:
+		if err != nil {
+			return nil, fmt.Errorf("could not start a scan %q: %w", addr, err)
+		}
+	case <-ctx.Done():
+		stopScan(ad.StopScan, errc)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &kindError{kind: ErrDeviceNotFound, err: fmt.Errorf("%q: %w", addr, ctx.Err())}
+		}
+		return nil, ctx.Err()
+	}
+
+	type result struct {
+		dev *bluetooth.Device
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		dev, err := ad.Connect(found.Address, bluetooth.ConnectionParams{})
+		done <- result{dev, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, fmt.Errorf("could not connect to %q: %w", addr, res.err)
+		}
+		return &btTransport{dev: res.dev, name: found.LocalName()}, nil
+	case <-ctx.Done():
+		rel := release
+		release = nil
+		go func() {
+			// disconnect once the connection attempt completes.
+			defer rel()
+			res := <-done
+			if res.err == nil {
+				_ = res.dev.Disconnect()
+			}
+		}()
+		return nil, ctxError(ctx)
+	}
+}
+
+func (bt *btTransport) DiscoverService(uuid string) (Service, error) {
+	id, err := bluetooth.ParseUUID(uuid)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse service UUID %q: %w", uuid, err)
+	}
+
+	svcs, err := bt.dev.DiscoverServices([]bluetooth.UUID{id})
+	if err != nil {
+		return nil, fmt.Errorf("could not discover service %q: %w", uuid, err)
+	}
+	for i := range svcs {
+		if svcs[i].UUID() == id {
+			return &btService{svc: &svcs[i]}, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find service %q", uuid)
+}
+
+func (bt *btTransport) Close() error {
+	return bt.dev.Disconnect()
+}
+
+type btService struct {
+	svc *bluetooth.DeviceService
+}
+
+func (svc *btService) UUID() string {
+	return svc.svc.UUID().String()
+}
+
+func (svc *btService) DiscoverCharacteristic(uuid string) (Characteristic, error) {
+	id, err := bluetooth.ParseUUID(uuid)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse characteristic UUID %q: %w", uuid, err)
+	}
+
+	chars, err := svc.svc.DiscoverCharacteristics([]bluetooth.UUID{id})
+	if err != nil {
+		return nil, fmt.Errorf("could not discover characteristic %q: %w", uuid, err)
+	}
+	for i := range chars {
+		if chars[i].UUID() == id {
+			return &btCharacteristic{c: chars[i]}, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find characteristic %q", uuid)
+}
+
+type btCharacteristic struct {
+	c bluetooth.DeviceCharacteristic
+
+	mu     sync.Mutex
+	cb     func(p []byte)
+	notify bool // whether notifications have been enabled on the device
+}
+
+func (c *btCharacteristic) UUID() string {
+	return c.c.UUID().String()
+}
+
+func (c *btCharacteristic) Read(p []byte) (int, error) {
+	return c.c.Read(p)
+}
+
+func (c *btCharacteristic) WriteWithoutResponse(p []byte) (int, error) {
+	return c.c.WriteWithoutResponse(p)
+}
+
+func (c *btCharacteristic) EnableNotifications(cb func(p []byte)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cb = cb
+	if c.notify || cb == nil {
+		// tinygo/bluetooth registers a new watcher for each call to
+		// EnableNotifications, and can not disable them.
+		// Enable them once and dispatch to the current callback.
+		return nil
+	}
+
+	err := c.c.EnableNotifications(c.dispatch)
+	if err != nil {
+		return err
+	}
+	c.notify = true
+	return nil
+}
+
+func (c *btCharacteristic) dispatch(p []byte) {
+	c.mu.Lock()
+	cb := c.cb
+	c.mu.Unlock()
+
+	if cb == nil {
+		return
+	}
+	cb(p)
+}
+
+var (
+	_ Transport      = (*btTransport)(nil)
+	_ Service        = (*btService)(nil)
+	_ Characteristic = (*btCharacteristic)(nil)
+)
+
+// btScanner scans for BLE advertisements with the default adapter.
+type btScanner struct{}
+
+func (btScanner) scan(ctx context.Context, f func(adv advertisement)) error {
+	return shareScan(ctx, btAdapter{bluetooth.DefaultAdapter}, f)
+}
+
+// scanAdapter is a Bluetooth adapter scanning for advertisements.
+type scanAdapter interface {
+	// scan calls f for each received advertisement, until stopScan is
+	// called.
+	scan(f func(adv advertisement)) error
+	stopScan() error
+}
+
+// shareScan scans for advertisements with the default adapter until the
+// context is done.
+//
+// The scan is paused whenever a connection attempt acquires the adapter,
+// and resumed once the adapter is released.
+// f is called outside of the scan callback of the adapter, so it may
+// itself connect to a device.
+func shareScan(ctx context.Context, ad scanAdapter, f func(adv advertisement)) error {
+	var (
+		advs = make(chan advertisement)
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for adv := range advs {
+			f(adv)
+		}
+	}()
+	defer func() {
+		close(advs)
+		<-done
+	}()
+
+	for {
+		release, err := lockAdapter(ctx)
+		if err != nil {
+			return ctx.Err()
+		}
+		yield, err := scanUntil(ctx, ad, advs)
+		release()
+		if !yield {
+			return err
+		}
+	}
+}
+
+// scanUntil scans with the adapter, forwarding advertisements to advs,
+// until the context is done, the scan fails or a connection attempt
+// requests the adapter.
+// scanUntil reports whether the scan was stopped to yield the adapter.
+func scanUntil(ctx context.Context, ad scanAdapter, advs chan<- advertisement) (bool, error) {
+	var (
+		quit = make(chan struct{})
+		errc = make(chan error, 1)
+	)
+	go func() {
+		errc <- ad.scan(func(adv advertisement) {
+			select {
+			case advs <- adv:
+			case <-quit:
+			}
+		})
+	}()
+
+	select {
+	case err := <-errc:
+		return false, err
+	case <-adapterYield:
+		close(quit)
+		stopScan(ad.stopScan, errc)
+		return true, nil
+	case <-ctx.Done():
+		close(quit)
+		stopScan(ad.stopScan, errc)
+		return false, ctx.Err()
+	}
+}
+
+// btAdapter is a scanAdapter backed by tinygo.org/x/bluetooth.
+type btAdapter struct {
+	ad *bluetooth.Adapter
+}
+
+func (bt btAdapter) scan(f func(adv advertisement)) error {
+	err := bt.ad.Enable()
+	if err != nil {
+		return fmt.Errorf("could not set default adapter power: %w", err)
+	}
+
+	svc := toUUID(uuidDeviceService)
+	return bt.ad.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
+		adv := advertisement{
+			addr:   result.Address.String(),
+			name:   result.LocalName(),
+			rssi:   int(result.RSSI),
+			aranet: result.HasServiceUUID(svc),
+		}
+		switch raw := result.Bytes(); {
+		case raw != nil:
+			// copy out: the payload may be reused.
+			adv.mfr = append([]byte(nil), manufacturerData(raw)...)
+		case isAranet(adv):
+			adv.mfr = bluezManufacturerData(adv.addr)
+		}
+		f(adv)
+	})
+}
+
+func (bt btAdapter) stopScan() error {
+	return bt.ad.StopScan()
+}
+
+// stopScan stops the on-going scan of an adapter, and waits for the scan
+// to report its completion on errc.
+func stopScan(stop func() error, errc <-chan error) {
+	// the scan may not have started yet: retry until it stops.
+	for {
+		_ = stop()
+		select {
+		case <-errc:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func toUUID(str string) bluetooth.UUID {
+	u, _ := bluetooth.ParseUUID(str)
+	return u
+}
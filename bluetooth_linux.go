@@ -0,0 +1,42 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package aranet4
+
+import (
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/muka/go-bluetooth/bluez/profile/adapter"
+	"github.com/muka/go-bluetooth/bluez/profile/device"
+)
+
+// bluezManufacturerData returns the SAF Tehnika manufacturer data BlueZ
+// recorded for the device with the provided address, or nil.
+//
+// On Linux, tinygo.org/x/bluetooth does not expose the advertisement
+// payload, so it is retrieved from the BlueZ device object.
+func bluezManufacturerData(addr string) []byte {
+	path := dbus.ObjectPath(
+		"/org/bluez/" + adapter.GetDefaultAdapterID() +
+			"/dev_" + strings.Replace(addr, ":", "_", -1),
+	)
+	dev, err := device.NewDevice1(path)
+	if err != nil || dev.Properties == nil {
+		return nil
+	}
+
+	v, ok := dev.Properties.ManufacturerData[companyID]
+	if !ok {
+		return nil
+	}
+	if vv, ok := v.(dbus.Variant); ok {
+		v = vv.Value()
+	}
+	p, _ := v.([]byte)
+	return p
+}
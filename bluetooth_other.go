@@ -0,0 +1,14 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package aranet4
+
+// bluezManufacturerData returns nil: the advertisement payload is directly
+// available from tinygo.org/x/bluetooth scan results on this platform.
+func bluezManufacturerData(addr string) []byte {
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAdapter is a scanAdapter receiving an advertisement every
+// millisecond.
+type fakeAdapter struct {
+	mu     sync.Mutex
+	stop   chan struct{} // closed to stop the on-going scan
+	starts int           // number of started scans
+}
+
+func (ad *fakeAdapter) scan(f func(adv advertisement)) error {
+	ad.mu.Lock()
+	stop := make(chan struct{})
+	ad.stop = stop
+	ad.starts++
+	ad.mu.Unlock()
+
+	tck := time.NewTicker(time.Millisecond)
+	defer tck.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-tck.C:
+			f(advertisement{addr: "F5:6C:BE:D5:61:47", aranet: true})
+		}
+	}
+}
+
+func (ad *fakeAdapter) stopScan() error {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	if ad.stop != nil {
+		close(ad.stop)
+		ad.stop = nil
+	}
+	return nil
+}
+
+func TestShareScan(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		ad = new(fakeAdapter)
+		n  = 0
+	)
+	err := shareScan(ctx, ad, func(adv advertisement) {
+		n++
+		switch n {
+		case 1:
+			// connect from within the callback, as aranet4-srv does to
+			// backfill the history of a device it listens to.
+			ctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			release, err := acquireAdapter(ctx)
+			if err != nil {
+				t.Errorf("could not acquire adapter held by scan: %+v", err)
+				return
+			}
+			defer release()
+			ad.mu.Lock()
+			defer ad.mu.Unlock()
+			if ad.stop != nil {
+				t.Errorf("scan not stopped while adapter is acquired")
+			}
+		case 3:
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, context.Canceled)
+	}
+
+	if got, want := ad.starts, 2; got != want {
+		t.Fatalf("invalid number of scans: got=%d, want=%d", got, want)
+	}
+
+	// the adapter is released once the scan is over.
+	release, err := lockAdapter(context.Background())
+	if err != nil {
+		t.Fatalf("could not acquire adapter: %+v", err)
+	}
+	release()
+}
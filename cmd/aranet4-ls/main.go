@@ -5,13 +5,16 @@
 package main // import "sbinet.org/x/aranet4/cmd/aranet4-ls"
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"time"
 
 	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/encoding"
 )
 
 func main() {
@@ -21,14 +24,39 @@ func main() {
 	var (
 		addr    = flag.String("addr", "F5:6C:BE:D5:61:47", "MAC address of Aranet4")
 		verbose = flag.Bool("v", false, "enable verbose mode")
+		timeout = flag.Duration("timeout", 2*time.Minute, "timeout for the whole session with the device")
+		scan    = flag.Duration("scan", 0, "scan for nearby Aranet devices during the provided duration and exit")
 
 		doTimeSeries = flag.Bool("ts", false, "fetch time series")
 		oname        = flag.String("o", "", "path to output file for time series")
+		oformat      = flag.String("fmt", "csv", "output format for time series (csv, ndjson or influx)")
 	)
 
 	flag.Parse()
 
-	dev, err := aranet4.New(*addr)
+	format, err := encoding.ParseFormat(*oformat)
+	if err != nil {
+		log.Fatalf("invalid output format: %+v", err)
+	}
+
+	if *scan > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *scan)
+		defer cancel()
+
+		devs, err := aranet4.Scan(ctx, aranet4.ScanOptions{})
+		if err != nil {
+			log.Fatalf("could not scan for devices: %+v", err)
+		}
+		for _, dev := range devs {
+			fmt.Printf("%s\t%-8v\t%4d dBm\t%q\n", dev.Addr, dev.Model, dev.RSSI, dev.Name)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	dev, err := aranet4.NewContext(ctx, *addr)
 	if err != nil {
 		log.Fatalf("could not create aranet4 client: %+v", err)
 	}
@@ -41,14 +69,14 @@ func main() {
 		}
 		log.Printf("name: %q", name)
 
-		vers, err := dev.Version()
+		info, err := dev.InfoContext(ctx)
 		if err != nil {
-			log.Fatalf("could not get device version: %+v", err)
+			log.Fatalf("could not get device information: %+v", err)
 		}
-		log.Printf("vers: %q", vers)
+		log.Printf("info:\n%v", info)
 	}
 
-	data, err := dev.Read()
+	data, err := dev.ReadContext(ctx)
 	if err != nil {
 		log.Fatalf("could not run client: %+v", err)
 	}
@@ -73,16 +101,13 @@ func main() {
 			}
 		}
 
-		vs, err := dev.ReadAll()
+		vs, err := dev.ReadAllContext(ctx)
 		if err != nil {
 			log.Fatalf("could not read data: %+v", err)
 		}
-		fmt.Fprintf(w, "id;timestamp (UTC);temperature (°C);humidity (%%);pressure (hPa);CO2 (ppm)\n")
-		for i, v := range vs {
-			fmt.Fprintf(w, "%d;%s;%.2f;%g;%.1f;%d\n",
-				i, v.Time.Format("2006-01-02 15:04:05"),
-				v.T, v.H, v.P, v.CO2,
-			)
+		err = encoding.WriteAll(w, format, vs)
+		if err != nil {
+			log.Fatalf("could not write data: %+v", err)
 		}
 		err = flush()
 		if err != nil {
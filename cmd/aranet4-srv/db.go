@@ -5,21 +5,31 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
-	"io"
 	"log"
 	"sort"
 	"time"
 
 	"go.etcd.io/bbolt"
 	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/emu"
 )
 
 const (
 	timeResolution int64 = 5 // seconds
+
+	bleTimeout = 2 * time.Minute // maximum duration of a session with the sensor
+
+	plotWindow int64 = 24 * 60 * 60 // default time range of the plots, in seconds
 )
 
+// ltApprox returns whether a was measured before b.
+// Time-stamps of the same measurement are identical within a session
+// with the device, but may still differ by a second or so across
+// sessions, e.g. after a restart of the server.
 func ltApprox(a, b aranet4.Data) bool {
 	at := a.Time.UTC().Unix()
 	bt := b.Time.UTC().Unix()
@@ -38,8 +48,34 @@ func abs(v int64) int64 {
 
 var (
 	bucketData = []byte("aranet4")
+	bucketMeta = []byte("meta")
+
+	keyVersion = []byte("version") // version of the db layout, in the meta bucket
 )
 
+// migrations upgrade the layout of the db, from the version of their index
+// to the next one.
+var migrations = []func(tx *bbolt.Tx) error{
+	migrateVersionedRecords,
+	migrateSortableKeys,
+}
+
+// dbVersion is the current version of the db layout.
+var dbVersion = len(migrations)
+
+// recordKey returns the key of the record of a sample measured at the
+// provided time, in seconds since the Unix epoch.
+// Keys are big endian, so that the order of keys in db is the time order
+// of the samples.
+func recordKey(unix int64) []byte {
+	if unix < 0 {
+		unix = 0
+	}
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(unix))
+	return k
+}
+
 func (srv *server) init() error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
@@ -53,7 +89,7 @@ func (srv *server) init() error {
 			return fmt.Errorf("could not create %q bucket", bucketData)
 		}
 
-		return nil
+		return migrate(tx)
 	})
 	if err != nil {
 		return fmt.Errorf("could not setup aranet4 db buckets: %w", err)
@@ -64,23 +100,18 @@ func (srv *server) init() error {
 		if bkt == nil {
 			return fmt.Errorf("could not find %q bucket", bucketData)
 		}
-		return bkt.ForEach(func(k, v []byte) error {
-			id := int64(binary.LittleEndian.Uint64(k))
-			if id-srv.last.Time.UTC().Unix() > timeResolution {
-				return unmarshalBinary(&srv.last, v)
-			}
+		k, v := bkt.Cursor().Last()
+		if k == nil {
 			return nil
-		})
+		}
+		return unmarshalBinary(&srv.last, v)
 	})
 	if err != nil {
 		return fmt.Errorf("could not find last data sample: %w", err)
 	}
+	srv.last.Quality = srv.policy.Classify(srv.last)
 
-	var (
-		beg int64 = 0
-		end int64 = -1
-	)
-	data, err := srv.rows(beg, end)
+	data, err := srv.rows(srv.window(-1, -1))
 	if err != nil {
 		return fmt.Errorf("could not read data from db: %w", err)
 	}
@@ -93,6 +124,130 @@ func (srv *server) init() error {
 	return nil
 }
 
+// migrate upgrades the layout of the db to the current version.
+func migrate(tx *bbolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+	if err != nil {
+		return fmt.Errorf("could not create %q bucket: %w", bucketMeta, err)
+	}
+
+	version := 0
+	if v := meta.Get(keyVersion); v != nil {
+		version = int(binary.LittleEndian.Uint64(v))
+	}
+	switch {
+	case version == dbVersion:
+		return nil
+	case version > dbVersion:
+		return fmt.Errorf("db version %d is newer than supported version %d", version, dbVersion)
+	}
+
+	for i := version; i < dbVersion; i++ {
+		log.Printf("migrating db from version %d to %d...", i, i+1)
+		err = migrations[i](tx)
+		if err != nil {
+			return fmt.Errorf("could not migrate db from version %d to %d: %w", i, i+1, err)
+		}
+	}
+
+	v := make([]byte, 8)
+	binary.LittleEndian.PutUint64(v, uint64(dbVersion))
+	err = meta.Put(keyVersion, v)
+	if err != nil {
+		return fmt.Errorf("could not store db version: %w", err)
+	}
+	return nil
+}
+
+// migrateVersionedRecords rewrites legacy records as versioned records.
+func migrateVersionedRecords(tx *bbolt.Tx) error {
+	bkt := tx.Bucket(bucketData)
+	if bkt == nil {
+		return fmt.Errorf("could not find %q bucket", bucketData)
+	}
+
+	type record struct {
+		key []byte
+		val []byte
+	}
+	var recs []record
+	err := bkt.ForEach(func(k, v []byte) error {
+		if len(v) != legacySize {
+			return nil
+		}
+		var data aranet4.Data
+		err := unmarshalLegacy(&data, v)
+		if err != nil {
+			return fmt.Errorf("could not decode legacy record %x: %w", k, err)
+		}
+		buf := make([]byte, recordSize)
+		err = marshalBinary(data, buf)
+		if err != nil {
+			return fmt.Errorf("could not encode record %x: %w", k, err)
+		}
+		// keys and values are only valid during the transaction.
+		recs = append(recs, record{key: append([]byte(nil), k...), val: buf})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// buckets can not be modified while iterating over them.
+	for _, rec := range recs {
+		err = bkt.Put(rec.key, rec.val)
+		if err != nil {
+			return fmt.Errorf("could not store record %x: %w", rec.key, err)
+		}
+	}
+	return nil
+}
+
+// migrateSortableKeys rewrites the little endian keys of records as big
+// endian keys.
+func migrateSortableKeys(tx *bbolt.Tx) error {
+	bkt := tx.Bucket(bucketData)
+	if bkt == nil {
+		return fmt.Errorf("could not find %q bucket", bucketData)
+	}
+
+	type record struct {
+		key []byte
+		val []byte
+	}
+	var recs []record
+	err := bkt.ForEach(func(k, v []byte) error {
+		if len(k) != 8 {
+			return fmt.Errorf("invalid record key %x", k)
+		}
+		// keys and values are only valid during the transaction.
+		recs = append(recs, record{
+			key: append([]byte(nil), k...),
+			val: append([]byte(nil), v...),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// old and new keys may collide: remove all old keys first.
+	for _, rec := range recs {
+		err = bkt.Delete(rec.key)
+		if err != nil {
+			return fmt.Errorf("could not remove record %x: %w", rec.key, err)
+		}
+	}
+	for _, rec := range recs {
+		key := recordKey(int64(binary.LittleEndian.Uint64(rec.key)))
+		err = bkt.Put(key, rec.val)
+		if err != nil {
+			return fmt.Errorf("could not store record %x: %w", key, err)
+		}
+	}
+	return nil
+}
+
 func (srv *server) update(n int) error {
 	var (
 		data []aranet4.Data
@@ -111,12 +266,21 @@ func (srv *server) update(n int) error {
 		}
 	}
 
-	err = srv.write(data)
+	return srv.store(data)
+}
+
+// store writes the provided samples to db and updates the plots.
+func (srv *server) store(data []aranet4.Data) error {
+	err := srv.write(data)
 	if err != nil {
 		return err
 	}
 
-	data, err = srv.rows(0, -1)
+	srv.mu.RLock()
+	beg, end := srv.window(-1, -1)
+	srv.mu.RUnlock()
+
+	data, err = srv.rows(beg, end)
 	if err != nil {
 		return err
 	}
@@ -124,6 +288,64 @@ func (srv *server) update(n int) error {
 	return srv.plot(data)
 }
 
+// observe records a new data sample.
+// When samples are missing between the last recorded sample and the
+// observed one, the history is first backfilled with fetch.
+func (srv *server) observe(data aranet4.Data, fetch func() ([]aranet4.Data, error)) error {
+	srv.mu.RLock()
+	last := srv.last
+	srv.mu.RUnlock()
+
+	if hasGap(last, data) {
+		log.Printf("missing samples since %s: fetching history...", last.Time.UTC().Format("2006-01-02 15:04:05"))
+		err := retry(5, func() error {
+			rows, err := fetch()
+			if err != nil {
+				return err
+			}
+			return srv.write(rows)
+		})
+		if err != nil {
+			log.Printf("could not backfill history: %+v", err)
+		}
+	}
+
+	return srv.store([]aranet4.Data{data})
+}
+
+// hasGap returns whether samples are missing between last and cur.
+func hasGap(last, cur aranet4.Data) bool {
+	if last.Time.IsZero() {
+		return true
+	}
+	return cur.Time.Sub(last.Time) > cur.Interval+cur.Interval/2
+}
+
+// window returns the range of time-stamps of the plots, as Unix times.
+// A missing start (-1) selects the plotWindow seconds before the end, or
+// before the last sample when the end is missing too.
+// window must be called with srv.mu held.
+func (srv *server) window(beg, end int64) (int64, int64) {
+	if beg >= 0 {
+		return beg, end
+	}
+	ref := end
+	if ref <= 0 {
+		if srv.last.Time.IsZero() {
+			return 0, end
+		}
+		ref = srv.last.Time.UTC().Unix()
+	}
+	beg = ref - plotWindow
+	if beg < 0 {
+		beg = 0
+	}
+	return beg, end
+}
+
+// rows returns the samples measured between beg and end, in seconds since
+// the Unix epoch, sorted by time.
+// A non-positive end selects all the samples measured after beg.
 func (srv *server) rows(beg, end int64) ([]aranet4.Data, error) {
 	var rows []aranet4.Data
 	err := srv.db.View(func(tx *bbolt.Tx) error {
@@ -131,32 +353,30 @@ func (srv *server) rows(beg, end int64) ([]aranet4.Data, error) {
 		if bkt == nil {
 			return fmt.Errorf("could not find %q bucket", bucketData)
 		}
-		return bkt.ForEach(func(k, v []byte) error {
-			var (
-				row aranet4.Data
-				err = unmarshalBinary(&row, v)
-			)
+
+		var last []byte
+		if end > 0 {
+			last = recordKey(end)
+		}
+		c := bkt.Cursor()
+		for k, v := c.Seek(recordKey(beg)); k != nil; k, v = c.Next() {
+			if last != nil && bytes.Compare(k, last) > 0 {
+				break
+			}
+			var row aranet4.Data
+			err := unmarshalBinary(&row, v)
 			if err != nil {
 				return err
 			}
-			id := row.Time.UTC().Unix()
-			if beg > id {
-				return nil
-			}
-			if end > 0 && id > end {
-				return nil
-			}
 			rows = append(rows, row)
-			return nil
-		})
+		}
+		return nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("could not read rows: %w", err)
 	}
 
-	sort.Slice(rows, func(i, j int) bool {
-		return ltApprox(rows[i], rows[j])
-	})
+	aranet4.NewClassifier(srv.policy).Fill(rows)
 
 	return rows, nil
 }
@@ -199,11 +419,9 @@ func (srv *server) write(vs []aranet4.Data) error {
 
 		for _, v := range vs {
 			var (
-				id  = make([]byte, 8)
-				buf = make([]byte, dataSize)
+				id  = recordKey(v.Time.UTC().Unix())
+				buf = make([]byte, recordSize)
 			)
-			unix := v.Time.UTC().Unix()
-			binary.LittleEndian.PutUint64(id, uint64(unix))
 			err := marshalBinary(v, buf)
 			if err != nil {
 				return fmt.Errorf("could not marshal sample %v: %w", v, err)
@@ -215,7 +433,7 @@ func (srv *server) write(vs []aranet4.Data) error {
 			}
 			if ltApprox(srv.last, v) {
 				srv.last = v
-				srv.last.Quality = qualityFrom(srv.last.CO2)
+				srv.last.Quality = srv.policy.Classify(v)
 			}
 		}
 
@@ -227,78 +445,48 @@ func (srv *server) write(vs []aranet4.Data) error {
 	return nil
 }
 
-const dataSize = 17
-
-func qualityFrom(co2 int) aranet4.Quality {
-	switch {
-	case co2 < 1000:
-		return 1
-	case co2 < 1400:
-		return 2
-	default:
-		return 3
+// newManager returns a manager for the device with the provided address,
+// or for the software device if sensor is not nil.
+func newManager(addr string, sensor *emu.Sensor) *aranet4.Manager {
+	if sensor != nil {
+		return aranet4.NewManagerWithDialer(func(context.Context) (aranet4.Transport, error) {
+			return sensor.Connect(), nil
+		})
 	}
+	return aranet4.NewManager(addr)
 }
 
-func unmarshalBinary(data *aranet4.Data, p []byte) error {
-	if len(p) != dataSize {
-		return io.ErrShortBuffer
-	}
-	data.Time = time.Unix(int64(binary.LittleEndian.Uint64(p)), 0).UTC()
-	data.H = float64(p[8])
-	data.P = float64(binary.LittleEndian.Uint16(p[9:])) / 10
-	data.T = float64(binary.LittleEndian.Uint16(p[11:])) / 100
-	data.CO2 = int(binary.LittleEndian.Uint16(p[13:]))
-	data.Battery = int(p[15])
-	data.Quality = qualityFrom(data.CO2)
-	data.Interval = time.Duration(p[16]) * time.Minute
-	return nil
-}
+func (srv *server) fetchRows() ([]aranet4.Data, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bleTimeout)
+	defer cancel()
 
-func marshalBinary(data aranet4.Data, p []byte) error {
-	if len(p) != dataSize {
-		return io.ErrShortBuffer
-	}
-	binary.LittleEndian.PutUint64(p[0:], uint64(data.Time.UTC().Unix()))
-	p[8] = uint8(data.H)
-	binary.LittleEndian.PutUint16(p[9:], uint16(data.P*10))
-	binary.LittleEndian.PutUint16(p[11:], uint16(data.T*100))
-	binary.LittleEndian.PutUint16(p[13:], uint16(data.CO2))
-	p[15] = uint8(data.Battery)
-	p[16] = uint8(data.Interval.Minutes())
-	return nil
-}
+	var rows []aranet4.Data
+	err := srv.mgr.Do(ctx, func(dev *aranet4.Device) (err error) {
+		srv.mu.RLock()
+		last := srv.last.Time
+		srv.mu.RUnlock()
 
-func (srv *server) fetchRows() ([]aranet4.Data, error) {
-	dev, err := aranet4.New(srv.addr)
+		// only fetch samples measured after the last one stored in db.
+		rows, err = dev.ReadAfterContext(ctx, last)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not create aranet4 client: %w", err)
+		return nil, err
 	}
-	defer dev.Close()
-
-	return dev.ReadAll()
+	return rows, nil
 }
 
 func (srv *server) fetchRow() ([]aranet4.Data, error) {
-	dev, err := aranet4.New(srv.addr)
-	if err != nil {
-		return nil, fmt.Errorf("could not create aranet4 client: %w", err)
-	}
-	defer dev.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), bleTimeout)
+	defer cancel()
 
-	v, err := dev.Read()
+	var v aranet4.Data
+	err := srv.mgr.Do(ctx, func(dev *aranet4.Device) (err error) {
+		v, err = dev.ReadContext(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve aranet4 data: %w", err)
 	}
 	return []aranet4.Data{v}, nil
 }
-
-func (srv *server) interval() (time.Duration, error) {
-	dev, err := aranet4.New(srv.addr)
-	if err != nil {
-		return 0, fmt.Errorf("could not create aranet4 client: %w", err)
-	}
-	defer dev.Close()
-
-	return dev.Interval()
-}
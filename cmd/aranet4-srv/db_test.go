@@ -5,38 +5,782 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"go.etcd.io/bbolt"
 	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/analysis"
+	"sbinet.org/x/aranet4/emu"
 )
 
 func TestRWData(t *testing.T) {
-	want := aranet4.Data{
-		H:        100,
-		P:        1000,
-		T:        100.12,
-		CO2:      2000,
-		Battery:  100,
-		Quality:  3,
-		Interval: 5 * time.Minute,
-		Time:     time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+	for _, tc := range []struct {
+		name string
+		want aranet4.Data
+	}{
+		{
+			name: "full",
+			want: aranet4.Data{
+				H:        100,
+				P:        1000,
+				T:        100.12,
+				CO2:      2000,
+				Battery:  100,
+				Status:   3,
+				Interval: 5 * time.Minute,
+				Time:     time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+			},
+		},
+		{
+			name: "missing",
+			want: aranet4.Data{
+				H:        42,
+				P:        1000,
+				Battery:  100,
+				Interval: 5 * time.Minute,
+				Time:     time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+				Missing:  aranet4.FieldT | aranet4.FieldCO2,
+			},
+		},
+		{
+			name: "cold-storage",
+			want: aranet4.Data{
+				Model:    aranet4.ModelAranet2,
+				H:        87.3,
+				T:        -21.35,
+				Battery:  -1,
+				Interval: 90 * time.Second,
+				Time:     time.Date(2022, time.January, 2, 15, 4, 5, 123456789, time.UTC),
+			},
+		},
+		{
+			name: "radon",
+			want: aranet4.Data{
+				Model:    aranet4.ModelAranetRadon,
+				H:        45.2,
+				P:        1012.3,
+				T:        19.05,
+				Radon:    153,
+				Status:   2,
+				Battery:  87,
+				Interval: 10 * time.Minute,
+				Time:     time.Date(2022, time.January, 2, 15, 4, 5, 0, time.UTC),
+			},
+		},
+		{
+			name: "radiation",
+			want: aranet4.Data{
+				Model:    aranet4.ModelAranetRadiation,
+				DoseRate: 0.09,
+				Dose:     0.012345,
+				Battery:  0,
+				Interval: time.Minute,
+				Time:     time.Date(2022, time.January, 2, 15, 4, 5, 0, time.UTC),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := make([]byte, recordSize)
+			err := marshalBinary(tc.want, buf)
+			if err != nil {
+				t.Fatalf("could not marshal binary: %+v", err)
+			}
+
+			var got aranet4.Data
+			err = unmarshalBinary(&got, buf)
+			if err != nil {
+				t.Fatalf("could not unmarshal binary: %+v", err)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid roundtrip:\ngot:\n%vwant:\n%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRowsQuality(t *testing.T) {
+	sensor := emu.New(emu.Config{Capacity: 12})
+	srv := newTestServer(t, sensor)
+
+	t0 := time.Date(2022, 1, 18, 8, 0, 0, 0, time.UTC)
+	var vs []aranet4.Data
+	for i, co2 := range []int{700, 850, 780, 1100, 1450, 1380, 900} {
+		vs = append(vs, aranet4.Data{
+			Model:    aranet4.ModelAranet4,
+			CO2:      co2,
+			H:        40,
+			P:        1000,
+			T:        21,
+			Battery:  -1,
+			Interval: 5 * time.Minute,
+			Time:     t0.Add(time.Duration(i) * 5 * time.Minute),
+		})
+	}
+	err := srv.write(vs)
+	if err != nil {
+		t.Fatalf("could not write samples: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		policy aranet4.Policy
+		want   []aranet4.Quality
+	}{
+		{
+			name:   "default",
+			policy: aranet4.DefaultPolicy(),
+			want:   []aranet4.Quality{1, 1, 1, 2, 3, 2, 1},
+		},
+		{
+			name: "schools",
+			policy: aranet4.Policy{
+				CO2: aranet4.Thresholds{Yellow: 800, Red: 1400, Hysteresis: 100},
+			},
+			want: []aranet4.Quality{1, 2, 2, 2, 3, 3, 2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.policy = tc.policy
+			rows, err := srv.rows(0, -1)
+			if err != nil {
+				t.Fatalf("could not read rows: %+v", err)
+			}
+			got := make([]aranet4.Quality, len(rows))
+			for i, row := range rows {
+				got[i] = row.Quality
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid quality:\ngot= %v\nwant=%v", got, tc.want)
+			}
+		})
 	}
 
-	buf := make([]byte, dataSize)
-	err := marshalBinary(want, buf)
+	// the status reported by the device is kept, but the quality is
+	// assessed with the configured policy.
+	srv.policy = aranet4.Policy{CO2: aranet4.Thresholds{Yellow: 800, Red: 1400}}
+	cur := vs[len(vs)-1]
+	cur.Time = cur.Time.Add(5 * time.Minute)
+	cur.Quality = aranet4.QualityGreen
+	cur.Status = aranet4.QualityGreen
+	cur.Battery = 90
+	err = srv.write([]aranet4.Data{cur})
+	if err != nil {
+		t.Fatalf("could not write current sample: %+v", err)
+	}
+	if got, want := srv.last.Quality, aranet4.QualityYellow; got != want {
+		t.Fatalf("invalid quality of last sample: got=%v, want=%v", got, want)
+	}
+	rows, err := srv.rows(cur.Time.Unix(), -1)
+	if err != nil {
+		t.Fatalf("could not read rows: %+v", err)
+	}
+	if got, want := rows[0].Status, aranet4.QualityGreen; got != want {
+		t.Fatalf("invalid status of last sample: got=%v, want=%v", got, want)
+	}
+	if got, want := rows[0].Quality, aranet4.QualityYellow; got != want {
+		t.Fatalf("invalid quality of last sample: got=%v, want=%v", got, want)
+	}
+}
+
+func TestMarshalBinaryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		data aranet4.Data
+		want string
+	}{
+		{aranet4.Data{Battery: -2}, "invalid battery level -2"},
+		{aranet4.Data{Battery: 200}, "invalid battery level 200"},
+		{aranet4.Data{Status: -1}, "invalid status -1"},
+		{aranet4.Data{Model: 256}, "invalid model 256"},
+	} {
+		t.Run(tc.want, func(t *testing.T) {
+			err := marshalBinary(tc.data, make([]byte, recordSize))
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.want; got != want {
+				t.Fatalf("invalid error: got=%q, want=%q", got, want)
+			}
+		})
+	}
+
+	err := unmarshalBinary(new(aranet4.Data), append([]byte{42}, make([]byte, recordSize-1)...))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "invalid record version 42"; got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+}
+
+// marshalLegacy encodes a sample as a legacy record.
+// Negative temperatures and unknown battery levels wrap around, as they
+// did with legacy records.
+func marshalLegacy(data aranet4.Data) []byte {
+	p := make([]byte, legacySize)
+	binary.LittleEndian.PutUint64(p[0:], uint64(data.Time.UTC().Unix()))
+	p[8] = missingU8
+	if data.Valid(aranet4.FieldH) {
+		p[8] = uint8(data.H)
+	}
+	binary.LittleEndian.PutUint16(p[9:], missingU16)
+	if data.Valid(aranet4.FieldP) {
+		binary.LittleEndian.PutUint16(p[9:], uint16(data.P*10))
+	}
+	binary.LittleEndian.PutUint16(p[11:], missingU16)
+	if data.Valid(aranet4.FieldT) {
+		binary.LittleEndian.PutUint16(p[11:], uint16(int16(math.Round(data.T*100))))
+	}
+	binary.LittleEndian.PutUint16(p[13:], missingU16)
+	if data.Valid(aranet4.FieldCO2) {
+		binary.LittleEndian.PutUint16(p[13:], uint16(data.CO2))
+	}
+	p[15] = uint8(data.Battery)
+	p[16] = uint8(data.Interval.Minutes())
+	return p
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	t0 := time.Date(2022, time.January, 2, 15, 0, 0, 0, time.UTC)
+	want := []aranet4.Data{
+		{H: 40, P: 1013.2, T: 21.5, CO2: 800, Battery: 96, Interval: 5 * time.Minute, Time: t0},
+		{H: 90, P: 1001.5, T: -18.25, CO2: 450, Battery: -1, Interval: 5 * time.Minute, Time: t0.Add(5 * time.Minute)},
+		{H: 35, P: 999.9, Battery: 0, Interval: 5 * time.Minute, Time: t0.Add(10 * time.Minute), Missing: aranet4.FieldT | aranet4.FieldCO2},
+	}
+
+	fname := filepath.Join(t.TempDir(), "data.db")
+	db, err := bbolt.Open(fname, 0644, nil)
+	if err != nil {
+		t.Fatalf("could not open db: %+v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bkt, err := tx.CreateBucket(bucketData)
+		if err != nil {
+			return err
+		}
+		for _, v := range want {
+			id := make([]byte, 8)
+			binary.LittleEndian.PutUint64(id, uint64(v.Time.Unix()))
+			err = bkt.Put(id, marshalLegacy(v))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		t.Fatalf("could not marshal binary: %+v", err)
+		t.Fatalf("could not fill legacy db: %+v", err)
 	}
 
-	var got aranet4.Data
-	err = unmarshalBinary(&got, buf)
+	srv := &server{db: db, policy: aranet4.DefaultPolicy()}
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		// migrations are only applied once.
+		err = srv.init()
+		if err != nil {
+			t.Fatalf("could not initialize server (run=%d): %+v", i, err)
+		}
+
+		err = db.View(func(tx *bbolt.Tx) error {
+			v := tx.Bucket(bucketMeta).Get(keyVersion)
+			if got, want := int(binary.LittleEndian.Uint64(v)), dbVersion; got != want {
+				t.Fatalf("invalid db version: got=%d, want=%d", got, want)
+			}
+			return tx.Bucket(bucketData).ForEach(func(k, v []byte) error {
+				if got, want := len(v), recordSize; got != want {
+					t.Fatalf("invalid record size: got=%d, want=%d", got, want)
+				}
+				var data aranet4.Data
+				err := unmarshalBinary(&data, v)
+				if err != nil {
+					return err
+				}
+				if got, want := k, recordKey(data.Time.Unix()); !bytes.Equal(got, want) {
+					t.Fatalf("invalid record key: got=%x, want=%x", got, want)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatalf("could not inspect db: %+v", err)
+		}
+
+		rows, err := srv.rows(0, -1)
+		if err != nil {
+			t.Fatalf("could not read rows: %+v", err)
+		}
+		for i := range want {
+			want[i].Quality = aranet4.DefaultPolicy().Classify(want[i])
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Fatalf("invalid rows:\ngot= %+v\nwant=%+v", rows, want)
+		}
+	}
+
+	// newer db layouts are not downgraded.
+	err = db.Update(func(tx *bbolt.Tx) error {
+		v := make([]byte, 8)
+		binary.LittleEndian.PutUint64(v, uint64(dbVersion+1))
+		return tx.Bucket(bucketMeta).Put(keyVersion, v)
+	})
+	if err != nil {
+		t.Fatalf("could not bump db version: %+v", err)
+	}
+	err = srv.init()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestMigrateSortableKeys(t *testing.T) {
+	var (
+		t0   = time.Date(2022, time.January, 2, 15, 0, 0, 0, time.UTC)
+		want = make([]aranet4.Data, 300)
+	)
+	for i := range want {
+		want[i] = aranet4.Data{
+			H:        40,
+			P:        1013.2,
+			T:        21.5,
+			CO2:      400 + i,
+			Battery:  96,
+			Quality:  aranet4.QualityGreen,
+			Interval: time.Minute,
+			Time:     t0.Add(time.Duration(i) * time.Minute),
+		}
+	}
+
+	fname := filepath.Join(t.TempDir(), "data.db")
+	db, err := bbolt.Open(fname, 0644, nil)
+	if err != nil {
+		t.Fatalf("could not open db: %+v", err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bkt, err := tx.CreateBucket(bucketData)
+		if err != nil {
+			return err
+		}
+		// little endian keys do not follow the time order of samples.
+		for _, v := range want {
+			var (
+				id  = make([]byte, 8)
+				buf = make([]byte, recordSize)
+			)
+			binary.LittleEndian.PutUint64(id, uint64(v.Time.Unix()))
+			err = marshalBinary(v, buf)
+			if err != nil {
+				return err
+			}
+			err = bkt.Put(id, buf)
+			if err != nil {
+				return err
+			}
+		}
+
+		meta, err := tx.CreateBucket(bucketMeta)
+		if err != nil {
+			return err
+		}
+		version := make([]byte, 8)
+		binary.LittleEndian.PutUint64(version, 1)
+		return meta.Put(keyVersion, version)
+	})
+	if err != nil {
+		t.Fatalf("could not fill db: %+v", err)
+	}
+
+	srv := &server{db: db, policy: aranet4.DefaultPolicy()}
+	err = srv.init()
+	if err != nil {
+		t.Fatalf("could not initialize server: %+v", err)
+	}
+
+	if got, want := srv.last, want[len(want)-1]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid last sample:\ngot:\n%vwant:\n%v", got, want)
+	}
+
+	rows, err := srv.rows(0, -1)
+	if err != nil {
+		t.Fatalf("could not read rows: %+v", err)
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("invalid rows:\ngot= %+v\nwant=%+v", rows, want)
+	}
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		if got, n := tx.Bucket(bucketData).Stats().KeyN, len(want); got != n {
+			t.Fatalf("invalid number of records: got=%d, want=%d", got, n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not inspect db: %+v", err)
+	}
+}
+
+func TestRowsRange(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	var (
+		t0   = time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC)
+		data = make([]aranet4.Data, 3*24*60)
+	)
+	for i := range data {
+		data[i] = aranet4.Data{
+			H:        40,
+			P:        1013.2,
+			T:        21.5,
+			CO2:      400 + i%1000,
+			Battery:  96,
+			Interval: time.Minute,
+			Time:     t0.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	err := srv.write(data)
 	if err != nil {
-		t.Fatalf("could not unmarshal binary: %+v", err)
+		t.Fatalf("could not write samples: %+v", err)
+	}
+
+	if got, want := srv.last.Time, data[len(data)-1].Time; !got.Equal(want) {
+		t.Fatalf("invalid last sample: got=%v, want=%v", got, want)
+	}
+
+	day := int64(24 * 60 * 60)
+	for _, tc := range []struct {
+		name     string
+		beg, end int64
+		want     []aranet4.Data
+	}{
+		{"all", 0, -1, data},
+		{"from", -1, -1, data},
+		{"before", 0, t0.Unix() - 1, nil},
+		{"after", t0.Unix() + 3*day, -1, nil},
+		{"first-day", t0.Unix(), t0.Unix() + day, data[:24*60+1]},
+		{"second-day", t0.Unix() + day, t0.Unix() + 2*day, data[24*60 : 2*24*60+1]},
+		{"last-day", t0.Unix() + 2*day, -1, data[2*24*60:]},
+		{"within", t0.Unix() + 90, t0.Unix() + 150, data[2:3]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := srv.rows(tc.beg, tc.end)
+			if err != nil {
+				t.Fatalf("could not read rows: %+v", err)
+			}
+			if got, want := len(rows), len(tc.want); got != want {
+				t.Fatalf("invalid number of rows: got=%d, want=%d", got, want)
+			}
+			for i := range rows {
+				if got, want := rows[i].Time, tc.want[i].Time; !got.Equal(want) {
+					t.Fatalf("invalid row %d: got=%v, want=%v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWindow(t *testing.T) {
+	var (
+		day  = plotWindow
+		last = time.Date(2022, time.January, 5, 12, 0, 0, 0, time.UTC)
+		now  = last.Unix()
+	)
+	for _, tc := range []struct {
+		name     string
+		last     time.Time
+		beg, end int64
+		want     [2]int64
+	}{
+		{"empty-db", time.Time{}, -1, -1, [2]int64{0, -1}},
+		{"default", last, -1, -1, [2]int64{now - day, -1}},
+		{"from", last, now - 3*day, -1, [2]int64{now - 3*day, -1}},
+		{"to", last, -1, now - 2*day, [2]int64{now - 3*day, now - 2*day}},
+		{"from-to", last, now - 5*day, now - 4*day, [2]int64{now - 5*day, now - 4*day}},
+		{"epoch", time.Unix(3600, 0), -1, -1, [2]int64{0, -1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &server{last: aranet4.Data{Time: tc.last}}
+			beg, end := srv.window(tc.beg, tc.end)
+			if got, want := [2]int64{beg, end}, tc.want; got != want {
+				t.Fatalf("invalid window: got=%v, want=%v", got, want)
+			}
+		})
+	}
+}
+
+func newTestServer(t *testing.T, sensor *emu.Sensor) *server {
+	t.Helper()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "data.db"), 0644, nil)
+	if err != nil {
+		t.Fatalf("could not open db: %+v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	srv := &server{
+		emu:    sensor,
+		mgr:    newManager("", sensor),
+		db:     db,
+		policy: aranet4.DefaultPolicy(),
+	}
+	t.Cleanup(func() { srv.mgr.Close() })
+	err = srv.init()
+	if err != nil {
+		t.Fatalf("could not initialize server: %+v", err)
+	}
+	return srv
+}
+
+// advertisement recorded from an Aranet4 device, with a patched
+// time-since-last-measurement.
+func advertisement(ago uint16) []byte {
+	p := []byte{
+		0x22, 0x13, 0x04, 0x01, 0x00, 0x0c, 0x0f, 0x01,
+		0x3a, 0x02, 0x8f, 0x01, 0x4d, 0x26, 0x1d, 0x60,
+		0x01, 0x2c, 0x01, 0x00, 0x00, 0x2a,
+	}
+	binary.LittleEndian.PutUint16(p[19:], ago)
+	return p
+}
+
+func TestPassive(t *testing.T) {
+	sensor := emu.New(emu.Config{Capacity: 12})
+	srv := newTestServer(t, sensor)
+
+	nrows := func() int {
+		t.Helper()
+		rows, err := srv.rows(0, -1)
+		if err != nil {
+			t.Fatalf("could not read rows: %+v", err)
+		}
+		return len(rows)
+	}
+
+	// pre-existing, outdated, samples.
+	hist := sensor.Samples()
+	err := srv.write(hist[:6])
+	if err != nil {
+		t.Fatalf("could not write history: %+v", err)
+	}
+	if got, want := nrows(), 6; got != want {
+		t.Fatalf("invalid number of rows: got=%d, want=%d", got, want)
+	}
+
+	tests := []struct {
+		name string
+		adv  []byte
+		want int
+		data aranet4.Data
+	}{
+		{name: "backfill", adv: advertisement(10), want: 13},
+		{name: "duplicate", adv: advertisement(11), want: 13},
+		{name: "stale", adv: advertisement(1000), want: 13},
+	}
+	// parse all advertisements upfront, as if they were received together.
+	for i, tc := range tests {
+		tests[i].data, err = aranet4.ParseAdvertisement(tc.adv)
+		if err != nil {
+			t.Fatalf("could not parse advertisement: %+v", err)
+		}
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := srv.observe(tc.data, srv.fetchRows)
+			if err != nil {
+				t.Fatalf("could not observe sample: %+v", err)
+			}
+			if got, want := nrows(), tc.want; got != want {
+				t.Fatalf("invalid number of rows: got=%d, want=%d", got, want)
+			}
+		})
+	}
+
+	if got, want := srv.last.CO2, 570; got != want {
+		t.Fatalf("invalid last sample: got=%d, want=%d", got, want)
+	}
+}
+
+func TestPlotDerived(t *testing.T) {
+	sensor := emu.New(emu.Config{Capacity: 12})
+	srv := newTestServer(t, sensor)
+
+	err := srv.store(sensor.Samples())
+	if err != nil {
+		t.Fatalf("could not store samples: %+v", err)
+	}
+
+	for _, m := range metrics() {
+		t.Run(m.Key, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.handlePlotDerived(w, httptest.NewRequest("GET", "/plot-derived?key="+m.Key, nil))
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Fatalf("invalid status: got=%d, want=%d", got, want)
+			}
+			if got, want := w.Header().Get("content-type"), "image/png"; got != want {
+				t.Fatalf("invalid content-type: got=%q, want=%q", got, want)
+			}
+			if w.Body.Len() == 0 {
+				t.Fatalf("empty plot")
+			}
+		})
+	}
+
+	for _, key := range []string{"not-there", "co2_corrected_ppm"} {
+		w := httptest.NewRecorder()
+		srv.handlePlotDerived(w, httptest.NewRequest("GET", "/plot-derived?key="+key, nil))
+		if got, want := w.Code, http.StatusNotFound; got != want {
+			t.Fatalf("invalid status for %q: got=%d, want=%d", key, got, want)
+		}
+	}
+}
+
+func TestVentilation(t *testing.T) {
+	var (
+		beg = time.Date(2022, 1, 18, 16, 0, 0, 0, time.UTC)
+		now = beg.Add(16 * time.Hour)
+	)
+	// people leave the office at 18:00, and the CO2 concentration decays
+	// toward 450 ppm at one air change per hour.
+	sensor := emu.New(emu.Config{
+		Start: beg,
+		Now:   func() time.Time { return now },
+	})
+	srv := newTestServer(t, sensor)
+
+	err := srv.store(sensor.Samples())
+	if err != nil {
+		t.Fatalf("could not store samples: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		query string
+		code  int
+		want  string
+	}{
+		{query: "baseline=450", code: http.StatusOK, want: "Mean air changes per hour: <b>1.00</b>"},
+		{query: "baseline=450&from=2022-01-20", code: http.StatusOK, want: "No CO2 decay episode found."},
+		{query: "baseline=-1", code: http.StatusBadRequest, want: "invalid baseline"},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.handleVentilation(w, httptest.NewRequest("GET", "/ventilation?"+tc.query, nil))
+			if got, want := w.Code, tc.code; got != want {
+				t.Fatalf("invalid status: got=%d, want=%d", got, want)
+			}
+			if got, want := w.Body.String(), tc.want; !strings.Contains(got, want) {
+				t.Fatalf("invalid report: got=%q, want=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestOccupancy(t *testing.T) {
+	var (
+		beg = time.Date(2022, 1, 18, 16, 0, 0, 0, time.UTC)
+		now = beg.Add(28 * time.Hour)
+	)
+	// the office is ventilated at one air change per hour, and its CO2
+	// concentration settles 800 ppm above the outdoor baseline during
+	// working hours.
+	sensor := emu.New(emu.Config{
+		Start: beg,
+		Now:   func() time.Time { return now },
+	})
+	srv := newTestServer(t, sensor)
+	srv.room = room{volume: 100, baseline: 450}
+
+	err := srv.store(sensor.Samples())
+	if err != nil {
+		t.Fatalf("could not store samples: %+v", err)
+	}
+	if srv.plots.Occupancy.Len() == 0 {
+		t.Fatalf("empty occupancy plot")
+	}
+
+	w := httptest.NewRecorder()
+	srv.handleAPIOccupancy(w, httptest.NewRequest("GET", "/api/occupancy", nil))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("invalid status: got=%d, want=%d (%s)", got, want, w.Body.String())
+	}
+
+	var reply struct {
+		Volume    float64 `json:"volume_m3"`
+		ACH       float64 `json:"ach"`
+		Occupancy []struct {
+			Time      time.Time `json:"time"`
+			Occupants float64   `json:"occupants"`
+		} `json:"occupancy"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &reply)
+	if err != nil {
+		t.Fatalf("could not decode reply: %+v", err)
+	}
+	if got, want := reply.Volume, 100.0; got != want {
+		t.Fatalf("invalid volume: got=%v, want=%v", got, want)
+	}
+	if got, want := reply.ACH, 1.0; math.Abs(got-want) > 0.05 {
+		t.Fatalf("invalid ACH: got=%v, want=%v", got, want)
+	}
+
+	// occupants needed to sustain 800 ppm, at one air change per hour.
+	crowd := 800e-6 * 100 / (analysis.GenerationOffice * 3.6)
+	for _, tc := range []struct {
+		time time.Time
+		want float64
+	}{
+		{time.Date(2022, 1, 19, 3, 0, 0, 0, time.UTC), 0},
+		{time.Date(2022, 1, 19, 12, 0, 0, 0, time.UTC), crowd},
+		{time.Date(2022, 1, 19, 16, 0, 0, 0, time.UTC), crowd},
+	} {
+		i := sort.Search(len(reply.Occupancy), func(i int) bool {
+			return !reply.Occupancy[i].Time.Before(tc.time)
+		})
+		if i == len(reply.Occupancy) {
+			t.Fatalf("no occupancy estimation at %v", tc.time)
+		}
+		if got, want := reply.Occupancy[i].Occupants, tc.want; math.Abs(got-want) > 0.3 {
+			t.Fatalf("invalid occupancy at %v: got=%v, want=%v", tc.time, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		query string
+		code  int
+	}{
+		{query: "volume=-1", code: http.StatusBadRequest},
+		{query: "ach=nope", code: http.StatusBadRequest},
+		{query: "volume=NaN", code: http.StatusBadRequest},
+		{query: "volume=50&ach=NaN", code: http.StatusBadRequest},
+		{query: "volume=50&generation=nan", code: http.StatusBadRequest},
+		{query: "volume=+Inf", code: http.StatusBadRequest},
+		{query: "volume=50&ach=2", code: http.StatusOK},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.handleAPIOccupancy(w, httptest.NewRequest("GET", "/api/occupancy?"+tc.query, nil))
+			if got, want := w.Code, tc.code; got != want {
+				t.Fatalf("invalid status: got=%d, want=%d", got, want)
+			}
+		})
 	}
 
-	if !reflect.DeepEqual(got, want) {
-		t.Fatalf("invalid roundtrip:\ngot:\n%vwant:\n%v", got, want)
+	srv.room = room{}
+	w = httptest.NewRecorder()
+	srv.handleAPIOccupancy(w, httptest.NewRequest("GET", "/api/occupancy", nil))
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Fatalf("invalid status without room volume: got=%d, want=%d", got, want)
 	}
 }
@@ -6,8 +6,12 @@ package main // import "sbinet.org/x/aranet4/cmd/aranet4-srv"
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+
+	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/analysis"
 )
 
 func main() {
@@ -18,15 +22,57 @@ func main() {
 		addr  = flag.String("addr", ":8080", "[host]:addr to serve")
 		devID = flag.String("device", "F5:6C:BE:D5:61:47", "MAC address of Aranet4")
 		db    = flag.String("db", "data.db", "path to DB file")
+		emu   = flag.Bool("emu", false, "use a software Aranet4 device (for demos)")
+		psv   = flag.Bool("passive", false, "collect data from advertisements, without connecting to the device")
+
+		vol  = flag.Float64("volume", 0, "volume of the room, in m³, to estimate its occupancy (0 disables the estimation)")
+		ach  = flag.Float64("ach", 0, "ventilation rate of the room, in air changes per hour (0 estimates it from CO2 decays)")
+		gen  = flag.Float64("co2-gen", analysis.GenerationOffice, "CO2 generation rate of one occupant, in L/s")
+		base = flag.Float64("baseline", analysis.DefaultBaseline, "outdoor CO2 concentration, in ppm")
+
+		co2Yellow = flag.Float64("co2-yellow", 1000, "CO2 concentration, in ppm, from which the air quality is yellow")
+		co2Red    = flag.Float64("co2-red", 1400, "CO2 concentration, in ppm, from which the air quality is red")
+		co2Hyst   = flag.Float64("co2-hysteresis", 0, "hysteresis of the CO2 thresholds of the air quality, in ppm")
+		tBand     = flag.String("comfort-t", "", "comfort band of temperature, in °C, as lo,hi (e.g. 19,26)")
+		hBand     = flag.String("comfort-h", "", "comfort band of relative humidity, in %, as lo,hi (e.g. 30,60)")
 	)
 
 	flag.Parse()
 
-	xmain(*addr, *devID, *db)
+	policy := aranet4.Policy{
+		CO2: aranet4.Thresholds{
+			Yellow:     *co2Yellow,
+			Red:        *co2Red,
+			Hysteresis: *co2Hyst,
+		},
+	}
+	for _, v := range []struct {
+		name string
+		band *aranet4.Band
+		arg  string
+	}{
+		{"comfort-t", &policy.T, *tBand},
+		{"comfort-h", &policy.H, *hBand},
+	} {
+		if v.arg == "" {
+			continue
+		}
+		_, err := fmt.Sscanf(v.arg, "%g,%g", &v.band.Lo, &v.band.Hi)
+		if err != nil || v.band.Lo >= v.band.Hi {
+			log.Fatalf("invalid -%s band %q", v.name, v.arg)
+		}
+	}
+
+	xmain(*addr, *devID, *db, *emu, *psv, room{
+		volume:     *vol,
+		ach:        *ach,
+		generation: *gen,
+		baseline:   *base,
+	}, policy)
 }
 
-func xmain(addr, devID, db string) {
-	srv := newServer(devID, db)
+func xmain(addr, devID, db string, emulate, passive bool, rm room, policy aranet4.Policy) {
+	srv := newServer(devID, db, emulate, passive, rm, policy)
 	defer srv.Close()
 
 	log.Printf("serving %q...", addr)
@@ -8,7 +8,9 @@ import (
 	"bytes"
 	"fmt"
 	"image/color"
+	"log"
 	"math"
+	"strings"
 
 	"go-hep.org/x/hep/hplot"
 	"gonum.org/v1/plot"
@@ -16,88 +18,183 @@ import (
 	"gonum.org/v1/plot/vg/draw"
 	"gonum.org/v1/plot/vg/vgimg"
 	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/derive"
 )
 
 func (srv *server) plot(data []aranet4.Data) error {
 	var err error
 
-	xs := make([]float64, 0, len(data))
-	for _, v := range data {
-		xs = append(xs, float64(v.Time.Unix()))
-	}
-
-	err = srv.plotCO2(xs, data)
+	err = srv.plotCO2(data)
 	if err != nil {
 		return fmt.Errorf("could not create CO2 plot: %w", err)
 	}
-	err = srv.plotT(xs, data)
+	err = srv.plotOccupancy(data)
+	if err != nil {
+		return fmt.Errorf("could not create occupancy plot: %w", err)
+	}
+	err = srv.plotT(data)
 	if err != nil {
 		return fmt.Errorf("could not create T plot: %w", err)
 	}
-	err = srv.plotH(xs, data)
+	err = srv.plotH(data)
 	if err != nil {
 		return fmt.Errorf("could not create H plot: %w", err)
 	}
-	err = srv.plotP(xs, data)
+	err = srv.plotP(data)
 	if err != nil {
 		return fmt.Errorf("could not create P plot: %w", err)
 	}
+	for _, m := range metrics() {
+		err = srv.plotDerived(data, m)
+		if err != nil {
+			return fmt.Errorf("could not create %s plot: %w", m.Name, err)
+		}
+	}
 
 	return nil
 }
 
-func (srv *server) plotCO2(xs []float64, data []aranet4.Data) error {
-	var (
-		ys = make([]float64, 0, len(data))
-	)
-
-	for _, data := range data {
-		ys = append(ys, float64(data.CO2))
+// series returns the time-stamps and values of the field f, skipping the
+// samples where that field is missing.
+func series(data []aranet4.Data, f aranet4.Field, y func(data aranet4.Data) float64) (xs, ys []float64) {
+	xs = make([]float64, 0, len(data))
+	ys = make([]float64, 0, len(data))
+	for _, v := range data {
+		if !v.Valid(f) {
+			continue
+		}
+		xs = append(xs, float64(v.Time.Unix()))
+		ys = append(ys, y(v))
 	}
+	return xs, ys
+}
+
+func (srv *server) plotCO2(data []aranet4.Data) error {
+	xs, ys := series(data, aranet4.FieldCO2, func(data aranet4.Data) float64 {
+		return float64(data.CO2)
+	})
 
 	c := color.NRGBA{B: 255, A: 255}
 	return srv.genPlot(&srv.plots.CO2, xs, ys, "CO2 [ppm]", c)
 }
 
-func (srv *server) plotT(xs []float64, data []aranet4.Data) error {
-	var (
-		ys = make([]float64, 0, len(data))
-	)
+func (srv *server) plotOccupancy(data []aranet4.Data) error {
+	srv.plots.Occupancy.Reset()
+	if srv.room.volume <= 0 {
+		return nil
+	}
+
+	_, occ, err := srv.occupancy(data, srv.room)
+	if err != nil {
+		// not enough data yet, e.g. to estimate the ventilation rate.
+		log.Printf("could not estimate occupancy: %+v", err)
+		return nil
+	}
+
+	xs := make([]float64, len(occ))
+	ys := make([]float64, len(occ))
+	for i, v := range occ {
+		xs[i] = float64(v.Time.Unix())
+		ys[i] = v.N
+	}
+
+	c := color.NRGBA{R: 255, G: 128, A: 255}
+	return srv.genPlot(&srv.plots.Occupancy, xs, ys, "Occupants", c)
+}
 
-	for _, data := range data {
-		ys = append(ys, float64(data.T))
+// occupancySection returns the HTML section displaying the occupancy plot,
+// if the occupancy of the room is estimated.
+func (srv *server) occupancySection() string {
+	if srv.room.volume <= 0 {
+		return ""
 	}
+	return occupancyPlot
+}
+
+func (srv *server) plotT(data []aranet4.Data) error {
+	xs, ys := series(data, aranet4.FieldT, func(data aranet4.Data) float64 {
+		return data.T
+	})
 
 	c := color.NRGBA{R: 255, A: 255}
 	return srv.genPlot(&srv.plots.T, xs, ys, "T [°C]", c)
 }
 
-func (srv *server) plotH(xs []float64, data []aranet4.Data) error {
-	var (
-		ys = make([]float64, 0, len(data))
-	)
-
-	for _, data := range data {
-		ys = append(ys, float64(data.H))
-	}
+func (srv *server) plotH(data []aranet4.Data) error {
+	xs, ys := series(data, aranet4.FieldH, func(data aranet4.Data) float64 {
+		return data.H
+	})
 
 	c := color.NRGBA{G: 255, A: 255}
 	return srv.genPlot(&srv.plots.H, xs, ys, "Humidity [%]", c)
 }
 
-func (srv *server) plotP(xs []float64, data []aranet4.Data) error {
-	var (
-		ys = make([]float64, 0, len(data))
-	)
-
-	for _, data := range data {
-		ys = append(ys, float64(data.P))
-	}
+func (srv *server) plotP(data []aranet4.Data) error {
+	xs, ys := series(data, aranet4.FieldP, func(data aranet4.Data) float64 {
+		return data.P
+	})
 
 	c := color.NRGBA{B: 255, G: 255, A: 255}
 	return srv.genPlot(&srv.plots.P, xs, ys, "Atmospheric Pressure [hPa]", c)
 }
 
+func (srv *server) plotDerived(data []aranet4.Data, m derive.Metric) error {
+	xs := make([]float64, 0, len(data))
+	ys := make([]float64, 0, len(data))
+	for _, v := range data {
+		y, ok := m.Eval(v)
+		if !ok {
+			continue
+		}
+		xs = append(xs, float64(v.Time.Unix()))
+		ys = append(ys, y)
+	}
+
+	if srv.plots.Derived == nil {
+		srv.plots.Derived = make(map[string]*bytes.Buffer)
+	}
+	buf, ok := srv.plots.Derived[m.Key]
+	if !ok {
+		buf = new(bytes.Buffer)
+		srv.plots.Derived[m.Key] = buf
+	}
+
+	label := m.Name
+	if m.Unit != "" {
+		label += " [" + m.Unit + "]"
+	}
+	c := color.NRGBA{R: 128, B: 128, A: 255}
+	return srv.genPlot(buf, xs, ys, label, c)
+}
+
+// metrics returns the derived metrics plotted by the server.
+//
+// The CO2 concentration corrected for the atmospheric pressure is left
+// out: Aranet devices already compensate their CO2 readings for pressure.
+func metrics() []derive.Metric {
+	var ms []derive.Metric
+	for _, m := range derive.Metrics() {
+		if m.Key == "co2_corrected_ppm" {
+			continue
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// derivedSection returns the HTML section displaying the plots of the
+// derived metrics available for the provided model.
+func derivedSection(model aranet4.Model) string {
+	var o strings.Builder
+	for _, m := range metrics() {
+		if model.Fields()&m.Fields != m.Fields {
+			continue
+		}
+		fmt.Fprintf(&o, derivedPlot, m.Name, m.Key)
+	}
+	return o.String()
+}
+
 func (srv *server) genPlot(buf *bytes.Buffer, xs, ys []float64, label string, c color.NRGBA) error {
 
 	buf.Reset()
@@ -152,11 +249,13 @@ const page = `
 		<pre>
 %s
 		</pre>
+		<a href="/ventilation">Ventilation report</a>
 		<!-- CO2 -->
 		<hr>
         <div class="row align-items-center justify-content-center">
 		  <img src="/plot-co2"/>
         </div>
+%s
 
 		<!-- Temperature -->
 		<hr>
@@ -175,6 +274,23 @@ const page = `
         <div class="row align-items-center justify-content-center">
 		  <img src="/plot-p"/>
         </div>
+%s
 	</body>
 </html>
 `
+
+const derivedPlot = `
+		<!-- %s -->
+		<hr>
+        <div class="row align-items-center justify-content-center">
+		  <img src="/plot-derived?key=%s"/>
+        </div>
+`
+
+const occupancyPlot = `
+		<!-- Occupancy -->
+		<hr>
+        <div class="row align-items-center justify-content-center">
+		  <img src="/plot-occupancy"/>
+        </div>
+`
@@ -0,0 +1,172 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+// Records of data samples in db.
+//
+// Records start with a version byte, followed by the fields of the
+// sample, in little endian:
+//   - version (uint8),
+//   - model (uint8),
+//   - missing fields (uint8),
+//   - air quality status reported by the device (int8, 0 if unknown),
+//   - battery level (int8, -1 if unknown),
+//   - time-stamp, in nanoseconds since the Unix epoch (int64),
+//   - measurement interval, in nanoseconds (int64),
+//   - temperature, relative humidity and pressure (float64 each),
+//   - CO2 and radon concentrations (int32 each),
+//   - radiation dose rate and total dose (float64 each).
+//
+// The air quality of samples is not stored, but assessed when reading
+// them, with the current policy.
+//
+// Legacy records, written before records were versioned, are 17 bytes
+// long and hold:
+//   - time-stamp, in seconds since the Unix epoch (uint64),
+//   - relative humidity, in % (uint8, 0xff if missing),
+//   - pressure, in 0.1 hPa (uint16, 0xffff if missing),
+//   - temperature, in 0.01°C (uint16, 0xffff if missing),
+//   - CO2 concentration, in ppm (uint16, 0xffff if missing),
+//   - battery level, in % (uint8),
+//   - measurement interval, in minutes (uint8).
+const (
+	recordVersion = 1
+	recordSize    = 69
+
+	legacySize = 17
+)
+
+// sentinel values marking missing fields in legacy records.
+const (
+	missingU8  = 0xff
+	missingU16 = 0xffff
+)
+
+func unmarshalBinary(data *aranet4.Data, p []byte) error {
+	if len(p) == legacySize {
+		return unmarshalLegacy(data, p)
+	}
+	if len(p) == 0 {
+		return io.ErrShortBuffer
+	}
+	switch v := p[0]; v {
+	case 1:
+		return unmarshalV1(data, p)
+	default:
+		return fmt.Errorf("invalid record version %d", v)
+	}
+}
+
+func unmarshalV1(data *aranet4.Data, p []byte) error {
+	if len(p) != recordSize {
+		return io.ErrShortBuffer
+	}
+	f64 := func(p []byte) float64 {
+		return math.Float64frombits(binary.LittleEndian.Uint64(p))
+	}
+
+	*data = aranet4.Data{
+		Model:    aranet4.Model(p[1]),
+		Missing:  aranet4.Field(p[2]),
+		Status:   aranet4.Quality(int8(p[3])),
+		Battery:  int(int8(p[4])),
+		Time:     time.Unix(0, int64(binary.LittleEndian.Uint64(p[5:]))).UTC(),
+		Interval: time.Duration(binary.LittleEndian.Uint64(p[13:])),
+		T:        f64(p[21:]),
+		H:        f64(p[29:]),
+		P:        f64(p[37:]),
+		CO2:      int(int32(binary.LittleEndian.Uint32(p[45:]))),
+		Radon:    int(int32(binary.LittleEndian.Uint32(p[49:]))),
+		DoseRate: f64(p[53:]),
+		Dose:     f64(p[61:]),
+	}
+	return nil
+}
+
+// unmarshalLegacy decodes a legacy record.
+//
+// Legacy records wrapped negative temperatures around, and stored unknown
+// battery levels as 255: both are recovered.
+// Legacy records did not store the model of the device nor its status.
+func unmarshalLegacy(data *aranet4.Data, p []byte) error {
+	if len(p) != legacySize {
+		return io.ErrShortBuffer
+	}
+	*data = aranet4.Data{}
+	data.Time = time.Unix(int64(binary.LittleEndian.Uint64(p)), 0).UTC()
+	if v := p[8]; v != missingU8 {
+		data.H = float64(v)
+	} else {
+		data.Missing |= aranet4.FieldH
+	}
+	if v := binary.LittleEndian.Uint16(p[9:]); v != missingU16 {
+		data.P = float64(v) / 10
+	} else {
+		data.Missing |= aranet4.FieldP
+	}
+	if v := binary.LittleEndian.Uint16(p[11:]); v != missingU16 {
+		data.T = float64(int16(v)) / 100
+	} else {
+		data.Missing |= aranet4.FieldT
+	}
+	if v := binary.LittleEndian.Uint16(p[13:]); v != missingU16 {
+		data.CO2 = int(v)
+	} else {
+		data.Missing |= aranet4.FieldCO2
+	}
+	data.Battery = int(p[15])
+	if data.Battery == missingU8 {
+		data.Battery = -1
+	}
+	data.Interval = time.Duration(p[16]) * time.Minute
+	return nil
+}
+
+func marshalBinary(data aranet4.Data, p []byte) error {
+	if len(p) != recordSize {
+		return io.ErrShortBuffer
+	}
+	switch {
+	case data.Battery < -1 || data.Battery > math.MaxInt8:
+		return fmt.Errorf("invalid battery level %d", data.Battery)
+	case data.Status < 0 || data.Status > math.MaxInt8:
+		return fmt.Errorf("invalid status %d", data.Status)
+	case data.Model < 0 || data.Model > math.MaxUint8:
+		return fmt.Errorf("invalid model %d", data.Model)
+	case data.CO2 < math.MinInt32 || data.CO2 > math.MaxInt32:
+		return fmt.Errorf("invalid CO2 concentration %d", data.CO2)
+	case data.Radon < math.MinInt32 || data.Radon > math.MaxInt32:
+		return fmt.Errorf("invalid radon concentration %d", data.Radon)
+	}
+	f64 := func(p []byte, v float64) {
+		binary.LittleEndian.PutUint64(p, math.Float64bits(v))
+	}
+
+	p[0] = recordVersion
+	p[1] = uint8(data.Model)
+	p[2] = uint8(data.Missing)
+	p[3] = uint8(int8(data.Status))
+	p[4] = uint8(int8(data.Battery))
+	binary.LittleEndian.PutUint64(p[5:], uint64(data.Time.UnixNano()))
+	binary.LittleEndian.PutUint64(p[13:], uint64(data.Interval))
+	f64(p[21:], data.T)
+	f64(p[29:], data.H)
+	f64(p[37:], data.P)
+	binary.LittleEndian.PutUint32(p[45:], uint32(int32(data.CO2)))
+	binary.LittleEndian.PutUint32(p[49:], uint32(int32(data.Radon)))
+	f64(p[53:], data.DoseRate)
+	f64(p[61:], data.Dose)
+	return nil
+}
@@ -0,0 +1,252 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/analysis"
+)
+
+// room describes the room of the device.
+type room struct {
+	volume     float64 // volume of the room, in m³ (0 if unknown)
+	ach        float64 // ventilation rate, in air changes per hour (0 if unknown)
+	generation float64 // CO2 generation rate of one occupant, in L/s
+	baseline   float64 // outdoor CO2 concentration, in ppm
+}
+
+// occupancy estimates the number of occupants of the room over time.
+// The ventilation rate of the room, if unknown, is estimated from the
+// decays of the CO2 concentration.
+func (srv *server) occupancy(data []aranet4.Data, rm room) (analysis.OccupancyOptions, []analysis.Occupancy, error) {
+	opts := analysis.OccupancyOptions{
+		Volume:     rm.volume,
+		ACH:        rm.ach,
+		Baseline:   rm.baseline,
+		Generation: rm.generation,
+	}
+	if opts.ACH <= 0 {
+		decays := analysis.Decays(data, analysis.DecayOptions{Baseline: rm.baseline})
+		if len(decays) == 0 {
+			return opts, nil, fmt.Errorf("could not estimate ventilation rate: no CO2 decay episode")
+		}
+		opts.ACH, _, _, _ = analysis.MeanACH(decays, 0.95)
+	}
+
+	occ, err := analysis.EstimateOccupancy(data, opts)
+	if err != nil {
+		return opts, nil, fmt.Errorf("could not estimate occupancy: %w", err)
+	}
+	return opts, occ, nil
+}
+
+// ventilation summarizes the ventilation performance of the room of the
+// device, from the decays of its CO2 concentration.
+type ventilation struct {
+	opts   analysis.DecayOptions
+	decays []analysis.Decay
+
+	// mean number of air changes per hour over all the episodes, with
+	// its standard error and confidence interval.
+	ach, stderr, lo, hi float64
+}
+
+func newVentilation(data []aranet4.Data, opts analysis.DecayOptions) ventilation {
+	if opts.Baseline <= 0 {
+		opts.Baseline = analysis.DefaultBaseline
+	}
+	if opts.Confidence <= 0 || opts.Confidence >= 1 {
+		opts.Confidence = 0.95
+	}
+	rep := ventilation{
+		opts:   opts,
+		decays: analysis.Decays(data, opts),
+	}
+	rep.ach, rep.stderr, rep.lo, rep.hi = analysis.MeanACH(rep.decays, opts.Confidence)
+	return rep
+}
+
+func (rep ventilation) write(w io.Writer) {
+	cl := 100 * rep.opts.Confidence
+	fmt.Fprintf(w, reportHeader, rep.opts.Baseline, len(rep.decays))
+	if len(rep.decays) == 0 {
+		io.WriteString(w, "\t\t<p>No CO2 decay episode found.</p>\n")
+		io.WriteString(w, reportFooter)
+		return
+	}
+
+	fmt.Fprintf(w,
+		"\t\t<p>Mean air changes per hour: <b>%.2f</b> ± %.2f (%g%% CI: [%.2f, %.2f])</p>\n",
+		rep.ach, rep.stderr, cl, rep.lo, rep.hi,
+	)
+	io.WriteString(w, "\t\t<table border=\"1\" cellpadding=\"4\">\n")
+	fmt.Fprintf(w,
+		"\t\t\t<tr><th>Start (UTC)</th><th>Duration</th><th>CO2 [ppm]</th>"+
+			"<th>ACH [1/h]</th><th>%g%% CI</th><th>R²</th><th>Samples</th></tr>\n",
+		cl,
+	)
+	for _, d := range rep.decays {
+		fmt.Fprintf(w,
+			"\t\t\t<tr><td>%s</td><td>%v</td><td>%d → %d</td>"+
+				"<td>%.2f</td><td>[%.2f, %.2f]</td><td>%.3f</td><td>%d</td></tr>\n",
+			d.Beg.UTC().Format("2006-01-02 15:04"),
+			d.Duration().Round(time.Minute),
+			d.From, d.To, d.ACH, d.Lo, d.Hi, d.R2, d.Samples,
+		)
+	}
+	io.WriteString(w, "\t\t</table>\n")
+	io.WriteString(w, reportFooter)
+}
+
+func (srv *server) handleVentilation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := analysis.DecayOptions{Baseline: srv.room.baseline}
+	err = formFloat(r, "baseline", &opts.Baseline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	beg, end := formRange(r)
+
+	srv.mu.RLock()
+	data, err := srv.rows(beg, end)
+	srv.mu.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	newVentilation(data, opts).write(w)
+}
+
+func (srv *server) handleAPIOccupancy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rm := srv.room
+	for _, v := range []struct {
+		name string
+		ptr  *float64
+	}{
+		{"volume", &rm.volume},
+		{"ach", &rm.ach},
+		{"generation", &rm.generation},
+		{"baseline", &rm.baseline},
+	} {
+		err = formFloat(r, v.name, v.ptr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if rm.volume <= 0 {
+		http.Error(w, "unknown room volume", http.StatusBadRequest)
+		return
+	}
+
+	beg, end := formRange(r)
+
+	srv.mu.RLock()
+	data, err := srv.rows(beg, end)
+	srv.mu.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	opts, occ, err := srv.occupancy(data, rm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	type sample struct {
+		Time      time.Time `json:"time"`
+		Occupants float64   `json:"occupants"`
+	}
+	reply := struct {
+		Volume     float64  `json:"volume_m3"`
+		ACH        float64  `json:"ach"`
+		Generation float64  `json:"generation_ls"`
+		Baseline   float64  `json:"baseline_ppm"`
+		Occupancy  []sample `json:"occupancy"`
+	}{
+		Volume:     opts.Volume,
+		ACH:        opts.ACH,
+		Generation: opts.Generation,
+		Baseline:   opts.Baseline,
+		Occupancy:  make([]sample, len(occ)),
+	}
+	if reply.Generation <= 0 {
+		reply.Generation = analysis.GenerationOffice
+	}
+	if reply.Baseline <= 0 {
+		reply.Baseline = analysis.DefaultBaseline
+	}
+	for i, v := range occ {
+		reply.Occupancy[i] = sample{Time: v.Time.UTC(), Occupants: v.N}
+	}
+
+	w.Header().Set("content-type", "application/json")
+	err = json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		log.Printf("could not encode occupancy: %+v", err)
+	}
+}
+
+// formFloat parses the named form value, if present, into v.
+func formFloat(r *http.Request, name string, v *float64) error {
+	s := r.Form.Get(name)
+	if s == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("invalid %s %q", name, s)
+	}
+	*v = f
+	return nil
+}
+
+const reportHeader = `
+<html>
+	<head>
+		<title>Aranet4 ventilation report</title>
+	</head>
+
+	<body>
+		<h2>Ventilation report</h2>
+		<p>
+		Air changes per hour (ACH) are fitted on episodes of exponential decay
+		of the CO2 concentration, toward an outdoor baseline of %g ppm.
+		</p>
+		<p>Episodes: %d</p>
+`
+
+const reportFooter = `
+		<hr>
+		<a href="/">Back</a>
+	</body>
+</html>
+`
@@ -6,6 +6,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,50 +15,74 @@ import (
 
 	"go.etcd.io/bbolt"
 	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/emu"
 )
 
 type server struct {
-	addr string // Aranet4 device address
-	mux  *http.ServeMux
+	addr   string           // Aranet4 device address
+	emu    *emu.Sensor      // software Aranet4 device, if any
+	mgr    *aranet4.Manager // connection to the device
+	mux    *http.ServeMux
+	room   room           // description of the room of the device
+	policy aranet4.Policy // assessment of the air quality
 
 	mu    sync.RWMutex
 	db    *bbolt.DB
 	last  aranet4.Data
 	plots struct {
-		CO2     bytes.Buffer
-		T, H, P bytes.Buffer
+		CO2       bytes.Buffer
+		Occupancy bytes.Buffer
+		T, H, P   bytes.Buffer
+		Derived   map[string]*bytes.Buffer // plots of derived metrics, by key
 	}
 }
 
-func newServer(addr, dbfile string) *server {
+func newServer(addr, dbfile string, emulate, passive bool, rm room, policy aranet4.Policy) *server {
 	db, err := bbolt.Open(dbfile, 0644, &bbolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		log.Panicf("could not open aranet4 db: %+v", err)
 	}
 
 	srv := &server{
-		addr: addr,
-		db:   db,
-		mux:  http.NewServeMux(),
+		addr:   addr,
+		db:     db,
+		mux:    http.NewServeMux(),
+		room:   rm,
+		policy: policy,
 	}
+	if emulate {
+		srv.emu = emu.New(emu.Config{})
+	}
+	srv.mgr = newManager(addr, srv.emu)
+	srv.mgr.SetPolicy(policy)
 	srv.mux.HandleFunc("/", srv.handleRoot)
 	srv.mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {})
 	srv.mux.HandleFunc("/update", srv.handleUpdate)
 	srv.mux.HandleFunc("/plot-co2", srv.handlePlotCO2)
+	srv.mux.HandleFunc("/plot-occupancy", srv.handlePlotOccupancy)
 	srv.mux.HandleFunc("/plot-h", srv.handlePlotH)
 	srv.mux.HandleFunc("/plot-p", srv.handlePlotP)
 	srv.mux.HandleFunc("/plot-t", srv.handlePlotT)
+	srv.mux.HandleFunc("/plot-derived", srv.handlePlotDerived)
+	srv.mux.HandleFunc("/ventilation", srv.handleVentilation)
+	srv.mux.HandleFunc("/api/occupancy", srv.handleAPIOccupancy)
 
 	err = srv.init()
 	if err != nil {
 		log.Panicf("could not initialize server: %+v", err)
 	}
 
-	go srv.loop()
+	switch {
+	case passive:
+		go srv.listen()
+	default:
+		go srv.loop()
+	}
 	return srv
 }
 
 func (srv *server) Close() error {
+	_ = srv.mgr.Close()
 	return srv.db.Close()
 }
 
@@ -73,27 +98,10 @@ func (srv *server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cnv := func(name string) int64 {
-		v := r.Form.Get(name)
-		if v == "" {
-			return -1
-		}
-		vv, err := time.Parse("2006-01-02", v)
-		if err != nil {
-			return -1
-		}
-		return vv.UTC().Unix()
-	}
-
-	var (
-		beg = cnv("from")
-		end = cnv("to")
-	)
-
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	data, err := srv.rows(beg, end)
+	data, err := srv.rows(srv.window(formRange(r)))
 	if err != nil {
 		fmt.Fprintf(w, "could not read rows from db: %+v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -111,7 +119,29 @@ func (srv *server) handleRoot(w http.ResponseWriter, r *http.Request) {
 	if refresh == 0 {
 		refresh = 10
 	}
-	fmt.Fprintf(w, page, refresh, srv.last.String())
+	fmt.Fprintf(w, page,
+		refresh, srv.last.Describe(srv.policy),
+		srv.occupancySection(), derivedSection(srv.last.Model),
+	)
+}
+
+// formRange returns the range of time-stamps selected by the "from" and
+// "to" form values, as Unix times.
+// Missing or invalid bounds are returned as -1.
+func formRange(r *http.Request) (beg, end int64) {
+	cnv := func(name string) int64 {
+		v := r.Form.Get(name)
+		if v == "" {
+			return -1
+		}
+		vv, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return -1
+		}
+		return vv.UTC().Unix()
+	}
+
+	return cnv("from"), cnv("to")
 }
 
 func (srv *server) handleUpdate(w http.ResponseWriter, r *http.Request) {
@@ -135,6 +165,14 @@ func (srv *server) handlePlotCO2(w http.ResponseWriter, r *http.Request) {
 	w.Write(srv.plots.CO2.Bytes())
 }
 
+func (srv *server) handlePlotOccupancy(w http.ResponseWriter, r *http.Request) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	w.Header().Set("content-type", "image/png")
+	w.Write(srv.plots.Occupancy.Bytes())
+}
+
 func (srv *server) handlePlotH(w http.ResponseWriter, r *http.Request) {
 	srv.mu.RLock()
 	defer srv.mu.RUnlock()
@@ -159,39 +197,78 @@ func (srv *server) handlePlotT(w http.ResponseWriter, r *http.Request) {
 	w.Write(srv.plots.T.Bytes())
 }
 
+func (srv *server) handlePlotDerived(w http.ResponseWriter, r *http.Request) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	buf, ok := srv.plots.Derived[r.FormValue("key")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("content-type", "image/png")
+	w.Write(buf.Bytes())
+}
+
+// loop records the measurements of the device as they are made, over
+// the persistent connection of the manager.
 func (srv *server) loop() {
-	var (
-		interval time.Duration
-		err      error
-	)
-	err = retry(5, func() error {
-		interval, err = srv.interval()
-		return err
-	})
-	if err != nil {
-		log.Panicf("could not fetch refresh frequency: %+v", err)
+	ctx := context.Background()
+	for {
+		ch, err := srv.mgr.Subscribe(ctx)
+		if err != nil {
+			log.Panicf("could not subscribe to measurements: %+v", err)
+		}
+		log.Printf("waiting for measurements...")
+		for data := range ch {
+			err := srv.observe(data, srv.fetchRows)
+			if err != nil {
+				log.Printf("could not record sample: %+v", err)
+			}
+		}
+		log.Printf("subscription ended: %+v", srv.mgr.Err())
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (srv *server) listen() {
+	// only connect to the device to backfill the history, so it keeps
+	// on advertising.
+	fetch := func() ([]aranet4.Data, error) {
+		defer srv.mgr.Disconnect()
+		return srv.fetchRows()
+	}
+	observe := func(data aranet4.Data) {
+		err := srv.observe(data, fetch)
+		if err != nil {
+			log.Printf("could not record advertised sample: %+v", err)
+		}
 	}
 
-	log.Printf("refresh frequency: %v", interval)
-	tck := time.NewTicker(interval)
-	defer tck.Stop()
+	if srv.emu != nil {
+		log.Printf("listening for advertisements from software device...")
+		tck := time.NewTicker(time.Second)
+		defer tck.Stop()
+		for range tck.C {
+			data, err := aranet4.ParseAdvertisement(srv.emu.Advertisement())
+			if err != nil {
+				log.Printf("could not parse advertisement: %+v", err)
+				continue
+			}
+			observe(data)
+		}
+		return
+	}
 
-	log.Printf("fetching history data...")
-	err = retry(5, func() error {
-		return srv.update(-1)
+	log.Printf("listening for advertisements from %q...", srv.addr)
+	err := aranet4.Listen(context.Background(), func(addr string, data aranet4.Data) {
+		if addr != srv.addr {
+			return
+		}
+		observe(data)
 	})
 	if err != nil {
-		log.Printf("could not update db: %+v", err)
-	}
-	log.Printf("starting loop...")
-	for range tck.C {
-		log.Printf("tick: %s", time.Now().UTC().Format("2006-01-02 15:04:05"))
-		err := retry(5, func() error {
-			return srv.update(1)
-		})
-		if err != nil {
-			log.Printf("could not update db: %+v", err)
-		}
+		log.Panicf("could not listen for advertisements: %+v", err)
 	}
 }
 
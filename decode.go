@@ -0,0 +1,146 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DecodeCurrent decodes the current measurements of a device, as read from
+// its "current readings" characteristic.
+//
+// The time-stamp of the returned sample is computed from the current time
+// and the elapsed time since the measurement, as reported by the device.
+// Measurements flagged as not available by the device are recorded in
+// the Missing field of the returned sample, and the status reported by
+// the device in its Status field.
+func DecodeCurrent(p []byte) (Data, error) {
+	return DecodeCurrentAt(p, time.Now())
+}
+
+// DecodeCurrentAt is like DecodeCurrent, but computes the time-stamp of
+// the returned sample relative to now, the time the measurements were
+// read.
+func DecodeCurrentAt(p []byte, now time.Time) (Data, error) {
+	var (
+		data Data
+		dec  = newDecoderAt(p, now)
+	)
+	err := dec.readCurrent(&data)
+	if err != nil {
+		return data, protocolError("decode current readings", p, err)
+	}
+	return data, nil
+}
+
+// DecodeCurrentDetailed decodes the current measurements of an Aranet2,
+// Aranet Radiation or Aranet Radon device, as read from its "detailed
+// current readings" characteristic.
+// The model of the device is recorded in the returned sample.
+//
+// As with DecodeCurrent, the time-stamp of the returned sample is
+// computed from the current time.
+func DecodeCurrentDetailed(p []byte) (Data, error) {
+	return DecodeCurrentDetailedAt(p, time.Now())
+}
+
+// DecodeCurrentDetailedAt is like DecodeCurrentDetailed, but computes the
+// time-stamp of the returned sample relative to now.
+func DecodeCurrentDetailedAt(p []byte, now time.Time) (Data, error) {
+	var (
+		data Data
+		dec  = newDecoderAt(p, now)
+	)
+	err := dec.readCurrentDetailed(&data)
+	if err != nil {
+		return data, protocolError("decode current readings", p, err)
+	}
+	return data, nil
+}
+
+// DecodeInterval decodes a duration, as read from the "interval" and
+// "seconds since update" characteristics of a device.
+func DecodeInterval(p []byte) (time.Duration, error) {
+	var (
+		v   time.Duration
+		dec = newDecoder(p)
+	)
+	err := dec.readInterval(&v)
+	if err != nil {
+		return 0, protocolError("decode interval", p, err)
+	}
+	return v, nil
+}
+
+// HistoryChunk is a chunk of the history of a parameter, as notified by
+// a device on its time series characteristic.
+type HistoryChunk struct {
+	Param Param // parameter of the history
+	Start int   // index of the first sample in the chunk, starting at 1
+	Count int   // number of samples in the chunk
+
+	values []byte // encoded samples
+}
+
+// End reports whether the chunk marks the end of the history.
+func (c HistoryChunk) End() bool { return c.Count == 0 }
+
+// Decode decodes the i-th sample of the chunk into the field of dst
+// corresponding to the parameter of the chunk.
+// Samples flagged as not available by the device are recorded in
+// dst.Missing.
+func (c HistoryChunk) Decode(i int, dst *Data) error {
+	if i < 0 || i >= c.Count {
+		return fmt.Errorf("aranet4: history sample index %d out of range [0, %d)", i, c.Count)
+	}
+	size := c.Param.size()
+	dec := newDecoder(c.values[i*size : (i+1)*size])
+	return dec.readField(c.Param, dst)
+}
+
+// size returns the size in bytes of one encoded sample of the parameter.
+func (p Param) size() int {
+	switch p {
+	case ParamH:
+		return 1
+	case ParamDoseRate, ParamRadon:
+		return 4
+	case ParamDose:
+		return 8
+	default:
+		return 2
+	}
+}
+
+// DecodeHistoryChunk decodes a chunk of history.
+// The returned chunk references p.
+func DecodeHistoryChunk(p []byte) (HistoryChunk, error) {
+	if len(p) < 4 {
+		return HistoryChunk{}, protocolError("decode history chunk", p, fmt.Errorf("too short: %w", io.ErrUnexpectedEOF))
+	}
+
+	c := HistoryChunk{
+		Param: Param(p[0]),
+		Start: int(binary.LittleEndian.Uint16(p[1:])),
+		Count: int(p[3]),
+	}
+	switch c.Param {
+	case ParamT, ParamH, ParamP, ParamCO2, ParamH2, ParamDoseRate, ParamDose, ParamRadon:
+	default:
+		return HistoryChunk{}, protocolError("decode history chunk", p, fmt.Errorf("invalid parameter %d", p[0]))
+	}
+
+	n := c.Count * c.Param.size()
+	if len(p)-4 < n {
+		return HistoryChunk{}, protocolError("decode history chunk", p, fmt.Errorf(
+			"too short for %d samples of %v: %w", c.Count, c.Param, io.ErrUnexpectedEOF,
+		))
+	}
+	c.values = p[4 : 4+n]
+	return c, nil
+}
@@ -0,0 +1,75 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package aranet4
+
+import (
+	"testing"
+)
+
+func FuzzDecodeCurrent(f *testing.F) {
+	f.Add(advPayload[advHeaderSize:])
+	f.Add([]byte{0x00, 0x80, 0x00, 0x40, 0x00, 0x80, 0x1d, 0x60, 0x00, 0x3c, 0x00, 0x00, 0x00})
+	f.Fuzz(func(t *testing.T, p []byte) {
+		data, err := DecodeCurrent(p)
+		if err != nil {
+			if len(p) >= advDataSize {
+				t.Fatalf("could not decode %x: %+v", p, err)
+			}
+			return
+		}
+		if len(p) < advDataSize {
+			t.Fatalf("decoded short payload %x", p)
+		}
+		if data.Missing&^(FieldT|FieldP|FieldCO2) != 0 {
+			t.Fatalf("invalid missing fields %v for %x", data.Missing, p)
+		}
+	})
+}
+
+func FuzzDecodeCurrentDetailed(f *testing.F) {
+	f.Add(aranet2Payload)
+	f.Add(radiationPayload)
+	f.Add(radonPayload)
+	f.Fuzz(func(t *testing.T, p []byte) {
+		data, err := DecodeCurrentDetailed(p)
+		if err != nil {
+			return
+		}
+		if data.Model.Fields()&FieldCO2 != 0 {
+			t.Fatalf("invalid model %v for %x", data.Model, p)
+		}
+	})
+}
+
+func FuzzDecodeHistoryChunk(f *testing.F) {
+	f.Add([]byte{0x01, 0x2a, 0x00, 0x02, 0x8f, 0x01, 0x00, 0x40})
+	f.Add([]byte{0x02, 0x01, 0x01, 0x03, 0x1d, 0x1e, 0x1f})
+	f.Add([]byte{0x04, 0x07, 0x00, 0x00})
+	f.Add([]byte{0x09, 0x01, 0x00, 0x01, 0x40, 0x4b, 0x4c, 0x00, 0x00, 0x00, 0x00, 0x00})
+	f.Fuzz(func(t *testing.T, p []byte) {
+		chunk, err := DecodeHistoryChunk(p)
+		if err != nil {
+			return
+		}
+		var data Data
+		for i := 0; i < chunk.Count; i++ {
+			err := chunk.Decode(i, &data)
+			if err != nil {
+				t.Fatalf("could not decode sample %d of %x: %+v", i, p, err)
+			}
+		}
+	})
+}
+
+func FuzzParseAdvertisement(f *testing.F) {
+	f.Add(advPayload)
+	f.Add(advPayload[:advHeaderSize])
+	f.Fuzz(func(t *testing.T, p []byte) {
+		_, _ = ParseAdvertisement(p)
+	})
+}
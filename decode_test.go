@@ -0,0 +1,250 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeCurrent(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		want Data
+		ago  time.Duration
+		err  error
+	}{
+		{
+			name: "full",
+			raw:  advPayload[advHeaderSize : advHeaderSize+advDataSize],
+			want: Data{
+				H: 29, P: 980.5, T: 19.95,
+				CO2:      570,
+				Battery:  96,
+				Status:   1,
+				Interval: 5 * time.Minute,
+				Model:    ModelAranet4,
+			},
+			ago: 2 * time.Minute,
+		},
+		{
+			name: "missing",
+			raw: []byte{
+				0x00, 0x80, // CO2: n/a
+				0x00, 0x40, // T: n/a
+				0x00, 0x80, // P: n/a
+				0x1d,       // H: 29%
+				0x60,       // battery: 96%
+				0x00,       // quality: n/a
+				0x3c, 0x00, // interval: 60s
+				0x00, 0x00, // ago: 0s
+			},
+			want: Data{
+				H:        29,
+				Battery:  96,
+				Interval: time.Minute,
+				Model:    ModelAranet4,
+				Missing:  FieldT | FieldP | FieldCO2,
+			},
+		},
+		{
+			name: "short",
+			raw:  advPayload[advHeaderSize : advHeaderSize+advDataSize-1],
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "empty",
+			err:  io.ErrUnexpectedEOF,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			beg := time.Now().UTC()
+			got, err := DecodeCurrent(tc.raw)
+			end := time.Now().UTC()
+			switch {
+			case tc.err != nil:
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("invalid error: got=%+v, want=%+v", err, tc.err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not decode: %+v", err)
+			}
+
+			if got.Time.Before(beg.Add(-tc.ago)) || got.Time.After(end.Add(-tc.ago)) {
+				t.Fatalf("invalid time-stamp: %v", got.Time)
+			}
+			got.Time = time.Time{}
+			if got != tc.want {
+				t.Fatalf("invalid data:\ngot= %#v\nwant=%#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeInterval(t *testing.T) {
+	for _, tc := range []struct {
+		raw  []byte
+		want time.Duration
+		err  error
+	}{
+		{raw: []byte{0x2c, 0x01}, want: 5 * time.Minute},
+		{raw: []byte{0x78, 0x00, 0xff}, want: 2 * time.Minute},
+		{raw: []byte{0xff, 0xff}, want: 65535 * time.Second},
+		{raw: []byte{0x2c}, err: io.ErrUnexpectedEOF},
+		{raw: nil, err: io.ErrUnexpectedEOF},
+	} {
+		got, err := DecodeInterval(tc.raw)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%x: invalid error: got=%+v, want=%+v", tc.raw, err, tc.err)
+		}
+		if got != tc.want {
+			t.Fatalf("%x: invalid interval: got=%v, want=%v", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeHistoryChunk(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		raw   []byte
+		param Param
+		start int
+		want  []Data
+		err   string
+	}{
+		{
+			name:  "T",
+			raw:   []byte{0x01, 0x2a, 0x00, 0x02, 0x8f, 0x01, 0x00, 0x40},
+			param: ParamT,
+			start: 42,
+			want:  []Data{{T: 19.95}, {Missing: FieldT}},
+		},
+		{
+			name:  "H",
+			raw:   []byte{0x02, 0x01, 0x01, 0x03, 0x1d, 0x1e, 0x1f},
+			param: ParamH,
+			start: 257,
+			want:  []Data{{H: 29}, {H: 30}, {H: 31}},
+		},
+		{
+			name:  "P",
+			raw:   []byte{0x03, 0x01, 0x00, 0x01, 0x4d, 0x26, 0xff},
+			param: ParamP,
+			start: 1,
+			want:  []Data{{P: 980.5}},
+		},
+		{
+			name:  "CO2",
+			raw:   []byte{0x04, 0x05, 0x00, 0x02, 0x3a, 0x02, 0x00, 0x80},
+			param: ParamCO2,
+			start: 5,
+			want:  []Data{{CO2: 570}, {Missing: FieldCO2}},
+		},
+		{
+			name:  "end",
+			raw:   []byte{0x04, 0x07, 0x00, 0x00},
+			param: ParamCO2,
+			start: 7,
+		},
+		{
+			name: "short-header",
+			raw:  []byte{0x04, 0x07, 0x00},
+			err:  "too short",
+		},
+		{
+			name: "short-values",
+			raw:  []byte{0x04, 0x05, 0x00, 0x02, 0x3a, 0x02, 0x00},
+			err:  "too short for 2 samples of CO2",
+		},
+		{
+			name: "invalid-param",
+			raw:  []byte{0x07, 0x05, 0x00, 0x00},
+			err:  "invalid parameter 7",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chunk, err := DecodeHistoryChunk(tc.raw)
+			switch {
+			case tc.err != "":
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("invalid error: got=%v, want=%q", err, tc.err)
+				}
+				var perr *ProtocolError
+				if !errors.As(err, &perr) {
+					t.Fatalf("invalid error type: got=%T, want=%T", err, perr)
+				}
+				if got, want := perr.Data, tc.raw; !bytes.Equal(got, want) {
+					t.Fatalf("invalid offending bytes: got=%x, want=%x", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not decode chunk: %+v", err)
+			}
+
+			if got, want := chunk.Param, tc.param; got != want {
+				t.Fatalf("invalid param: got=%v, want=%v", got, want)
+			}
+			if got, want := chunk.Start, tc.start; got != want {
+				t.Fatalf("invalid start: got=%d, want=%d", got, want)
+			}
+			if got, want := chunk.Count, len(tc.want); got != want {
+				t.Fatalf("invalid count: got=%d, want=%d", got, want)
+			}
+			if got, want := chunk.End(), len(tc.want) == 0; got != want {
+				t.Fatalf("invalid end: got=%v, want=%v", got, want)
+			}
+
+			for i, want := range tc.want {
+				var got Data
+				err := chunk.Decode(i, &got)
+				if err != nil {
+					t.Fatalf("could not decode sample %d: %+v", i, err)
+				}
+				if got != want {
+					t.Fatalf("invalid sample %d:\ngot= %#v\nwant=%#v", i, got, want)
+				}
+			}
+
+			err = chunk.Decode(chunk.Count, new(Data))
+			if err == nil {
+				t.Fatalf("expected an error decoding past the end of the chunk")
+			}
+		})
+	}
+}
+
+func TestDecodeAllocs(t *testing.T) {
+	var (
+		cur   = advPayload[advHeaderSize : advHeaderSize+advDataSize]
+		chunk = []byte{0x01, 0x2a, 0x00, 0x02, 0x8f, 0x01, 0x00, 0x40}
+		data  Data
+	)
+	for _, tc := range []struct {
+		name string
+		f    func()
+	}{
+		{"current", func() { data, _ = DecodeCurrent(cur) }},
+		{"interval", func() { data.Interval, _ = DecodeInterval(cur[9:]) }},
+		{"chunk", func() {
+			c, _ := DecodeHistoryChunk(chunk)
+			for i := 0; i < c.Count; i++ {
+				_ = c.Decode(i, &data)
+			}
+		}},
+		{"advertisement", func() { data, _ = ParseAdvertisement(advPayload) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := testing.AllocsPerRun(100, tc.f); got != 0 {
+				t.Fatalf("invalid number of allocations: got=%v, want=0", got)
+			}
+		})
+	}
+}
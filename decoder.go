@@ -6,67 +6,184 @@ package aranet4
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"time"
 )
 
+// decoder decodes little-endian values from a byte slice.
 type decoder struct {
-	r   io.Reader
-	buf []byte
+	p   []byte
 	err error
+	now time.Time // reference time of the decoded time-stamps
 }
 
-func newDecoder(r io.Reader) *decoder {
-	return &decoder{
-		r:   r,
-		buf: make([]byte, 2),
+func newDecoder(p []byte) decoder {
+	return decoder{p: p}
+}
+
+// newDecoderAt returns a decoder computing time-stamps relative to now.
+func newDecoderAt(p []byte, now time.Time) decoder {
+	return decoder{p: p, now: now}
+}
+
+func (dec *decoder) load1() (byte, error) {
+	if dec.err != nil {
+		return 0, dec.err
 	}
+	if len(dec.p) < 1 {
+		dec.err = io.ErrUnexpectedEOF
+		return 0, dec.err
+	}
+	v := dec.p[0]
+	dec.p = dec.p[1:]
+	return v, nil
 }
 
-func (dec *decoder) load1() error {
+func (dec *decoder) load2() (uint16, error) {
 	if dec.err != nil {
-		return dec.err
+		return 0, dec.err
 	}
-	_, dec.err = io.ReadFull(dec.r, dec.buf[:1])
-	return dec.err
+	if len(dec.p) < 2 {
+		dec.err = io.ErrUnexpectedEOF
+		return 0, dec.err
+	}
+	v := binary.LittleEndian.Uint16(dec.p)
+	dec.p = dec.p[2:]
+	return v, nil
 }
 
-func (dec *decoder) load2() error {
+func (dec *decoder) load4() (uint32, error) {
 	if dec.err != nil {
-		return dec.err
+		return 0, dec.err
 	}
-	_, dec.err = io.ReadFull(dec.r, dec.buf[:2])
-	return dec.err
+	if len(dec.p) < 4 {
+		dec.err = io.ErrUnexpectedEOF
+		return 0, dec.err
+	}
+	v := binary.LittleEndian.Uint32(dec.p)
+	dec.p = dec.p[4:]
+	return v, nil
 }
 
-func (dec *decoder) readField(id byte, v *Data) error {
+func (dec *decoder) load8() (uint64, error) {
+	if dec.err != nil {
+		return 0, dec.err
+	}
+	if len(dec.p) < 8 {
+		dec.err = io.ErrUnexpectedEOF
+		return 0, dec.err
+	}
+	v := binary.LittleEndian.Uint64(dec.p)
+	dec.p = dec.p[8:]
+	return v, nil
+}
+
+// readField decodes the value of the parameter id into v.
+// Values flagged as not available by the device are recorded in v.Missing,
+// and decoding may carry on.
+func (dec *decoder) readField(id Param, v *Data) error {
 	if dec.err != nil {
 		return dec.err
 	}
+	var (
+		f   Field
+		err error
+	)
 	switch id {
-	case paramT:
-		return dec.readT(&v.T)
-	case paramH:
-		return dec.readH(&v.H)
-	case paramP:
-		return dec.readP(&v.P)
-	case paramCO2:
-		return dec.readCO2(&v.CO2)
+	case ParamT:
+		f, err = FieldT, dec.readT(&v.T)
+	case ParamH:
+		f, err = FieldH, dec.readH(&v.H)
+	case ParamP:
+		f, err = FieldP, dec.readP(&v.P)
+	case ParamCO2:
+		f, err = FieldCO2, dec.readCO2(&v.CO2)
+	case ParamH2:
+		f, err = FieldH, dec.readH2(&v.H)
+	case ParamDoseRate:
+		f, err = FieldDoseRate, dec.readDoseRate(&v.DoseRate)
+	case ParamDose:
+		f, err = FieldDose, dec.readDose(&v.Dose)
+	case ParamRadon:
+		f, err = FieldRadon, dec.readRadon(&v.Radon)
 	default:
 		return fmt.Errorf("unknown field id=%d", id)
 	}
+	if errors.Is(err, ErrNoData) {
+		v.Missing |= f
+		return nil
+	}
+	return err
+}
+
+// readCurrent decodes a data sample laid out as the uuidReadAll
+// characteristic.
+func (dec *decoder) readCurrent(v *Data) error {
+	v.Model = ModelAranet4
+	for _, id := range [...]Param{ParamCO2, ParamT, ParamP, ParamH} {
+		dec.readField(id, v)
+	}
+	dec.readBattery(&v.Battery)
+	dec.readQuality(&v.Status)
+	dec.readInterval(&v.Interval)
+	dec.readTime(&v.Time)
+	return dec.err
+}
+
+// readCurrentDetailed decodes a data sample laid out as the
+// uuidReadAllDetailed characteristic, used by Aranet devices other than
+// Aranet4:
+//   - device type (uint16),
+//   - measurement interval and elapsed time since the measurement, in
+//     seconds (uint16 each),
+//   - battery level (uint8),
+//   - model specific measurements,
+//   - status (uint8).
+func (dec *decoder) readCurrentDetailed(v *Data) error {
+	typ, _ := dec.load2()
+	dec.readInterval(&v.Interval)
+	dec.readTime(&v.Time)
+	dec.readBattery(&v.Battery)
+	if dec.err != nil {
+		return dec.err
+	}
+
+	var params []Param
+	v.Model = modelFromType(int(typ))
+	switch v.Model {
+	case ModelAranet2:
+		params = []Param{ParamT, ParamH2}
+	case ModelAranetRadiation:
+		params = []Param{ParamDoseRate, ParamDose}
+	case ModelAranetRadon:
+		params = []Param{ParamT, ParamP, ParamH2, ParamRadon}
+	default:
+		return fmt.Errorf("unknown device type %d", typ)
+	}
+	for _, id := range params {
+		dec.readField(id, v)
+	}
+	if v.Model == ModelAranetRadiation {
+		_, _ = dec.load4() // duration of the dose measurement, in seconds
+	}
+	status, _ := dec.load1()
+	if v.Model == ModelAranetRadon {
+		v.Status = Quality(status)
+	}
+	return dec.err
 }
 
 func (dec *decoder) readCO2(v *int) error {
-	err := dec.load2()
+	vv, err := dec.load2()
 	if err != nil {
 		return err
 	}
 
-	vv := binary.LittleEndian.Uint16(dec.buf)
 	switch vv & 0x8000 {
 	case 0x8000:
+		*v = 0
 		return ErrNoData
 	default:
 		*v = int(vv)
@@ -76,33 +193,32 @@ func (dec *decoder) readCO2(v *int) error {
 }
 
 func (dec *decoder) readT(v *float64) error {
-	err := dec.load2()
+	vv, err := dec.load2()
 	if err != nil {
 		return err
 	}
 
-	vv := binary.LittleEndian.Uint16(dec.buf)
-	switch {
-	case vv == 0x4000:
-		return ErrNoData
-	case vv > 0x8000:
+	// temperatures are signed, in 0.05°C.
+	switch vv {
+	case 0x4000, 0x8000:
 		*v = 0
+		return ErrNoData
 	default:
-		*v = float64(vv) / 20
+		*v = float64(int16(vv)) / 20
 	}
 
 	return nil
 }
 
 func (dec *decoder) readP(v *float64) error {
-	err := dec.load2()
+	vv, err := dec.load2()
 	if err != nil {
 		return err
 	}
 
-	vv := binary.LittleEndian.Uint16(dec.buf)
 	switch {
 	case vv&0x8000 == 0x8000:
+		*v = 0
 		return ErrNoData
 	default:
 		*v = float64(vv) / 10
@@ -111,50 +227,99 @@ func (dec *decoder) readP(v *float64) error {
 }
 
 func (dec *decoder) readH(v *float64) error {
-	err := dec.load1()
+	vv, err := dec.load1()
+	if err != nil {
+		return err
+	}
+
+	*v = float64(vv)
+	return nil
+}
+
+func (dec *decoder) readH2(v *float64) error {
+	vv, err := dec.load2()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case vv&0x8000 == 0x8000:
+		*v = 0
+		return ErrNoData
+	default:
+		*v = float64(vv) / 10
+	}
+	return nil
+}
+
+func (dec *decoder) readRadon(v *int) error {
+	vv, err := dec.load4()
+	if err != nil {
+		return err
+	}
+	*v = int(vv)
+	return nil
+}
+
+// readDoseRate decodes a dose rate in nSv/h, into µSv/h.
+func (dec *decoder) readDoseRate(v *float64) error {
+	vv, err := dec.load4()
 	if err != nil {
 		return err
 	}
+	*v = float64(vv) / 1e3
+	return nil
+}
 
-	*v = float64(dec.buf[0])
+// readDose decodes a dose in nSv, into mSv.
+func (dec *decoder) readDose(v *float64) error {
+	vv, err := dec.load8()
+	if err != nil {
+		return err
+	}
+	*v = float64(vv) / 1e6
 	return nil
 }
 
 func (dec *decoder) readBattery(v *int) error {
-	err := dec.load1()
+	vv, err := dec.load1()
 	if err != nil {
 		return err
 	}
-	*v = int(dec.buf[0])
+	*v = int(vv)
 	return nil
 }
 
 func (dec *decoder) readQuality(v *Quality) error {
-	err := dec.load1()
+	vv, err := dec.load1()
 	if err != nil {
 		return err
 	}
-	*v = Quality(dec.buf[0])
+	*v = Quality(vv)
 	return nil
 }
 
 func (dec *decoder) readInterval(v *time.Duration) error {
-	err := dec.load2()
+	vv, err := dec.load2()
 	if err != nil {
 		return err
 	}
 
-	*v = time.Duration(binary.LittleEndian.Uint16(dec.buf)) * time.Second
+	*v = time.Duration(vv) * time.Second
 	return nil
 }
 
 func (dec *decoder) readTime(v *time.Time) error {
-	err := dec.load2()
+	vv, err := dec.load2()
 	if err != nil {
 		return err
 	}
 
-	ago := time.Duration(binary.LittleEndian.Uint16(dec.buf)) * time.Second
-	*v = time.Now().UTC().Add(-ago)
+	now := dec.now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	ago := time.Duration(vv) * time.Second
+	*v = now.UTC().Add(-ago)
 	return nil
 }
@@ -0,0 +1,160 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package derive computes environmental metrics derived from the
+// quantities measured by Aranet devices, such as the dew point or the
+// absolute humidity.
+//
+// Temperatures are in degrees Celsius, relative humidities in percent and
+// pressures in hPa, as reported by the devices.
+package derive // import "sbinet.org/x/aranet4/derive"
+
+import (
+	"math"
+)
+
+const (
+	// StandardPressure is the standard atmospheric pressure at sea level,
+	// in hPa.
+	StandardPressure = 1013.25
+
+	// Coefficients of the Magnus formula, from Alduchov and Eskridge (1996),
+	// valid within 0.4% between -40°C and 50°C.
+	magnusA = 6.1094 // hPa
+	magnusB = 17.625
+	magnusC = 243.04 // °C
+
+	zeroC = 273.15     // 0°C, in K
+	rv    = 461.5      // specific gas constant of water vapour, in J/(kg.K)
+	baroK = 2.25577e-5 // lapse rate factor of the barometric formula, in 1/m
+)
+
+// SaturationVaporPressure returns the saturation vapour pressure of water
+// over a flat surface of liquid water at temperature t, in hPa.
+//
+// It uses the Magnus formula:
+//
+//	es(t) = 6.1094 exp(17.625 t / (t + 243.04))
+func SaturationVaporPressure(t float64) float64 {
+	return magnusA * math.Exp(magnusB*t/(t+magnusC))
+}
+
+// VaporPressure returns the partial pressure of water vapour in air at
+// temperature t and relative humidity rh, in hPa.
+//
+//	e(t, rh) = rh/100 es(t)
+func VaporPressure(t, rh float64) float64 {
+	return rh / 100 * SaturationVaporPressure(t)
+}
+
+// DewPoint returns the temperature, in °C, to which air at temperature t
+// and relative humidity rh must be cooled to become saturated with water
+// vapour.
+//
+// It inverts the Magnus formula:
+//
+//	γ  = ln(rh/100) + 17.625 t / (t + 243.04)
+//	td = 243.04 γ / (17.625 - γ)
+//
+// DewPoint returns NaN if rh is not positive.
+func DewPoint(t, rh float64) float64 {
+	if rh <= 0 {
+		return math.NaN()
+	}
+	g := math.Log(rh/100) + magnusB*t/(t+magnusC)
+	return magnusC * g / (magnusB - g)
+}
+
+// AbsoluteHumidity returns the mass of water vapour per volume of air at
+// temperature t and relative humidity rh, in g/m³.
+//
+// It follows from the ideal gas law, with e the vapour pressure in Pa and
+// Rv = 461.5 J/(kg.K) the specific gas constant of water vapour:
+//
+//	ah = 1000 e / (Rv (t + 273.15))
+func AbsoluteHumidity(t, rh float64) float64 {
+	e := 100 * VaporPressure(t, rh)
+	return 1000 * e / (rv * (t + zeroC))
+}
+
+// HeatIndex returns the temperature, in °C, perceived by humans in air at
+// temperature t and relative humidity rh.
+//
+// It follows the algorithm of the US National Weather Service: the
+// simple formula of Steadman is used below 80°F, and the regression of
+// Rothfusz (1990), with its adjustments for low and high humidities,
+// above.
+// The heat index is only meaningful for warm temperatures.
+func HeatIndex(t, rh float64) float64 {
+	f := t*9/5 + 32
+
+	hi := 0.5 * (f + 61 + (f-68)*1.2 + rh*0.094)
+	if (hi+f)/2 >= 80 {
+		hi = -42.379 +
+			2.04901523*f +
+			10.14333127*rh -
+			0.22475541*f*rh -
+			6.83783e-3*f*f -
+			5.481717e-2*rh*rh +
+			1.22874e-3*f*f*rh +
+			8.5282e-4*f*rh*rh -
+			1.99e-6*f*f*rh*rh
+
+		switch {
+		case rh < 13 && f >= 80 && f <= 112:
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(f-95))/17)
+		case rh > 85 && f >= 80 && f <= 87:
+			hi += (rh - 85) / 10 * (87 - f) / 5
+		}
+	}
+
+	return (hi - 32) * 5 / 9
+}
+
+// Humidex returns the humidex of Environment Canada for air at
+// temperature t and relative humidity rh, a dimensionless number close to
+// the temperature, in °C, felt by humans.
+//
+// It uses the vapour pressure e, in hPa:
+//
+//	humidex = t + 0.5555 (e - 10)
+func Humidex(t, rh float64) float64 {
+	return t + 0.5555*(VaporPressure(t, rh)-10)
+}
+
+// VaporPressureDeficit returns the difference between the saturation
+// vapour pressure and the vapour pressure of air at temperature t and
+// relative humidity rh, in kPa, as customary in horticulture.
+//
+//	vpd = (1 - rh/100) es(t) / 10
+func VaporPressureDeficit(t, rh float64) float64 {
+	return (1 - rh/100) * SaturationVaporPressure(t) / 10
+}
+
+// PressureAtAltitude returns the standard atmospheric pressure at the
+// altitude h above sea level, in meters, in hPa.
+//
+// It uses the barometric formula of the international standard
+// atmosphere, valid in the troposphere:
+//
+//	p(h) = 1013.25 (1 - 2.25577e-5 h)^5.25588
+func PressureAtAltitude(h float64) float64 {
+	return StandardPressure * math.Pow(1-baroK*h, 5.25588)
+}
+
+// CO2Corrected returns the CO2 concentration, in ppm, read by a sensor
+// calibrated at the standard pressure, corrected for the atmospheric
+// pressure p, in hPa.
+//
+// Non-dispersive infrared sensors measure the density of CO2 molecules,
+// which scales with the pressure of the air:
+//
+//	co2(p) = co2 1013.25 / p
+//
+// The pressure can be estimated from the altitude with PressureAtAltitude.
+// Readings already compensated for pressure by the device must not be
+// corrected again.
+func CO2Corrected(co2, p float64) float64 {
+	return co2 * StandardPressure / p
+}
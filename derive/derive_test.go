@@ -0,0 +1,154 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package derive
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+func celsius(f float64) float64 { return (f - 32) * 5 / 9 }
+
+// rhFromDewPoint returns the relative humidity of air at temperature t
+// with the dew point td.
+func rhFromDewPoint(t, td float64) float64 {
+	return 100 * SaturationVaporPressure(td) / SaturationVaporPressure(t)
+}
+
+func TestReferenceValues(t *testing.T) {
+	// reference values are rounded as in the tables they come from.
+	for _, tc := range []struct {
+		name string
+		got  float64
+		want float64
+		tol  float64
+	}{
+		// dew point calculators of the NOAA and Vaisala.
+		{"dew-point-25c-60pct", DewPoint(25, 60), 16.7, 0.05},
+		{"dew-point-20c-50pct", DewPoint(20, 50), 9.3, 0.05},
+		{"dew-point-saturated", DewPoint(21.5, 100), 21.5, 1e-9},
+		// absolute humidity tables.
+		{"abs-humidity-25c-60pct", AbsoluteHumidity(25, 60), 13.8, 0.05},
+		{"abs-humidity-20c-50pct", AbsoluteHumidity(20, 50), 8.6, 0.05},
+		{"abs-humidity-30c-100pct", AbsoluteHumidity(30, 100), 30.3, 0.05},
+		// heat index chart of the US National Weather Service, in °F.
+		{"heat-index-90f-70pct", HeatIndex(celsius(90), 70), celsius(106), 0.5 * 5 / 9},
+		{"heat-index-96f-65pct", HeatIndex(celsius(96), 65), celsius(121), 0.5 * 5 / 9},
+		{"heat-index-80f-40pct", HeatIndex(celsius(80), 40), celsius(80), 0.5 * 5 / 9},
+		{"heat-index-86f-90pct", HeatIndex(celsius(86), 90), celsius(105), 0.5 * 5 / 9},
+		{"heat-index-70f-50pct", HeatIndex(celsius(70), 50), celsius(69), 0.5 * 5 / 9},
+		// humidex table of Environment Canada, from the dew point.
+		{"humidex-30c-15c", Humidex(30, rhFromDewPoint(30, 15)), 34, 0.5},
+		{"humidex-35c-25c", Humidex(35, rhFromDewPoint(35, 25)), 47, 0.5},
+		{"humidex-25c-20c", Humidex(25, rhFromDewPoint(25, 20)), 32, 0.5},
+		// vapour pressure deficit charts.
+		{"vpd-25c-60pct", VaporPressureDeficit(25, 60), 1.26, 0.01},
+		{"vpd-20c-80pct", VaporPressureDeficit(20, 80), 0.47, 0.005},
+		{"vpd-saturated", VaporPressureDeficit(20, 100), 0, 1e-12},
+		// international standard atmosphere.
+		{"pressure-0m", PressureAtAltitude(0), 1013.25, 1e-9},
+		{"pressure-1000m", PressureAtAltitude(1000), 898.76, 0.05},
+		{"pressure-2000m", PressureAtAltitude(2000), 794.95, 0.05},
+		{"co2-sea-level", CO2Corrected(800, StandardPressure), 800, 1e-9},
+		{"co2-900hpa", CO2Corrected(800, 900), 900.67, 0.05},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if math.Abs(tc.got-tc.want) > tc.tol {
+				t.Fatalf("invalid value: got=%v, want=%v±%v", tc.got, tc.want, tc.tol)
+			}
+		})
+	}
+}
+
+func TestDewPointInvalid(t *testing.T) {
+	for _, rh := range []float64{0, -1} {
+		if got := DewPoint(20, rh); !math.IsNaN(got) {
+			t.Fatalf("invalid dew point for rh=%v: got=%v, want=NaN", rh, got)
+		}
+	}
+}
+
+func TestMetricEval(t *testing.T) {
+	data := aranet4.Data{
+		Model:    aranet4.ModelAranet4,
+		T:        25,
+		H:        60,
+		P:        900,
+		CO2:      800,
+		Interval: 5 * time.Minute,
+	}
+
+	for _, tc := range []struct {
+		key  string
+		data aranet4.Data
+		want float64
+		ok   bool
+	}{
+		{key: "dew_point_c", data: data, want: DewPoint(25, 60), ok: true},
+		{key: "abs_humidity_gm3", data: data, want: AbsoluteHumidity(25, 60), ok: true},
+		{key: "heat_index_c", data: data, want: HeatIndex(25, 60), ok: true},
+		{key: "humidex", data: data, want: Humidex(25, 60), ok: true},
+		{key: "vpd_kpa", data: data, want: VaporPressureDeficit(25, 60), ok: true},
+		{key: "co2_corrected_ppm", data: data, want: CO2Corrected(800, 900), ok: true},
+		{
+			key: "dew_point_c",
+			data: func() aranet4.Data {
+				v := data
+				v.Missing = aranet4.FieldH
+				return v
+			}(),
+		},
+		{
+			key: "dew_point_c",
+			data: func() aranet4.Data {
+				v := data
+				v.H = 0
+				return v
+			}(),
+		},
+		{
+			key: "co2_corrected_ppm",
+			data: func() aranet4.Data {
+				v := data
+				v.Model = aranet4.ModelAranet2
+				return v
+			}(),
+		},
+	} {
+		t.Run(tc.key, func(t *testing.T) {
+			m, ok := Lookup(tc.key)
+			if !ok {
+				t.Fatalf("could not find metric %q", tc.key)
+			}
+			got, ok := m.Eval(tc.data)
+			if ok != tc.ok {
+				t.Fatalf("invalid validity: got=%v, want=%v", ok, tc.ok)
+			}
+			if got != tc.want {
+				t.Fatalf("invalid value: got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	keys := make(map[string]bool)
+	for _, m := range Metrics() {
+		if keys[m.Key] {
+			t.Fatalf("duplicate metric %q", m.Key)
+		}
+		keys[m.Key] = true
+		if m.Name == "" || m.Fields == 0 || m.eval == nil {
+			t.Fatalf("invalid metric %q: %+v", m.Key, m)
+		}
+	}
+
+	if _, ok := Lookup("not-there"); ok {
+		t.Fatalf("found unknown metric")
+	}
+}
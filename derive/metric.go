@@ -0,0 +1,86 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package derive
+
+import (
+	"math"
+
+	"sbinet.org/x/aranet4"
+)
+
+// Metric describes a metric derived from the quantities of a data sample.
+type Metric struct {
+	Key    string        // machine-readable name, with its unit, e.g. "dew_point_c"
+	Name   string        // human-readable name, e.g. "Dew point"
+	Unit   string        // unit of the metric, e.g. "°C"
+	Fields aranet4.Field // quantities the metric is computed from
+
+	eval func(data aranet4.Data) float64
+}
+
+// Eval computes the metric for the provided data sample.
+// Eval returns false if a quantity needed by the metric is missing or not
+// measured by the model of the device, or if the metric is not defined
+// for the sample.
+func (m Metric) Eval(data aranet4.Data) (float64, bool) {
+	if !data.Valid(m.Fields) {
+		return 0, false
+	}
+	v := m.eval(data)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+var metrics = []Metric{
+	{
+		Key: "dew_point_c", Name: "Dew point", Unit: "°C",
+		Fields: aranet4.FieldT | aranet4.FieldH,
+		eval:   func(data aranet4.Data) float64 { return DewPoint(data.T, data.H) },
+	},
+	{
+		Key: "abs_humidity_gm3", Name: "Absolute humidity", Unit: "g/m³",
+		Fields: aranet4.FieldT | aranet4.FieldH,
+		eval:   func(data aranet4.Data) float64 { return AbsoluteHumidity(data.T, data.H) },
+	},
+	{
+		Key: "heat_index_c", Name: "Heat index", Unit: "°C",
+		Fields: aranet4.FieldT | aranet4.FieldH,
+		eval:   func(data aranet4.Data) float64 { return HeatIndex(data.T, data.H) },
+	},
+	{
+		Key: "humidex", Name: "Humidex", Unit: "",
+		Fields: aranet4.FieldT | aranet4.FieldH,
+		eval:   func(data aranet4.Data) float64 { return Humidex(data.T, data.H) },
+	},
+	{
+		Key: "vpd_kpa", Name: "Vapour pressure deficit", Unit: "kPa",
+		Fields: aranet4.FieldT | aranet4.FieldH,
+		eval:   func(data aranet4.Data) float64 { return VaporPressureDeficit(data.T, data.H) },
+	},
+	{
+		Key: "co2_corrected_ppm", Name: "CO2 corrected for pressure", Unit: "ppm",
+		Fields: aranet4.FieldCO2 | aranet4.FieldP,
+		eval: func(data aranet4.Data) float64 {
+			return CO2Corrected(float64(data.CO2), data.P)
+		},
+	},
+}
+
+// Metrics returns the metrics computed by the package.
+func Metrics() []Metric {
+	return append([]Metric(nil), metrics...)
+}
+
+// Lookup returns the metric with the provided key.
+func Lookup(key string) (Metric, bool) {
+	for _, m := range metrics {
+		if m.Key == key {
+			return m, true
+		}
+	}
+	return Metric{}, false
+}
@@ -5,58 +5,183 @@
 package aranet4
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
-	// "errors"
 	"fmt"
-	// "io"
+	"io"
+	"sort"
+	"sync"
 	"time"
-
-	// "github.com/muka/go-bluetooth/bluez/profile/adapter"
-	// "github.com/muka/go-bluetooth/bluez/profile/device"
-	"tinygo.org/x/bluetooth"
 )
 
+// Device is an Aranet4 device.
 type Device struct {
 	addr string
-	dev  *bluetooth.Device
-	scan bluetooth.ScanResult
-	svc *bluetooth.DeviceService
+	name string
+	tr   Transport
+	dial Dialer // re-establishes the connection after a link loss, if any
+
+	mu     sync.Mutex
+	closed bool
+	stale  bool             // whether the transport was disconnected to abort an operation
+	model  Model            // model of the device, once identified
+	now    func() time.Time // clock of the host
+	grid   time.Time        // time-stamp of a measurement, anchoring the measurement grid
+	policy Policy           // assessment of the air quality of the samples
+
+	cache sync.Mutex // guards the discovered services and characteristics
+	svcs  map[string]Service
+	chars map[string]Characteristic
+
+	timeout time.Duration // maximum duration of a history download, per parameter
+	slack   time.Duration // delay between a measurement and its pick up by subscriptions
+
+	subErr error // error that ended the last subscription
 }
 
+// Dialer establishes a connection to a device.
+type Dialer func(ctx context.Context) (Transport, error)
+
+// New connects to the Aranet4 device with the provided MAC address,
+// using the default Bluetooth adapter.
+//
+// New blocks until the device has been found.
 func New(addr string) (*Device, error) {
-	ad := bluetooth.DefaultAdapter
-	err := ad.Enable()
+	return NewContext(context.Background(), addr)
+}
+
+// NewContext connects to the Aranet4 device with the provided MAC address,
+// using the default Bluetooth adapter.
+//
+// If the context is done before the connection is established, the scan
+// is stopped and NewContext returns the context's error.
+// If the device could not be found before the deadline of the context,
+// the error also matches ErrDeviceNotFound.
+func NewContext(ctx context.Context, addr string) (*Device, error) {
+	bt, err := dialBluetooth(ctx, addr)
 	if err != nil {
-		return nil, fmt.Errorf("could not set default adapter power: %w", err)
+		return nil, err
 	}
 
-	var foundDevice bluetooth.ScanResult
-	err = ad.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
-		if (result.Address.String() != addr) {
-			return
-		}
-		foundDevice = result			
-		// Stop the scan.
-		adapter.StopScan()
-	})
-	if err != nil {
-		return nil, fmt.Errorf("could not start a scan %q: %w", addr, err)
+	dev := NewWithTransport(bt)
+	dev.addr = addr
+	dev.name = bt.name
+	dev.model = modelFromName(bt.name)
+	dev.dial = func(ctx context.Context) (Transport, error) {
+		return dialBluetooth(ctx, addr)
 	}
+	return dev, nil
+}
 
-	dev, err := ad.Connect(foundDevice.Address, bluetooth.ConnectionParams{})
+// NewWithDialer returns a Device communicating with an Aranet4 device
+// through the transports established by dial.
+//
+// dial is called once by NewWithDialer, and then each time the connection
+// needs to be re-established after a link loss.
+func NewWithDialer(ctx context.Context, dial Dialer) (*Device, error) {
+	tr, err := dial(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not connect to %q: %w", addr, err)
+		return nil, fmt.Errorf("could not connect: %w", err)
+	}
+
+	dev := NewWithTransport(tr)
+	dev.dial = dial
+	return dev, nil
+}
+
+// NewWithTransport returns a Device communicating with an Aranet4 device
+// through the provided transport.
+func NewWithTransport(tr Transport) *Device {
+	return &Device{
+		tr:      tr,
+		svcs:    make(map[string]Service),
+		chars:   make(map[string]Characteristic),
+		timeout: historyTimeout,
+		slack:   time.Second,
+		now:     time.Now,
+		policy:  DefaultPolicy(),
 	}
+}
 
-	return &Device{addr: addr, dev: dev}, nil
+// SetClock sets the function returning the current time, used to compute
+// the time-stamps of the measurements from the elapsed times reported by
+// the device.
+// A nil function resets the clock to time.Now.
+func (dev *Device) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.now = now
 }
 
+// SetPolicy sets the policy assessing the air quality of the samples
+// read from the device (default: DefaultPolicy).
+// The status reported by the device is kept in the Status field of its
+// current readings.
+func (dev *Device) SetPolicy(p Policy) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.policy = p
+}
+
+// clock returns the current time.
+func (dev *Device) clock() time.Time {
+	dev.mu.Lock()
+	now := dev.now
+	dev.mu.Unlock()
+	return now().UTC()
+}
+
+// gridTolerance is the maximum distance between a time-stamp and the
+// measurement grid of the device, for that time-stamp to be snapped to
+// the grid.
+// Devices report elapsed times with a one second resolution.
+const gridTolerance = 2 * time.Second
+
+// align snaps the time-stamp t of a measurement to the measurement grid
+// of the device, so that the same measurement gets the same time-stamp
+// each time it is read.
+//
+// The grid is anchored on the first measurement read from the device,
+// and re-anchored whenever a measurement falls off the grid, e.g. after
+// the measurement interval was changed.
+func (dev *Device) align(t time.Time, interval time.Duration) time.Time {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	if !dev.grid.IsZero() && interval > 0 {
+		d := t.Sub(dev.grid)
+		k := d / interval
+		switch r := d - k*interval; {
+		case r > interval/2:
+			k++
+		case r < -interval/2:
+			k--
+		}
+		ref := dev.grid.Add(k * interval)
+		if dt := t.Sub(ref); -gridTolerance <= dt && dt <= gridTolerance {
+			return ref
+		}
+	}
+	dev.grid = t
+	return t
+}
+
+// Close disconnects from the device.
 func (dev *Device) Close() error {
-	if dev.dev == nil {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	if dev.tr == nil || dev.closed {
 		return nil
 	}
-	err := dev.dev.Disconnect()
+	dev.closed = true
+	if dev.stale {
+		return nil
+	}
+	err := dev.tr.Close()
 	if err != nil {
 		return fmt.Errorf("could not disconnect: %w", err)
 	}
@@ -64,244 +189,646 @@ func (dev *Device) Close() error {
 }
 
 func (dev *Device) Name() (string, error) {
-	return dev.scan.LocalName(), nil
+	return dev.name, nil
+}
+
+// reconnect replaces the transport of the device with a new one, after
+// a link loss.
+func (dev *Device) reconnect(ctx context.Context) error {
+	if dev.dial == nil {
+		return fmt.Errorf("could not reconnect: no dialer")
+	}
+
+	dev.cache.Lock()
+	defer dev.cache.Unlock()
+
+	dev.mu.Lock()
+	closed := dev.closed
+	stale := dev.stale
+	old := dev.tr
+	dev.tr = nil
+	dev.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if old != nil && !stale {
+		_ = old.Close()
+	}
+
+	tr, err := dev.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("could not reconnect: %w", err)
+	}
+
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.closed {
+		_ = tr.Close()
+		return ErrClosed
+	}
+	dev.tr = tr
+	dev.stale = false
+	dev.svcs = make(map[string]Service)
+	dev.chars = make(map[string]Characteristic)
+	return nil
 }
 
-func toUUID(str string) bluetooth.UUID {
-	u, _ := bluetooth.ParseUUID(str)
-	return u
+// Model returns the model of the device.
+//
+// The model is identified from the name of the device.
+// Devices that cannot be identified are assumed to be Aranet4 devices.
+func (dev *Device) Model() (Model, error) {
+	return dev.ModelContext(context.Background())
 }
 
-func (dev *Device) getCharByUUID(uuidstr string) (*bluetooth.DeviceCharacteristic, error) {
-	uuid := toUUID(uuidstr)
-	if dev.svc == nil {
-		svcs, err := dev.dev.DiscoverServices(nil)		
-		if err != nil {
-			return nil, fmt.Errorf("could not find service: %w", err)
-		}
-		for _, s := range svcs {
-			if s.UUID() == toUUID(uuidDeviceService) {
-				dev.svc = &s
-			}
-		}
+// ModelContext is like Model, but aborts the operation when the context
+// is done.
+func (dev *Device) ModelContext(ctx context.Context) (Model, error) {
+	var v Model
+	err := dev.do(ctx, func() error {
+		v = dev.getModel()
+		return nil
+	})
+	if err != nil {
+		return ModelUnknown, err
 	}
-	if dev.svc == nil {
-		return nil, fmt.Errorf("no service found", nil)
+	return v, nil
+}
+
+func (dev *Device) getModel() Model {
+	dev.mu.Lock()
+	m := dev.model
+	dev.mu.Unlock()
+	if m != ModelUnknown {
+		return m
+	}
+
+	name, err := dev.readString(uuidGenericService, uuidGenericReadDeviceName)
+	if err != nil {
+		return ModelAranet4
+	}
+	m = modelFromName(name)
+	if m == ModelUnknown {
+		m = ModelAranet4
 	}
-	chars, err := dev.svc.DiscoverCharacteristics([]bluetooth.UUID{uuid})
+
+	dev.mu.Lock()
+	dev.model = m
+	dev.mu.Unlock()
+	return m
+}
+
+// do runs f, unless the context is done before f returns.
+// In that case, the transport is disconnected so the on-going operation
+// is aborted, and the context's error is returned once f has returned.
+// An expired deadline also matches ErrTimeout.
+//
+// The device is not closed: the next operation re-establishes the
+// connection, if the device was created with NewContext or
+// NewWithDialer, and fails with ErrNotConnected otherwise.
+func (dev *Device) do(ctx context.Context, f func() error) error {
+	if ctx.Err() != nil {
+		return ctxError(ctx)
+	}
+	err := dev.revive(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not get characteristic %q: %w", uuidCommonReadSWRevision, err)
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- f()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		dev.abort()
+		// f may still be using the transport: wait for it to give up.
+		<-errc
+		return ctxError(ctx)
+	}
+}
+
+// abort disconnects the transport, to abort the on-going operation,
+// without closing the device.
+func (dev *Device) abort() {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	if dev.tr == nil || dev.closed || dev.stale {
+		return
+	}
+	dev.stale = true
+	_ = dev.tr.Close()
+}
+
+// revive re-establishes the connection after an aborted operation.
+func (dev *Device) revive(ctx context.Context) error {
+	dev.mu.Lock()
+	stale := dev.stale && !dev.closed
+	dev.mu.Unlock()
+	if !stale {
+		return nil
+	}
+	if dev.dial == nil {
+		return ErrNotConnected
+	}
+	return dev.reconnect(ctx)
+}
+
+// getCharByUUID returns the characteristic uuid of the Aranet service.
+func (dev *Device) getCharByUUID(uuid string) (Characteristic, error) {
+	return dev.getChar(uuidDeviceService, uuid)
+}
+
+// getChar returns the characteristic uuid of the service svc.
+func (dev *Device) getChar(svc, uuid string) (Characteristic, error) {
+	dev.mu.Lock()
+	closed := dev.closed
+	dev.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
 	}
-	var foundChar bluetooth.DeviceCharacteristic
-	for _, char := range chars {
-		if char.UUID() == uuid {
-			foundChar = char
+
+	dev.cache.Lock()
+	defer dev.cache.Unlock()
+
+	if dev.tr == nil {
+		return nil, ErrNotConnected
+	}
+
+	if c, ok := dev.chars[uuid]; ok {
+		return c, nil
+	}
+
+	s, ok := dev.svcs[svc]
+	if !ok {
+		var err error
+		s, err = dev.tr.DiscoverService(svc)
+		if err != nil {
+			return nil, &CharacteristicError{Op: "discover", Service: svc, UUID: uuid, Err: err}
 		}
+		dev.svcs[svc] = s
+	}
+
+	c, err := s.DiscoverCharacteristic(uuid)
+	if err != nil {
+		return nil, &CharacteristicError{Op: "discover", Service: svc, UUID: uuid, Err: err}
 	}
-	return &foundChar, nil
+	dev.chars[uuid] = c
+	return c, nil
 }
 
-func (dev *Device) Version() (string, error) {
-	char, err := dev.getCharByUUID(uuidCommonReadSWRevision)
+// readValue reads the value of the characteristic uuid of the service svc.
+func (dev *Device) readValue(svc, uuid string) ([]byte, error) {
+	c, err := dev.getChar(svc, uuid)
 	if err != nil {
-		return "", fmt.Errorf("could not get characteristic %q: %w", uuidCommonReadSWRevision, err)
+		return nil, err
 	}
+
 	raw := make([]byte, 255)
-	if n, err := char.Read(raw); err != nil || n == 0 {
-		return "", fmt.Errorf("could not read device name: %w", err)
+	n, err := c.Read(raw)
+	if err != nil {
+		return nil, &CharacteristicError{Op: "read", Service: svc, UUID: uuid, Err: err}
+	}
+	return raw[:n], nil
+}
+
+// Version returns the software revision of the device.
+func (dev *Device) Version() (string, error) {
+	return dev.VersionContext(context.Background())
+}
+
+// VersionContext is like Version, but aborts the operation when the
+// context is done.
+func (dev *Device) VersionContext(ctx context.Context) (string, error) {
+	var v string
+	err := dev.do(ctx, func() (err error) {
+		v, err = dev.version()
+		return err
+	})
+	if err != nil {
+		return "", err
 	}
-	return string(raw), nil
+	return v, nil
+}
+
+func (dev *Device) version() (string, error) {
+	return dev.readString(uuidCommonService, uuidCommonReadSWRevision)
 }
 
+// Read returns the current measurements of the device.
 func (dev *Device) Read() (Data, error) {
-	var data Data
-	c, err := dev.getCharByUUID(uuidReadAll)
+	return dev.ReadContext(context.Background())
+}
+
+// ReadContext is like Read, but aborts the operation when the context
+// is done.
+func (dev *Device) ReadContext(ctx context.Context) (Data, error) {
+	var v Data
+	err := dev.do(ctx, func() (err error) {
+		v, err = dev.read()
+		return err
+	})
 	if err != nil {
-		return data, fmt.Errorf("could not get characteristic %q: %w", uuidReadAll, err)
+		return Data{}, err
 	}
+	return v, nil
+}
 
-	raw := make([]byte, 255)
-	if n, err := c.Read(raw); err != nil || n == 0 {
-		return data, fmt.Errorf("could not get value: %w", err)
+func (dev *Device) read() (Data, error) {
+	var (
+		uuid   = uuidReadAll
+		decode = DecodeCurrentAt
+	)
+	if dev.getModel() != ModelAranet4 {
+		uuid = uuidReadAllDetailed
+		decode = DecodeCurrentDetailedAt
 	}
 
-	dec := newDecoder(bytes.NewReader(raw))
-	dec.readCO2(&data.CO2)
-	dec.readT(&data.T)
-	dec.readP(&data.P)
-	dec.readH(&data.H)
-	dec.readBattery(&data.Battery)
-	dec.readQuality(&data.Quality)
-	dec.readInterval(&data.Interval)
-	dec.readTime(&data.Time)
+	raw, err := dev.readValue(uuidDeviceService, uuid)
+	if err != nil {
+		return Data{}, err
+	}
 
-	if dec.err != nil {
-		return data, fmt.Errorf("could not decode data sample: %w", dec.err)
+	data, err := decode(raw, dev.clock())
+	if err != nil {
+		return data, err
 	}
+	data.Time = dev.align(data.Time, data.Interval)
+
+	dev.mu.Lock()
+	policy := dev.policy
+	dev.mu.Unlock()
+	data.Quality = policy.Classify(data)
 
 	return data, nil
 }
 
+// NumData returns the number of data samples held by the device.
 func (dev *Device) NumData() (int, error) {
-	c, err := dev.getCharByUUID(uuidReadTotalReadings)
+	return dev.NumDataContext(context.Background())
+}
+
+// NumDataContext is like NumData, but aborts the operation when the
+// context is done.
+func (dev *Device) NumDataContext(ctx context.Context) (int, error) {
+	var v int
+	err := dev.do(ctx, func() (err error) {
+		v, err = dev.numData()
+		return err
+	})
 	if err != nil {
-		return 0, fmt.Errorf("could not get characteristic %q: %w", uuidReadTotalReadings, err)
+		return 0, err
 	}
+	return v, nil
+}
 
-	raw := make([]byte, 255)
-	if n, err := c.Read(raw); err != nil || n == 0 {
-		return 0, fmt.Errorf("could not get value: %w", err)
+func (dev *Device) numData() (int, error) {
+	raw, err := dev.readValue(uuidDeviceService, uuidReadTotalReadings)
+	if err != nil {
+		return 0, err
+	}
+	if len(raw) < 2 {
+		return 0, protocolError("decode number of samples", raw, io.ErrUnexpectedEOF)
 	}
 
 	return int(binary.LittleEndian.Uint16(raw)), nil
 }
 
+// Since returns the elapsed time since the last measurement.
 func (dev *Device) Since() (time.Duration, error) {
-	c, err := dev.getCharByUUID(uuidReadSecondsSinceUpdate)
+	return dev.SinceContext(context.Background())
+}
+
+// SinceContext is like Since, but aborts the operation when the context
+// is done.
+func (dev *Device) SinceContext(ctx context.Context) (time.Duration, error) {
+	var v time.Duration
+	err := dev.do(ctx, func() (err error) {
+		v, err = dev.since()
+		return err
+	})
 	if err != nil {
-		return 0, fmt.Errorf("could not get characteristic %q: %w", uuidReadSecondsSinceUpdate, err)
+		return 0, err
 	}
+	return v, nil
+}
 
-	raw := make([]byte, 255)
-	if n, err := c.Read(raw); err != nil || n == 0 {
-		return 0, fmt.Errorf("could not get value: %w", err)
+func (dev *Device) since() (time.Duration, error) {
+	raw, err := dev.readValue(uuidDeviceService, uuidReadSecondsSinceUpdate)
+	if err != nil {
+		return 0, err
 	}
 
-	var (
-		ago time.Duration
-		dec = newDecoder(bytes.NewReader(raw))
-	)
-	err = dec.readInterval(&ago)
+	return DecodeInterval(raw)
+}
+
+// Interval returns the measurement interval of the device.
+func (dev *Device) Interval() (time.Duration, error) {
+	return dev.IntervalContext(context.Background())
+}
+
+// IntervalContext is like Interval, but aborts the operation when the
+// context is done.
+func (dev *Device) IntervalContext(ctx context.Context) (time.Duration, error) {
+	var v time.Duration
+	err := dev.do(ctx, func() (err error) {
+		v, err = dev.interval()
+		return err
+	})
 	if err != nil {
-		return 0, fmt.Errorf("could not decode interval value %q: %w", raw, err)
+		return 0, err
 	}
-	return ago, nil
+	return v, nil
 }
 
-func (dev *Device) Interval() (time.Duration, error) {
-	c, err := dev.getCharByUUID(uuidReadInterval)
+func (dev *Device) interval() (time.Duration, error) {
+	raw, err := dev.readValue(uuidDeviceService, uuidReadInterval)
 	if err != nil {
-		return 0, fmt.Errorf("could not get characteristic %q: %w", uuidReadInterval, err)
+		return 0, err
 	}
 
-	raw := make([]byte, 255)
-	if n, err := c.Read(raw); err != nil || n == 0 {
-		return 0, fmt.Errorf("could not get value: %w", err)
+	return DecodeInterval(raw)
+}
+
+// historyTimeout is the maximum duration to wait for the complete
+// history of a parameter.
+const historyTimeout = 30 * time.Second
+
+// ReadAll downloads the whole history of data samples held by the device.
+//
+// ReadAll blocks until all the samples of every parameter have been
+// received, or until a deadline passes.
+func (dev *Device) ReadAll() ([]Data, error) {
+	return dev.ReadRangeContext(context.Background(), 1, -1)
+}
+
+// ReadAllContext is like ReadAll, but aborts the download when the
+// context is done.
+func (dev *Device) ReadAllContext(ctx context.Context) ([]Data, error) {
+	return dev.ReadRangeContext(ctx, 1, -1)
+}
+
+// ReadSince downloads the data samples recorded after the sample with
+// the provided index.
+// Sample indices start at 1, for the oldest sample held by the device,
+// and end at NumData, for the latest one.
+//
+// Once the history buffer of the device is full, indices are shifted
+// by one at each new measurement.
+func (dev *Device) ReadSince(last int) ([]Data, error) {
+	return dev.ReadRangeContext(context.Background(), last+1, -1)
+}
+
+// ReadSinceContext is like ReadSince, but aborts the download when the
+// context is done.
+func (dev *Device) ReadSinceContext(ctx context.Context, last int) ([]Data, error) {
+	return dev.ReadRangeContext(ctx, last+1, -1)
+}
+
+// ReadAfter downloads the data samples measured after the provided time,
+// or the whole history of the device if t is zero.
+//
+// Only the samples measured after t are downloaded, as located with the
+// clock of the device (see SetClock).
+func (dev *Device) ReadAfter(t time.Time) ([]Data, error) {
+	return dev.ReadAfterContext(context.Background(), t)
+}
+
+// ReadAfterContext is like ReadAfter, but aborts the download when the
+// context is done.
+func (dev *Device) ReadAfterContext(ctx context.Context, t time.Time) ([]Data, error) {
+	if t.IsZero() {
+		return dev.ReadAllContext(ctx)
 	}
 
-	var (
-		ago time.Duration
-		dec = newDecoder(bytes.NewReader(raw))
-	)
-	err = dec.readInterval(&ago)
+	n, err := dev.NumDataContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get total number of samples: %w", err)
+	}
+	ago, err := dev.SinceContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get last measurement update: %w", err)
+	}
+	delta, err := dev.IntervalContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get sampling: %w", err)
+	}
+	if delta <= 0 {
+		raw := make([]byte, 2)
+		binary.LittleEndian.PutUint16(raw, uint16(delta/time.Second))
+		return nil, protocolError("decode interval", raw, fmt.Errorf("invalid measurement interval %v", delta))
+	}
+
+	// download one sample of overlap, to account for time-stamp jitter.
+	k := int(dev.clock().Sub(t.Add(ago))/delta) + 1
+	if k < 1 {
+		return nil, nil
+	}
+	from := n - k + 1
+	if from < 1 {
+		from = 1
+	}
+	rows, err := dev.ReadRangeContext(ctx, from, n)
 	if err != nil {
-		return 0, fmt.Errorf("could not decode interval value %q: %w", raw, err)
+		return nil, err
 	}
-	return ago, nil
+
+	// drop the samples measured up to t.
+	cut := t.Add(delta / 2)
+	i := sort.Search(len(rows), func(i int) bool {
+		return rows[i].Time.After(cut)
+	})
+	return rows[i:], nil
 }
 
-// This doesnt really work right now.
-func (dev *Device) ReadAll() ([]Data, error) {
-	now := time.Now().UTC()
-	ago, err := dev.Since()
+// ReadRange downloads the data samples with indices in [from, to].
+// Sample indices start at 1, for the oldest sample held by the device,
+// and end at NumData, for the latest one.
+// A negative or too large to index is clamped to the latest sample.
+//
+// ReadRange blocks until all the samples of every parameter have been
+// received, or until a deadline passes.
+func (dev *Device) ReadRange(from, to int) ([]Data, error) {
+	return dev.ReadRangeContext(context.Background(), from, to)
+}
+
+// ReadRangeContext is like ReadRange, but aborts the download when the
+// context is done.
+func (dev *Device) ReadRangeContext(ctx context.Context, from, to int) ([]Data, error) {
+	var vs []Data
+	err := dev.do(ctx, func() (err error) {
+		vs, err = dev.readRange(ctx, from, to)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+func (dev *Device) readRange(ctx context.Context, from, to int) ([]Data, error) {
+	if from < 1 {
+		return nil, fmt.Errorf("invalid sample index %d", from)
+	}
+
+	now := dev.clock()
+	ago, err := dev.since()
 	if err != nil {
 		return nil, fmt.Errorf("could not get last measurement update: %w", err)
 	}
 
-	delta, err := dev.Interval()
+	delta, err := dev.interval()
 	if err != nil {
 		return nil, fmt.Errorf("could not get sampling: %w", err)
 	}
 
-	n, err := dev.NumData()
+	n, err := dev.numData()
 	if err != nil {
 		return nil, fmt.Errorf("could not get total number of samples: %w", err)
 	}
-	out := make([]Data, n)
-	for _, id := range []byte{paramT, paramH, paramP, paramCO2} {
-		err = dev.readN(out, id)
+	if to < 0 || to > n {
+		to = n
+	}
+	if from > to {
+		return nil, nil
+	}
+
+	model := dev.getModel()
+	out := make([]Data, to-from+1)
+	for _, id := range model.params() {
+		err = dev.readN(ctx, out, id, from)
 		if err != nil {
 			return nil, fmt.Errorf("could not read param=%d: %w", id, err)
 		}
 	}
 
-	beg := now.Add(-ago - time.Duration(n-1)*delta)
+	last := dev.align(now.Add(-ago), delta)
+	beg := last.Add(-time.Duration(n-from) * delta)
 	for i := range out {
+		out[i].Model = model
 		out[i].Battery = -1 // no battery information when fetching history.
-		out[i].Quality = qualityFrom(out[i].CO2)
 		out[i].Interval = delta
 		out[i].Time = beg.Add(time.Duration(i) * delta)
 	}
 
+	dev.mu.Lock()
+	policy := dev.policy
+	dev.mu.Unlock()
+	NewClassifier(policy).Fill(out)
+
 	return out, nil
 }
 
-// type btOptions = map[string]interface{}
+// readN fills dst with the history of the parameter id, starting at
+// the sample index from.
+//
+// readN subscribes to the time series notifications, requests the history
+// and waits for all the chunks covering dst to arrive, in order.
+func (dev *Device) readN(ctx context.Context, dst []Data, id Param, from int) (err error) {
+	if len(dst) == 0 {
+		return nil
+	}
+
+	c, err := dev.getCharByUUID(uuidReadTimeSeries)
+	if err != nil {
+		return err
+	}
+	notifyError := func(err error) error {
+		return &CharacteristicError{
+			Op:      "notify",
+			Service: uuidDeviceService,
+			UUID:    uuidReadTimeSeries,
+			Err:     err,
+		}
+	}
+
+	var (
+		chunks = make(chan []byte, 16)
+		stop   = make(chan struct{})
+	)
+	defer close(stop)
 
-// var opReqCmd = btOptions{
-// 	"type": "request",
-// }
+	err = c.EnableNotifications(func(p []byte) {
+		// the transport may reuse its buffer.
+		p = append([]byte(nil), p...)
+		select {
+		case chunks <- p:
+		case <-stop:
+		}
+	})
+	if err != nil {
+		return notifyError(err)
+	}
+	defer func() {
+		e := c.EnableNotifications(nil)
+		if e != nil && err == nil {
+			err = notifyError(e)
+		}
+	}()
 
-func (dev *Device) readN(dst []Data, id byte) error {
 	{
 		cmd := []byte{
 			0x82, 0x00, 0x00, 0x00, 0x01, 0x00, 0xff, 0xff,
 		}
-		cmd[1] = id
-		binary.LittleEndian.PutUint16(cmd[4:], 0x0001)
-		binary.LittleEndian.PutUint16(cmd[6:], 0xffff)
-
-		c, err := dev.getCharByUUID(uuidWriteCmd)
-		if err != nil {
-			return fmt.Errorf("could not get characteristic %q: %w", uuidWriteCmd, err)
-		}
+		cmd[1] = byte(id)
+		binary.LittleEndian.PutUint16(cmd[4:], uint16(from))
+		binary.LittleEndian.PutUint16(cmd[6:], uint16(from+len(dst)-1))
 
-		_, err = c.WriteWithoutResponse(cmd)
+		err := dev.writeCmd(cmd)
 		if err != nil {
-			return fmt.Errorf("could not write command: %w", err)
+			return err
 		}
 	}
 
-	c, err := dev.getCharByUUID(uuidReadTimeSeries)
-	if err != nil {
-		return fmt.Errorf("could not get characteristic %q: %w", uuidReadTimeSeries, err)
-	}
+	timeout := time.NewTimer(dev.timeout)
+	defer timeout.Stop()
 
-	done := make(chan struct{})
+	next := 0 // index of the next expected sample.
+	for next < len(dst) {
+		var p []byte
+		select {
+		case p = <-chunks:
+		case <-timeout.C:
+			return &kindError{
+				kind: ErrTimeout,
+				err:  fmt.Errorf("waiting for history (received %d/%d samples)", next, len(dst)),
+			}
+		case <-ctx.Done():
+			return ctxError(ctx)
+		}
 
-	var errLoop error
-	err = c.EnableNotifications(func(p []byte) {
-		param := p[0]
-		fmt.Println("param")
-		fmt.Println(param)
-		if param != id {
-			errLoop = fmt.Errorf("invalid parameter: got=0x%x, want=0x%x", param, id)
-			return
+		if len(p) > 0 && Param(p[0]) != id {
+			// stray chunk from a previous request.
+			continue
+		}
+		chunk, err := DecodeHistoryChunk(p)
+		if err != nil {
+			return err
 		}
 
-		idx := int(binary.LittleEndian.Uint16(p[1:]) - 1)
-		cnt := int(p[3])
-		if cnt == 0 {
-			close(done)
-			return
+		idx := chunk.Start - from
+		if chunk.End() {
+			return protocolError("read history", p, fmt.Errorf("history ended prematurely (received %d/%d samples)", next, len(dst)))
+		}
+		switch {
+		case idx > next:
+			return protocolError("read history", p, fmt.Errorf("missing history chunk (got index=%d, want=%d)", idx+from, next+from))
+		case idx < next:
+			return protocolError("read history", p, fmt.Errorf("out-of-order history chunk (got index=%d, want=%d)", idx+from, next+from))
 		}
-		max := min(idx+cnt, len(dst)) // a new sample may have appeared
-		dec := newDecoder(bytes.NewReader(p[4:]))
+
+		max := min(idx+chunk.Count, len(dst)) // a new sample may have appeared
 		for i := idx; i < max; i++ {
-			err := dec.readField(id, &dst[i])
+			err := chunk.Decode(i-idx, &dst[i])
 			if err != nil {
-				errLoop = fmt.Errorf("could not read param=%d, idx=%d: %w", id, i, err)
-				return
+				return fmt.Errorf("could not read idx=%d: %w", i, err)
 			}
 		}
-		return
-	})
-	if err != nil {
-		return fmt.Errorf("could not watch props: %w", err)
-	}
-
-	if errLoop != nil {
-		return fmt.Errorf("could not read notified data: %w", errLoop)
+		next = max
 	}
 
 	return nil
@@ -0,0 +1,628 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func newTestDevice(t *testing.T, hist []Data, mangle func(chunks [][]byte) [][]byte) *Device {
+	t.Helper()
+	return NewWithTransport(newTestTransport(t, hist, mangle))
+}
+
+func newTestTransport(t *testing.T, hist []Data, mangle func(chunks [][]byte) [][]byte) *memTransport {
+	t.Helper()
+
+	tr := newMemTransport()
+	tr.add(uuidDeviceService, uuidReadAll).value = []byte{
+		0x3a, 0x02, // CO2: 570 ppm
+		0x8f, 0x01, // T: 19.95°C
+		0x4d, 0x26, // P: 980.5 hPa
+		0x1d,       // H: 29%
+		0x60,       // battery: 96%
+		0x01,       // quality: green
+		0x2c, 0x01, // interval: 300s
+		0x78, 0x00, // ago: 120s
+	}
+	tr.add(uuidDeviceService, uuidReadInterval).value = []byte{0x2c, 0x01}
+	tr.add(uuidDeviceService, uuidReadSecondsSinceUpdate).value = []byte{0x78, 0x00}
+	tr.add(uuidDeviceService, uuidReadTotalReadings).value = []byte{byte(len(hist)), 0x00}
+
+	ts := tr.add(uuidDeviceService, uuidReadTimeSeries)
+	tr.add(uuidDeviceService, uuidWriteCmd).write = func(p []byte) {
+		if p[0] != 0x82 {
+			t.Errorf("invalid command: %x", p)
+			return
+		}
+		var (
+			from = int(binary.LittleEndian.Uint16(p[4:]))
+			to   = int(binary.LittleEndian.Uint16(p[6:]))
+		)
+		chunks := historyChunks(hist, Param(p[1]), from, to)
+		if mangle != nil {
+			chunks = mangle(chunks)
+		}
+		go func() {
+			for _, p := range chunks {
+				ts.notify(p)
+			}
+		}()
+	}
+
+	return tr
+}
+
+func TestDeviceContext(t *testing.T) {
+	dev := newTestDevice(t, make([]Data, 10), func([][]byte) [][]byte {
+		return nil
+	})
+	defer dev.Close()
+	tr := dev.tr.(*memTransport)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := dev.ReadAllContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, context.DeadlineExceeded)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrTimeout)
+	}
+
+	select {
+	case <-tr.closed:
+	default:
+		t.Fatalf("device was not disconnected")
+	}
+
+	// only the operation was aborted: the device is not closed.
+	_, err = dev.Read()
+	if !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("invalid error reading from a disconnected device: got=%+v, want=%+v", err, ErrNotConnected)
+	}
+
+	_ = dev.Close()
+	_, err = dev.Read()
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("invalid error reading from a closed device: got=%+v, want=%+v", err, ErrClosed)
+	}
+}
+
+func TestDeviceErrors(t *testing.T) {
+	dev := newTestDevice(t, nil, nil)
+	defer dev.Close()
+	tr := dev.tr.(*memTransport)
+
+	_, err := dev.Version()
+	var cerr *CharacteristicError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("invalid error type: got=%T, want=%T", err, cerr)
+	}
+	if got, want := cerr.UUID, uuidCommonReadSWRevision; got != want {
+		t.Fatalf("invalid characteristic: got=%q, want=%q", got, want)
+	}
+	if got, want := cerr.Op, "discover"; got != want {
+		t.Fatalf("invalid operation: got=%q, want=%q", got, want)
+	}
+
+	tr.svcs[uuidDeviceService].chars[uuidReadAll].value = []byte{0x3a, 0x02, 0x8f}
+	_, err = dev.Read()
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		t.Fatalf("invalid error type: got=%T, want=%T", err, perr)
+	}
+	if got, want := perr.Data, []byte{0x3a, 0x02, 0x8f}; !bytes.Equal(got, want) {
+		t.Fatalf("invalid offending bytes: got=%x, want=%x", got, want)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDeviceContextHang(t *testing.T) {
+	dev := newTestDevice(t, nil, nil)
+	defer dev.Close()
+	tr := dev.tr.(*memTransport)
+	tr.svcs[uuidDeviceService].chars[uuidReadAll].hang = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := dev.ReadContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, context.Canceled)
+	}
+
+	_, err = dev.VersionContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, context.Canceled)
+	}
+
+	// the device is not closed, but can not reconnect without a dialer.
+	_, err = dev.Read()
+	if !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrNotConnected)
+	}
+	err = dev.Close()
+	if err != nil {
+		t.Fatalf("could not close device: %+v", err)
+	}
+}
+
+func TestDeviceContextReconnect(t *testing.T) {
+	var dials int
+	dev, err := NewWithDialer(context.Background(), func(context.Context) (Transport, error) {
+		dials++
+		tr := newTestTransport(t, nil, nil)
+		// only the first connection hangs.
+		tr.svcs[uuidDeviceService].chars[uuidReadAll].hang = dials == 1
+		return tr, nil
+	})
+	if err != nil {
+		t.Fatalf("could not create device: %+v", err)
+	}
+	defer dev.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = dev.ReadContext(ctx)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrTimeout)
+	}
+
+	data, err := dev.Read()
+	if err != nil {
+		t.Fatalf("could not read data after aborted operation: %+v", err)
+	}
+	if got, want := data.CO2, 570; got != want {
+		t.Fatalf("invalid CO2: got=%d, want=%d", got, want)
+	}
+	if got, want := dials, 2; got != want {
+		t.Fatalf("invalid number of dials: got=%d, want=%d", got, want)
+	}
+}
+
+func historyChunks(hist []Data, param Param, from, to int) [][]byte {
+	const chunk = 3
+	var out [][]byte
+	to = min(to, len(hist))
+	for beg := from - 1; beg < to; beg += chunk {
+		end := min(beg+chunk, to)
+		p := []byte{byte(param), 0, 0, byte(end - beg)}
+		binary.LittleEndian.PutUint16(p[1:], uint16(beg+1))
+		for _, v := range hist[beg:end] {
+			switch param {
+			case ParamT:
+				if !v.Valid(FieldT) {
+					p = appendU16(p, 0x4000)
+					continue
+				}
+				p = appendU16(p, uint16(v.T*20))
+			case ParamH:
+				p = append(p, byte(v.H))
+			case ParamP:
+				if !v.Valid(FieldP) {
+					p = appendU16(p, 0x8000)
+					continue
+				}
+				p = appendU16(p, uint16(v.P*10))
+			case ParamCO2:
+				if !v.Valid(FieldCO2) {
+					p = appendU16(p, 0x8000)
+					continue
+				}
+				p = appendU16(p, uint16(v.CO2))
+			}
+		}
+		out = append(out, p)
+	}
+	return append(out, []byte{byte(param), 0, 0, 0})
+}
+
+func TestDeviceRead(t *testing.T) {
+	dev := newTestDevice(t, nil, nil)
+	defer dev.Close()
+
+	now := time.Date(2022, 1, 20, 15, 50, 28, 0, time.UTC)
+	dev.SetClock(func() time.Time { return now })
+
+	got, err := dev.Read()
+	if err != nil {
+		t.Fatalf("could not read data: %+v", err)
+	}
+
+	want := Data{
+		H: 29, P: 980.5, T: 19.95,
+		CO2:      570,
+		Battery:  96,
+		Quality:  1,
+		Status:   1,
+		Interval: 5 * time.Minute,
+		Model:    ModelAranet4,
+	}
+	if got, want := got.Time, now.Add(-2*time.Minute); !got.Equal(want) {
+		t.Fatalf("invalid time-stamp: got=%v, want=%v", got, want)
+	}
+	got.Time = time.Time{}
+	if got != want {
+		t.Fatalf("invalid data:\ngot:\n%vwant:\n%v", got, want)
+	}
+
+	// the configured policy supersedes the thresholds of the firmware.
+	dev.SetPolicy(Policy{CO2: Thresholds{Yellow: 500, Red: 1000}})
+	got, err = dev.Read()
+	if err != nil {
+		t.Fatalf("could not read data: %+v", err)
+	}
+	if got, want := got.Quality, QualityYellow; got != want {
+		t.Fatalf("invalid quality: got=%v, want=%v", got, want)
+	}
+	if got, want := got.Status, QualityGreen; got != want {
+		t.Fatalf("invalid status: got=%v, want=%v", got, want)
+	}
+}
+
+func TestDeviceAlign(t *testing.T) {
+	hist := make([]Data, 10)
+	for i := range hist {
+		hist[i].CO2 = 400 + i
+	}
+	dev := newTestDevice(t, hist, nil)
+	defer dev.Close()
+
+	var (
+		t0  = time.Date(2022, 1, 20, 15, 50, 28, 0, time.UTC)
+		now = t0
+		tr  = dev.tr.(*memTransport)
+		cur = tr.svcs[uuidDeviceService].chars[uuidReadAll]
+		ago = tr.svcs[uuidDeviceService].chars[uuidReadSecondsSinceUpdate]
+	)
+	dev.SetClock(func() time.Time { return now })
+	setAgo := func(v uint16) {
+		ago.value = []byte{byte(v), byte(v >> 8)}
+		cur.value[11] = byte(v)
+		cur.value[12] = byte(v >> 8)
+	}
+
+	ref, err := dev.ReadAll()
+	if err != nil {
+		t.Fatalf("could not read history: %+v", err)
+	}
+	if got, want := ref[len(ref)-1].Time, t0.Add(-2*time.Minute); !got.Equal(want) {
+		t.Fatalf("invalid time-stamp: got=%v, want=%v", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		dt   time.Duration // elapsed time since t0
+		ago  uint16        // elapsed time since the last measurement, in seconds
+		want time.Time     // time-stamp of the last measurement
+	}{
+		{"same", 0, 120, t0.Add(-2 * time.Minute)},
+		{"jitter", 900 * time.Millisecond, 120, t0.Add(-2 * time.Minute)},
+		{"tick", 1200 * time.Millisecond, 121, t0.Add(-2 * time.Minute)},
+		{"next", 3*time.Minute + 300*time.Millisecond, 0, t0.Add(3 * time.Minute)},
+		{"off-grid", 5*time.Minute + 500*time.Millisecond, 30, t0.Add(4*time.Minute + 30*time.Second + 500*time.Millisecond)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			now = t0.Add(tc.dt)
+			setAgo(tc.ago)
+
+			got, err := dev.Read()
+			if err != nil {
+				t.Fatalf("could not read data: %+v", err)
+			}
+			if got, want := got.Time, tc.want; !got.Equal(want) {
+				t.Fatalf("invalid time-stamp: got=%v, want=%v", got, want)
+			}
+
+			vs, err := dev.ReadAll()
+			if err != nil {
+				t.Fatalf("could not read history: %+v", err)
+			}
+			if got, want := vs[len(vs)-1].Time, tc.want; !got.Equal(want) {
+				t.Fatalf("invalid time-stamp: got=%v, want=%v", got, want)
+			}
+			if tc.ago != 120 && tc.ago != 121 {
+				return
+			}
+			for i := range vs {
+				if got, want := vs[i].Time, ref[i].Time; !got.Equal(want) {
+					t.Fatalf("invalid time-stamp for sample %d: got=%v, want=%v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeviceReadMissing(t *testing.T) {
+	hist := []Data{
+		{T: 20.30, H: 38, P: 982.3, CO2: 667},
+		{T: 21.30, H: 36, P: 982.3, Missing: FieldCO2},
+		{H: 36, Missing: FieldT | FieldP | FieldCO2},
+		{T: 21.10, H: 35, P: 982.2, CO2: 825},
+	}
+	dev := newTestDevice(t, hist, nil)
+	defer dev.Close()
+	tr := dev.tr.(*memTransport)
+	tr.svcs[uuidDeviceService].chars[uuidReadAll].value = []byte{
+		0x00, 0x80, // CO2: n/a
+		0x00, 0x40, // T: n/a
+		0x4d, 0x26, // P: 980.5 hPa
+		0x1d,       // H: 29%
+		0x60,       // battery: 96%
+		0x00,       // quality: n/a
+		0x2c, 0x01, // interval: 300s
+		0x78, 0x00, // ago: 120s
+	}
+
+	cur, err := dev.Read()
+	if err != nil {
+		t.Fatalf("could not read data: %+v", err)
+	}
+	if got, want := cur.Missing, FieldT|FieldCO2; got != want {
+		t.Fatalf("invalid missing fields: got=%v, want=%v", got, want)
+	}
+	if got, want := cur.P, 980.5; got != want {
+		t.Fatalf("invalid P: got=%g, want=%g", got, want)
+	}
+
+	vs, err := dev.ReadAll()
+	if err != nil {
+		t.Fatalf("could not read history: %+v", err)
+	}
+	if got, want := len(vs), len(hist); got != want {
+		t.Fatalf("invalid number of samples: got=%d, want=%d", got, want)
+	}
+	for i, v := range vs {
+		want := hist[i]
+		want.Model = ModelAranet4
+		want.Quality = DefaultPolicy().Classify(want)
+		v.Battery = 0
+		v.Interval = 0
+		v.Time = time.Time{}
+		if v != want {
+			t.Fatalf("invalid sample %d:\ngot= %#v\nwant=%#v", i, v, want)
+		}
+	}
+}
+
+func TestDeviceIntervals(t *testing.T) {
+	dev := newTestDevice(t, make([]Data, 42), nil)
+	defer dev.Close()
+
+	ago, err := dev.Since()
+	if err != nil {
+		t.Fatalf("could not read last update: %+v", err)
+	}
+	if got, want := ago, 2*time.Minute; got != want {
+		t.Fatalf("invalid last update: got=%v, want=%v", got, want)
+	}
+
+	delta, err := dev.Interval()
+	if err != nil {
+		t.Fatalf("could not read interval: %+v", err)
+	}
+	if got, want := delta, 5*time.Minute; got != want {
+		t.Fatalf("invalid interval: got=%v, want=%v", got, want)
+	}
+
+	n, err := dev.NumData()
+	if err != nil {
+		t.Fatalf("could not read number of samples: %+v", err)
+	}
+	if got, want := n, 42; got != want {
+		t.Fatalf("invalid number of samples: got=%d, want=%d", got, want)
+	}
+}
+
+func TestDeviceReadAll(t *testing.T) {
+	hist := []Data{
+		{T: 20.30, H: 38, P: 982.3, CO2: 667},
+		{T: 21.30, H: 36, P: 982.3, CO2: 836},
+		{T: 21.20, H: 36, P: 982.2, CO2: 763},
+		{T: 21.10, H: 35, P: 982.2, CO2: 825},
+		{T: 21.05, H: 35, P: 982.2, CO2: 807},
+		{T: 21.00, H: 34, P: 982.3, CO2: 1765},
+		{T: 20.95, H: 34, P: 982.2, CO2: 928},
+	}
+	dev := newTestDevice(t, hist, nil)
+	defer dev.Close()
+
+	got, err := dev.ReadAll()
+	if err != nil {
+		t.Fatalf("could not read history: %+v", err)
+	}
+	if len(got) != len(hist) {
+		t.Fatalf("invalid number of samples: got=%d, want=%d", len(got), len(hist))
+	}
+
+	for i, v := range got {
+		want := hist[i]
+		if v.T != want.T || v.H != want.H || v.P != want.P || v.CO2 != want.CO2 {
+			t.Fatalf("invalid sample %d:\ngot:\n%vwant:\n%v", i, v, want)
+		}
+		if got, want := v.Interval, 5*time.Minute; got != want {
+			t.Fatalf("invalid sample %d interval: got=%v, want=%v", i, got, want)
+		}
+		if i == 0 {
+			continue
+		}
+		if got, want := v.Time.Sub(got[i-1].Time), 5*time.Minute; got != want {
+			t.Fatalf("invalid sample %d time-step: got=%v, want=%v", i, got, want)
+		}
+	}
+}
+
+func TestDeviceReadRange(t *testing.T) {
+	hist := make([]Data, 20)
+	for i := range hist {
+		hist[i].CO2 = 400 + i
+	}
+	dev := newTestDevice(t, hist, nil)
+	defer dev.Close()
+
+	now := time.Date(2022, 1, 20, 15, 50, 28, 0, time.UTC)
+	dev.SetClock(func() time.Time { return now })
+
+	for _, tc := range []struct {
+		from, to int
+		want     []int
+	}{
+		{from: 1, to: 3, want: []int{400, 401, 402}},
+		{from: 5, to: 11, want: []int{404, 405, 406, 407, 408, 409, 410}},
+		{from: 18, to: -1, want: []int{417, 418, 419}},
+		{from: 19, to: 42, want: []int{418, 419}},
+		{from: 20, to: 20, want: []int{419}},
+		{from: 21, to: -1, want: nil},
+	} {
+		got, err := dev.ReadRange(tc.from, tc.to)
+		if err != nil {
+			t.Fatalf("could not read range [%d, %d]: %+v", tc.from, tc.to, err)
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("[%d, %d]: invalid number of samples: got=%d, want=%d", tc.from, tc.to, len(got), len(tc.want))
+		}
+		for i, v := range got {
+			if v.CO2 != tc.want[i] {
+				t.Fatalf("[%d, %d]: invalid sample %d: got=%d, want=%d", tc.from, tc.to, i, v.CO2, tc.want[i])
+			}
+		}
+		if len(got) > 0 {
+			// the latest sample was measured 2 minutes ago.
+			last := now.Add(-2*time.Minute - time.Duration(len(hist)-tc.from-len(got)+1)*5*time.Minute)
+			if got, want := got[len(got)-1].Time, last; !got.Equal(want) {
+				t.Fatalf("[%d, %d]: invalid time-stamp: got=%v, want=%v", tc.from, tc.to, got, want)
+			}
+		}
+	}
+
+	got, err := dev.ReadSince(17)
+	if err != nil {
+		t.Fatalf("could not read samples since 17: %+v", err)
+	}
+	if len(got) != 3 || got[0].CO2 != 417 {
+		t.Fatalf("invalid samples since 17: %v", got)
+	}
+
+	_, err = dev.ReadRange(0, 3)
+	if err == nil {
+		t.Fatalf("expected an error for an invalid index")
+	}
+}
+
+func TestDeviceReadAllErrors(t *testing.T) {
+	hist := make([]Data, 10)
+	for _, tc := range []struct {
+		name   string
+		mangle func(chunks [][]byte) [][]byte
+		want   string
+		kind   func(err error) bool
+	}{
+		{
+			name: "missing",
+			mangle: func(chunks [][]byte) [][]byte {
+				return append(chunks[:1], chunks[2:]...)
+			},
+			want: "could not read param=1: aranet4: could not read history (data=01070003000000000000): missing history chunk (got index=7, want=4)",
+			kind: isProtocolError,
+		},
+		{
+			name: "out-of-order",
+			mangle: func(chunks [][]byte) [][]byte {
+				return append([][]byte{chunks[0], chunks[1], chunks[1]}, chunks[2:]...)
+			},
+			want: "could not read param=1: aranet4: could not read history (data=01040003000000000000): out-of-order history chunk (got index=4, want=7)",
+			kind: isProtocolError,
+		},
+		{
+			name: "premature-end",
+			mangle: func(chunks [][]byte) [][]byte {
+				return chunks[len(chunks)-1:]
+			},
+			want: "could not read param=1: aranet4: could not read history (data=01000000): history ended prematurely (received 0/10 samples)",
+			kind: isProtocolError,
+		},
+		{
+			name: "timeout",
+			mangle: func(chunks [][]byte) [][]byte {
+				return chunks[:2]
+			},
+			want: "could not read param=1: aranet4: timeout: waiting for history (received 6/10 samples)",
+			kind: func(err error) bool { return errors.Is(err, ErrTimeout) },
+		},
+		{
+			name: "stray",
+			mangle: func(chunks [][]byte) [][]byte {
+				return append([][]byte{{0x7, 0x1, 0x0, 0x0}}, chunks...)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev := newTestDevice(t, hist, tc.mangle)
+			defer dev.Close()
+			dev.timeout = 100 * time.Millisecond
+
+			_, err := dev.ReadAll()
+			switch {
+			case err == nil && tc.want == "":
+				// ok.
+			case err == nil:
+				t.Fatalf("expected an error")
+			case tc.want == "":
+				t.Fatalf("could not read history: %+v", err)
+			default:
+				if got, want := err.Error(), tc.want; got != want {
+					t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+				}
+				if !tc.kind(err) {
+					t.Fatalf("invalid error kind: %#v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDeviceReadAfterInvalidInterval(t *testing.T) {
+	dev := newTestDevice(t, make([]Data, 10), func(chunks [][]byte) [][]byte {
+		t.Errorf("history downloaded with an invalid interval")
+		return chunks
+	})
+	defer dev.Close()
+	tr := dev.tr.(*memTransport)
+	tr.add(uuidDeviceService, uuidReadInterval).value = []byte{0x00, 0x00}
+
+	_, err := dev.ReadAfter(time.Date(2022, 1, 20, 15, 0, 0, 0, time.UTC))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !isProtocolError(err) {
+		t.Fatalf("invalid error kind: %#v", err)
+	}
+	if got, want := err.Error(), "aranet4: could not decode interval (data=0000): invalid measurement interval 0s"; got != want {
+		t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+	}
+}
+
+func isProtocolError(err error) bool {
+	var perr *ProtocolError
+	return errors.As(err, &perr)
+}
+
+func appendU16(p []byte, v uint16) []byte {
+	return append(p, byte(v), byte(v>>8))
+}
@@ -0,0 +1,63 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package emu
+
+import (
+	"math"
+	"time"
+)
+
+// Curve describes how a measured quantity evolves with time.
+type Curve func(t time.Time) float64
+
+// Constant returns a curve with the constant value v.
+func Constant(v float64) Curve {
+	return func(time.Time) float64 { return v }
+}
+
+// Sine returns a sinusoidal curve oscillating around mean, with the
+// provided amplitude and period.
+// The curve reaches its maximum at phase (modulo period) past the Unix
+// epoch.
+func Sine(mean, amp float64, period, phase time.Duration) Curve {
+	return func(t time.Time) float64 {
+		x := float64(t.Sub(time.Unix(0, 0).Add(phase))) / float64(period)
+		return mean + amp*math.Cos(2*math.Pi*x)
+	}
+}
+
+// Sum returns a curve summing the provided curves.
+func Sum(cs ...Curve) Curve {
+	return func(t time.Time) float64 {
+		var v float64
+		for _, c := range cs {
+			v += c(t)
+		}
+		return v
+	}
+}
+
+// Office returns a CO2 curve mimicking an occupied office: a baseline
+// of base ppm, rising up to base+peak ppm during working hours
+// (09:00-18:00 UTC), on every day of the week.
+func Office(base, peak float64) Curve {
+	return func(t time.Time) float64 {
+		t = t.UTC()
+		h := float64(t.Hour()) + float64(t.Minute())/60
+		switch {
+		case h < 9 || h >= 18:
+			// exponential decay after people left.
+			dt := h - 18
+			if dt < 0 {
+				dt += 24
+			}
+			return base + peak*math.Exp(-dt)
+		default:
+			// saturating rise while people are in.
+			dt := h - 9
+			return base + peak*(1-math.Exp(-dt))
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package emu provides a software Aranet4 device.
+//
+// A Sensor serves the Aranet4 GATT profile from synthetic measurement
+// curves, so that aranet4.Device clients may be exercised without
+// any hardware:
+//
+//	sensor := emu.New(emu.Config{})
+//	dev := aranet4.NewWithTransport(sensor.Connect())
+//	defer dev.Close()
+package emu // import "sbinet.org/x/aranet4/emu"
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+// Capacity is the default number of samples held in the history buffer
+// of an Aranet4 device.
+const Capacity = 2016
+
+// Config configures a software Aranet4 device.
+type Config struct {
+	Name     string        // device name (default: "Aranet4 EMU01")
+	Version  string        // software revision (default: "v1.2.0")
+	Interval time.Duration // measurement interval (default: 5 minutes)
+	Capacity int           // size of the history buffer (default: Capacity)
+	Battery  int           // battery level, in percents (default: 96)
+
+	// Start is the time of the first measurement.
+	// If zero, Start is set so that the history buffer is full
+	// when the device is created.
+	Start time.Time
+
+	// Now returns the current time (default: time.Now).
+	Now func() time.Time
+
+	// MTU is the maximum size of a notification payload, in bytes
+	// (default: 20).
+	MTU int
+
+	// Measurement curves.
+	// A NaN value of the CO2, T or P curves is reported as missing,
+	// as the device does during sensor calibration.
+	CO2 Curve // CO2 level, in ppm (default: Office(450, 800))
+	T   Curve // temperature, in °C (default: daily sine around 21°C)
+	H   Curve // relative humidity, in % (default: daily sine around 40%)
+	P   Curve // atmospheric pressure, in hPa (default: sine around 1013 hPa)
+}
+
+func (cfg *Config) defaults() {
+	if cfg.Name == "" {
+		cfg.Name = "Aranet4 EMU01"
+	}
+	if cfg.Version == "" {
+		cfg.Version = "v1.2.0"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Minute
+	}
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = Capacity
+	}
+	if cfg.Battery == 0 {
+		cfg.Battery = 96
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Start.IsZero() {
+		cfg.Start = cfg.Now().Add(-time.Duration(cfg.Capacity)*cfg.Interval + cfg.Interval/2)
+	}
+	if cfg.MTU <= 4 {
+		cfg.MTU = 20
+	}
+	if cfg.CO2 == nil {
+		cfg.CO2 = Office(450, 800)
+	}
+	if cfg.T == nil {
+		cfg.T = Sine(21, 1.5, 24*time.Hour, 15*time.Hour)
+	}
+	if cfg.H == nil {
+		cfg.H = Sine(40, 5, 24*time.Hour, 3*time.Hour)
+	}
+	if cfg.P == nil {
+		cfg.P = Sine(1013, 8, 5*24*time.Hour, 0)
+	}
+}
+
+// Sensor is a software Aranet4 device.
+type Sensor struct {
+	mu    sync.Mutex
+	cfg   Config
+	conns map[*conn]struct{}
+
+	// settings, as changed by clients.
+	start        time.Time // time of the first measurement
+	interval     time.Duration
+	integrations bool
+	extended     bool // extended Bluetooth range
+}
+
+// New returns a new software Aranet4 device.
+//
+// The device starts with its Smart Home integrations enabled and with
+// the standard Bluetooth range.
+func New(cfg Config) *Sensor {
+	cfg.defaults()
+	return &Sensor{
+		cfg:          cfg,
+		conns:        make(map[*conn]struct{}),
+		start:        cfg.Start,
+		interval:     cfg.Interval,
+		integrations: true,
+	}
+}
+
+// Connect returns a new GATT connection to the device.
+func (s *Sensor) Connect() aranet4.Transport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := newConn(s)
+	s.conns[c] = struct{}{}
+	return c
+}
+
+// Disconnect drops all the connections to the device, as a link loss
+// would.
+func (s *Sensor) Disconnect() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = make(map[*conn]struct{})
+	s.mu.Unlock()
+
+	for c := range conns {
+		c.Close()
+	}
+}
+
+func (s *Sensor) release(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+// Samples returns the content of the history buffer of the device,
+// as it would be reported by the device.
+func (s *Sensor) Samples() []aranet4.Data {
+	var (
+		now    = s.cfg.Now()
+		tl     = s.timeline()
+		beg, n = tl.window(now)
+	)
+	out := make([]aranet4.Data, n)
+	for i := range out {
+		out[i] = s.sample(tl, beg+i)
+		out[i].Battery = -1
+		out[i].Status = 0
+	}
+	return out
+}
+
+// Current returns the latest measurement of the device, as it would be
+// reported by the device.
+func (s *Sensor) Current() aranet4.Data {
+	var (
+		tl     = s.timeline()
+		beg, n = tl.window(s.cfg.Now())
+	)
+	return s.sample(tl, beg+n-1)
+}
+
+// Advertisement returns the manufacturer specific data the device
+// broadcasts, without the leading company identifier.
+// Measurements are only advertised when the "Smart Home integration" of
+// the device is enabled.
+func (s *Sensor) Advertisement() []byte {
+	s.mu.Lock()
+	integrations := s.integrations
+	s.mu.Unlock()
+
+	p := make([]byte, 8, 22)
+	if !integrations {
+		return p
+	}
+
+	var (
+		now    = s.cfg.Now()
+		tl     = s.timeline()
+		beg, n = tl.window(now)
+	)
+	p[0] = 1 << 5 // integration enabled
+	p = append(p, s.current(now, true)...)
+	p = append(p, uint8(beg+n)) // measurement counter
+	return p
+}
+
+// setInterval changes the measurement interval of the device.
+// As with the hardware device, this clears the history buffer.
+func (s *Sensor) setInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d == s.interval {
+		return
+	}
+	s.interval = d
+	s.start = s.cfg.Now()
+}
+
+func (s *Sensor) setIntegrations(v bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.integrations = v
+}
+
+func (s *Sensor) setExtendedRange(v bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.extended = v
+}
+
+// timeline describes when the measurements of a device are taken.
+type timeline struct {
+	start    time.Time // time of the first measurement
+	interval time.Duration
+	capacity int // size of the history buffer
+}
+
+func (s *Sensor) timeline() timeline {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return timeline{
+		start:    s.start,
+		interval: s.interval,
+		capacity: s.cfg.Capacity,
+	}
+}
+
+// window returns the index of the oldest measurement held in the history
+// buffer and the number of measurements in that buffer.
+func (tl timeline) window(now time.Time) (beg, n int) {
+	if now.Before(tl.start) {
+		return 0, 0
+	}
+	last := int(now.Sub(tl.start) / tl.interval)
+	n = last + 1
+	if n > tl.capacity {
+		n = tl.capacity
+	}
+	return last - n + 1, n
+}
+
+// since returns the elapsed time since the last measurement.
+func (tl timeline) since(now time.Time) time.Duration {
+	if now.Before(tl.start) {
+		return 0
+	}
+	return now.Sub(tl.start) % tl.interval
+}
+
+// sample returns the k-th measurement since the start of the device.
+func (s *Sensor) sample(tl timeline, k int) aranet4.Data {
+	var (
+		t    = tl.start.Add(time.Duration(k) * tl.interval)
+		data = aranet4.Data{
+			H:        math.Round(s.cfg.H(t)),
+			Battery:  s.cfg.Battery,
+			Interval: tl.interval,
+			Time:     t.UTC(),
+		}
+	)
+
+	switch co2 := s.cfg.CO2(t); {
+	case math.IsNaN(co2):
+		data.Missing |= aranet4.FieldCO2
+	default:
+		data.CO2 = int(math.Round(co2))
+		data.Status = firmware.Classify(data)
+	}
+	switch v := s.cfg.T(t); {
+	case math.IsNaN(v):
+		data.Missing |= aranet4.FieldT
+	default:
+		data.T = math.Round(v*20) / 20
+	}
+	switch v := s.cfg.P(t); {
+	case math.IsNaN(v):
+		data.Missing |= aranet4.FieldP
+	default:
+		data.P = math.Round(v*10) / 10
+	}
+	return data
+}
+
+// firmware is the policy used by Aranet4 devices to assess the quality of
+// their current readings.
+var firmware = aranet4.DefaultPolicy()
@@ -0,0 +1,428 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package emu_test
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/emu"
+)
+
+var epoch = time.Date(2022, time.January, 20, 15, 48, 28, 0, time.UTC)
+
+func newSensor(cfg emu.Config) *emu.Sensor {
+	if cfg.Now == nil {
+		cfg.Now = func() time.Time { return epoch }
+	}
+	if cfg.Start.IsZero() {
+		cfg.Start = epoch.Add(-10*time.Minute - 42*time.Second)
+	}
+	return emu.New(cfg)
+}
+
+func TestSensorRead(t *testing.T) {
+	sensor := newSensor(emu.Config{
+		CO2: emu.Constant(1234),
+		T:   emu.Constant(-4.25),
+		H:   emu.Constant(42),
+		P:   emu.Constant(987.6),
+	})
+	dev := aranet4.NewWithTransport(sensor.Connect())
+	defer dev.Close()
+
+	data, err := dev.Read()
+	if err != nil {
+		t.Fatalf("could not read data: %+v", err)
+	}
+	if got, want := data.CO2, 1234; got != want {
+		t.Fatalf("invalid CO2: got=%d, want=%d", got, want)
+	}
+	if got, want := data.H, 42.0; got != want {
+		t.Fatalf("invalid H: got=%g, want=%g", got, want)
+	}
+	if got, want := data.P, 987.6; got != want {
+		t.Fatalf("invalid P: got=%g, want=%g", got, want)
+	}
+	if got, want := data.T, -4.25; got != want {
+		t.Fatalf("invalid T: got=%g, want=%g", got, want)
+	}
+	if got, want := data.Status, aranet4.Quality(2); got != want {
+		t.Fatalf("invalid quality: got=%v, want=%v", got, want)
+	}
+
+	ago, err := dev.Since()
+	if err != nil {
+		t.Fatalf("could not read last update: %+v", err)
+	}
+	if got, want := ago, 42*time.Second; got != want {
+		t.Fatalf("invalid last update: got=%v, want=%v", got, want)
+	}
+
+	delta, err := dev.Interval()
+	if err != nil {
+		t.Fatalf("could not read interval: %+v", err)
+	}
+	if got, want := delta, 5*time.Minute; got != want {
+		t.Fatalf("invalid interval: got=%v, want=%v", got, want)
+	}
+
+	n, err := dev.NumData()
+	if err != nil {
+		t.Fatalf("could not read number of samples: %+v", err)
+	}
+	if got, want := n, 3; got != want {
+		t.Fatalf("invalid number of samples: got=%d, want=%d", got, want)
+	}
+}
+
+func TestSensorCapacity(t *testing.T) {
+	sensor := newSensor(emu.Config{
+		Capacity: 10,
+		Start:    epoch.Add(-24 * time.Hour),
+	})
+	if got, want := len(sensor.Samples()), 10; got != want {
+		t.Fatalf("invalid history size: got=%d, want=%d", got, want)
+	}
+}
+
+func TestSensorHistory(t *testing.T) {
+	sensor := newSensor(emu.Config{
+		MTU:   12,
+		Start: epoch.Add(-2*time.Hour - time.Minute),
+	})
+	samples := sensor.Samples()
+	if len(samples) != 25 {
+		t.Fatalf("invalid history size: got=%d, want=%d", len(samples), 25)
+	}
+
+	tr := sensor.Connect()
+	defer tr.Close()
+
+	svc, err := tr.DiscoverService("f0cd1400-95da-4f4b-9ac8-aa55d312af0c")
+	if err != nil {
+		t.Fatalf("could not discover service: %+v", err)
+	}
+	cmd, err := svc.DiscoverCharacteristic("f0cd1402-95da-4f4b-9ac8-aa55d312af0c")
+	if err != nil {
+		t.Fatalf("could not discover command characteristic: %+v", err)
+	}
+	ts, err := svc.DiscoverCharacteristic("f0cd2003-95da-4f4b-9ac8-aa55d312af0c")
+	if err != nil {
+		t.Fatalf("could not discover time-series characteristic: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		id       byte
+		from, to int
+		size     int
+	}{
+		{id: 1, from: 1, to: 0xffff, size: 2},
+		{id: 2, from: 1, to: 0xffff, size: 1},
+		{id: 3, from: 3, to: 7, size: 2},
+		{id: 4, from: 20, to: 0xffff, size: 2},
+	} {
+		chunks := make(chan []byte, 64)
+		err = ts.EnableNotifications(func(p []byte) {
+			chunks <- append([]byte(nil), p...)
+		})
+		if err != nil {
+			t.Fatalf("could not enable notifications: %+v", err)
+		}
+
+		req := []byte{0x82, tc.id, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint16(req[4:], uint16(tc.from))
+		binary.LittleEndian.PutUint16(req[6:], uint16(tc.to))
+		_, err = cmd.WriteWithoutResponse(req)
+		if err != nil {
+			t.Fatalf("could not send history command: %+v", err)
+		}
+
+		next := tc.from
+	loop:
+		for {
+			var p []byte
+			select {
+			case p = <-chunks:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("param=%d: timeout waiting for notification", tc.id)
+			}
+			if got, want := p[0], tc.id; got != want {
+				t.Fatalf("invalid param: got=%d, want=%d", got, want)
+			}
+			if got, want := int(binary.LittleEndian.Uint16(p[1:])), next; got != want {
+				t.Fatalf("param=%d: invalid chunk index: got=%d, want=%d", tc.id, got, want)
+			}
+			cnt := int(p[3])
+			if cnt == 0 {
+				break loop
+			}
+			if len(p) > 12 {
+				t.Fatalf("param=%d: chunk exceeds MTU: %d", tc.id, len(p))
+			}
+			if got, want := len(p), 4+cnt*tc.size; got != want {
+				t.Fatalf("param=%d: invalid chunk size: got=%d, want=%d", tc.id, got, want)
+			}
+			for i := 0; i < cnt; i++ {
+				v := samples[next+i-1]
+				var got, want float64
+				switch tc.id {
+				case 1:
+					got, want = float64(binary.LittleEndian.Uint16(p[4+2*i:]))/20, v.T
+				case 2:
+					got, want = float64(p[4+i]), v.H
+				case 3:
+					got, want = float64(binary.LittleEndian.Uint16(p[4+2*i:]))/10, v.P
+				case 4:
+					got, want = float64(binary.LittleEndian.Uint16(p[4+2*i:])), float64(v.CO2)
+				}
+				if got != want {
+					t.Fatalf("param=%d, idx=%d: got=%g, want=%g", tc.id, next+i, got, want)
+				}
+			}
+			next += cnt
+		}
+
+		end := tc.to
+		if end > len(samples) {
+			end = len(samples)
+		}
+		if got, want := next, end+1; got != want {
+			t.Fatalf("param=%d: invalid number of samples: got=%d, want=%d", tc.id, got-tc.from, want-tc.from)
+		}
+	}
+}
+
+func TestSensorReadAll(t *testing.T) {
+	sensor := newSensor(emu.Config{
+		Start: epoch.Add(-24*time.Hour - time.Minute),
+	})
+	want := sensor.Samples()
+
+	dev := aranet4.NewWithTransport(sensor.Connect())
+	defer dev.Close()
+
+	got, err := dev.ReadAll()
+	if err != nil {
+		t.Fatalf("could not read history: %+v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("invalid number of samples: got=%d, want=%d", len(got), len(want))
+	}
+	for i := range got {
+		g, w := got[i], want[i]
+		if g.T != w.T || g.H != w.H || g.P != w.P || g.CO2 != w.CO2 {
+			t.Fatalf("invalid sample %d:\ngot:\n%vwant:\n%v", i, g, w)
+		}
+	}
+
+	// a second download on the same connection.
+	_, err = dev.ReadAll()
+	if err != nil {
+		t.Fatalf("could not read history again: %+v", err)
+	}
+}
+
+func TestSensorNegativeT(t *testing.T) {
+	sensor := newSensor(emu.Config{
+		T: emu.Constant(-5),
+	})
+	dev := aranet4.NewWithTransport(sensor.Connect())
+	defer dev.Close()
+
+	data, err := dev.Read()
+	if err != nil {
+		t.Fatalf("could not read data: %+v", err)
+	}
+	if got, want := data.T, -5.0; got != want {
+		t.Fatalf("invalid T: got=%g, want=%g", got, want)
+	}
+	if !data.Valid(aranet4.FieldT) {
+		t.Fatalf("temperature flagged as missing")
+	}
+
+	vs, err := dev.ReadAll()
+	if err != nil {
+		t.Fatalf("could not read history: %+v", err)
+	}
+	for i, v := range vs {
+		if got, want := v.T, -5.0; got != want {
+			t.Fatalf("invalid T for sample %d: got=%g, want=%g", i, got, want)
+		}
+	}
+}
+
+func TestSensorReadAfter(t *testing.T) {
+	sensor := newSensor(emu.Config{
+		Interval: time.Minute,
+		Start:    epoch.Add(-30*time.Minute - 42*time.Second),
+	})
+	want := sensor.Samples()
+
+	dev := aranet4.NewWithTransport(sensor.Connect())
+	defer dev.Close()
+	dev.SetClock(func() time.Time { return epoch })
+
+	for _, tc := range []struct {
+		name string
+		t    time.Time
+		want []aranet4.Data
+	}{
+		{"all", time.Time{}, want},
+		{"some", want[20].Time, want[21:]},
+		{"jitter", want[20].Time.Add(-time.Second), want[21:]},
+		{"none", want[len(want)-1].Time, nil},
+		{"old", want[0].Time.Add(-time.Hour), want},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := dev.ReadAfter(tc.t)
+			if err != nil {
+				t.Fatalf("could not read history: %+v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("invalid number of samples: got=%d, want=%d", len(got), len(tc.want))
+			}
+			for i := range got {
+				if got, want := got[i].Time, tc.want[i].Time; !got.Equal(want) {
+					t.Fatalf("invalid time-stamp for sample %d: got=%v, want=%v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSensorDisconnect(t *testing.T) {
+	sensor := newSensor(emu.Config{})
+	dev := aranet4.NewWithTransport(sensor.Connect())
+	defer dev.Close()
+
+	_, err := dev.Read()
+	if err != nil {
+		t.Fatalf("could not read data: %+v", err)
+	}
+
+	sensor.Disconnect()
+
+	_, err = dev.Read()
+	if err == nil {
+		t.Fatalf("expected an error after link loss")
+	}
+}
+
+func TestSensorSettings(t *testing.T) {
+	now := epoch
+	sensor := newSensor(emu.Config{
+		Now: func() time.Time { return now },
+	})
+	dev := aranet4.NewWithTransport(sensor.Connect())
+	defer dev.Close()
+
+	err := dev.SetInterval(time.Minute)
+	if err != nil {
+		t.Fatalf("could not set interval: %+v", err)
+	}
+	if got, want := sensor.Current().Interval, time.Minute; got != want {
+		t.Fatalf("invalid interval: got=%v, want=%v", got, want)
+	}
+
+	// changing the interval clears the history.
+	now = now.Add(2*time.Minute + 10*time.Second)
+	n, err := dev.NumData()
+	if err != nil {
+		t.Fatalf("could not read number of samples: %+v", err)
+	}
+	if got, want := n, 3; got != want {
+		t.Fatalf("invalid number of samples: got=%d, want=%d", got, want)
+	}
+
+	err = dev.SetIntegrations(false)
+	if err != nil {
+		t.Fatalf("could not disable integrations: %+v", err)
+	}
+	_, err = aranet4.ParseAdvertisement(sensor.Advertisement())
+	if err == nil {
+		t.Fatalf("expected no measurements in advertisement")
+	}
+
+	err = dev.SetIntegrations(true)
+	if err != nil {
+		t.Fatalf("could not enable integrations: %+v", err)
+	}
+	_, err = aranet4.ParseAdvertisement(sensor.Advertisement())
+	if err != nil {
+		t.Fatalf("could not parse advertisement: %+v", err)
+	}
+
+	err = dev.SetBluetoothRange(aranet4.RangeExtended)
+	if err != nil {
+		t.Fatalf("could not set bluetooth range: %+v", err)
+	}
+	r, err := dev.BluetoothRange()
+	if err != nil {
+		t.Fatalf("could not read bluetooth range: %+v", err)
+	}
+	if got, want := r, aranet4.RangeExtended; got != want {
+		t.Fatalf("invalid bluetooth range: got=%v, want=%v", got, want)
+	}
+}
+
+func TestSensorInfo(t *testing.T) {
+	sensor := newSensor(emu.Config{Name: "Aranet4 0A1B2", Battery: 42})
+	dev := aranet4.NewWithTransport(sensor.Connect())
+	defer dev.Close()
+
+	info, err := dev.Info()
+	if err != nil {
+		t.Fatalf("could not read device info: %+v", err)
+	}
+	if got, want := info.Name, "Aranet4 0A1B2"; got != want {
+		t.Fatalf("invalid name: got=%q, want=%q", got, want)
+	}
+	if got, want := info.Software, "v1.2.0"; got != want {
+		t.Fatalf("invalid software revision: got=%q, want=%q", got, want)
+	}
+	if got, want := info.Battery, 42; got != want {
+		t.Fatalf("invalid battery: got=%d, want=%d", got, want)
+	}
+}
+
+func TestSensorMissing(t *testing.T) {
+	var (
+		calib = epoch.Add(-5 * time.Minute)
+		co2   = func(t time.Time) float64 {
+			if t.Before(calib) {
+				return 800
+			}
+			return math.NaN()
+		}
+	)
+	sensor := newSensor(emu.Config{CO2: co2})
+	dev := aranet4.NewWithTransport(sensor.Connect())
+	defer dev.Close()
+
+	cur, err := dev.Read()
+	if err != nil {
+		t.Fatalf("could not read data: %+v", err)
+	}
+	if got, want := cur.Missing, aranet4.FieldCO2; got != want {
+		t.Fatalf("invalid missing fields: got=%v, want=%v", got, want)
+	}
+
+	vs, err := dev.ReadAll()
+	if err != nil {
+		t.Fatalf("could not read history: %+v", err)
+	}
+	for i, want := range []aranet4.Field{0, 0, aranet4.FieldCO2} {
+		if got := vs[i].Missing; got != want {
+			t.Fatalf("invalid missing fields for sample %d: got=%v, want=%v", i, got, want)
+		}
+	}
+	if got, want := vs[0].CO2, 800; got != want {
+		t.Fatalf("invalid CO2: got=%d, want=%d", got, want)
+	}
+}
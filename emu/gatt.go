@@ -0,0 +1,470 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package emu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+const (
+	uuidDeviceService          = "f0cd1400-95da-4f4b-9ac8-aa55d312af0c"
+	uuidReadSettings           = "f0cd1401-95da-4f4b-9ac8-aa55d312af0c"
+	uuidWriteCmd               = "f0cd1402-95da-4f4b-9ac8-aa55d312af0c"
+	uuidReadSample             = "f0cd1503-95da-4f4b-9ac8-aa55d312af0c"
+	uuidReadAll                = "f0cd3001-95da-4f4b-9ac8-aa55d312af0c"
+	uuidReadInterval           = "f0cd2002-95da-4f4b-9ac8-aa55d312af0c"
+	uuidReadTimeSeries         = "f0cd2003-95da-4f4b-9ac8-aa55d312af0c"
+	uuidReadSecondsSinceUpdate = "f0cd2004-95da-4f4b-9ac8-aa55d312af0c"
+	uuidReadTotalReadings      = "f0cd2001-95da-4f4b-9ac8-aa55d312af0c"
+
+	uuidGenericService        = "00001800-0000-1000-8000-00805f9b34fb"
+	uuidGenericReadDeviceName = "00002a00-0000-1000-8000-00805f9b34fb"
+
+	uuidCommonService              = "0000180a-0000-1000-8000-00805f9b34fb"
+	uuidCommonReadManufacturerName = "00002a29-0000-1000-8000-00805f9b34fb"
+	uuidCommonReadModelNumber      = "00002a24-0000-1000-8000-00805f9b34fb"
+	uuidCommonReadSerialNumber     = "00002a25-0000-1000-8000-00805f9b34fb"
+	uuidCommonReadHWRevision       = "00002a27-0000-1000-8000-00805f9b34fb"
+	uuidCommonReadSWRevision       = "00002a28-0000-1000-8000-00805f9b34fb"
+
+	uuidBatteryService    = "0000180f-0000-1000-8000-00805f9b34fb"
+	uuidCommonReadBattery = "00002a19-0000-1000-8000-00805f9b34fb"
+)
+
+const (
+	paramT   = 1
+	paramH   = 2
+	paramP   = 3
+	paramCO2 = 4
+)
+
+const (
+	cmdHistory         = 0x82
+	cmdSetInterval     = 0x90
+	cmdSetIntegrations = 0x91
+	cmdSetRange        = 0x92
+)
+
+const (
+	settingsIntegrations  = 1 << 5
+	settingsRangeExtended = 1 << 6
+)
+
+var errDisconnected = fmt.Errorf("emu: device disconnected")
+
+// conn is a GATT connection to a software Aranet4 device.
+type conn struct {
+	s *Sensor
+
+	mu     sync.Mutex
+	closed bool
+	svcs   map[string]*service
+	notify func(p []byte) // time-series notification callback
+	req    []byte         // pending history request
+	stop   chan struct{}  // stops the on-going history stream
+}
+
+func newConn(s *Sensor) *conn {
+	c := &conn{
+		s:    s,
+		svcs: make(map[string]*service),
+	}
+	c.addService(uuidDeviceService,
+		&characteristic{uuid: uuidReadAll, read: c.readAll},
+		&characteristic{uuid: uuidReadSample, read: c.readSample},
+		&characteristic{uuid: uuidReadInterval, read: c.readInterval},
+		&characteristic{uuid: uuidReadSecondsSinceUpdate, read: c.readSince},
+		&characteristic{uuid: uuidReadTotalReadings, read: c.readTotal},
+		&characteristic{uuid: uuidReadSettings, read: c.readSettings},
+		&characteristic{uuid: uuidWriteCmd, write: c.writeCmd},
+		&characteristic{uuid: uuidReadTimeSeries, subscribe: c.subscribe},
+	)
+	c.addService(uuidGenericService,
+		&characteristic{uuid: uuidGenericReadDeviceName, read: c.str(s.cfg.Name)},
+	)
+	c.addService(uuidCommonService,
+		&characteristic{uuid: uuidCommonReadManufacturerName, read: c.str("SAF Tehnika")},
+		&characteristic{uuid: uuidCommonReadModelNumber, read: c.str("Aranet4")},
+		&characteristic{uuid: uuidCommonReadSerialNumber, read: c.str("0123456789")},
+		&characteristic{uuid: uuidCommonReadHWRevision, read: c.str("12")},
+		&characteristic{uuid: uuidCommonReadSWRevision, read: c.str(s.cfg.Version)},
+	)
+	c.addService(uuidBatteryService,
+		&characteristic{uuid: uuidCommonReadBattery, read: c.readBattery},
+	)
+	return c
+}
+
+func (c *conn) addService(uuid string, chars ...*characteristic) {
+	svc := &service{
+		c:     c,
+		uuid:  uuid,
+		chars: make(map[string]*characteristic, len(chars)),
+	}
+	for _, char := range chars {
+		char.c = c
+		svc.chars[char.uuid] = char
+	}
+	c.svcs[uuid] = svc
+}
+
+func (c *conn) DiscoverService(uuid string) (aranet4.Service, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, errDisconnected
+	}
+	svc, ok := c.svcs[uuid]
+	if !ok {
+		return nil, fmt.Errorf("emu: could not find service %q", uuid)
+	}
+	return svc, nil
+}
+
+func (c *conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.notify = nil
+	c.req = nil
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.s.release(c)
+	return nil
+}
+
+func (c *conn) str(v string) func() []byte {
+	return func() []byte { return []byte(v) }
+}
+
+func (c *conn) readAll() []byte {
+	return c.s.current(c.s.cfg.Now(), true)
+}
+
+func (c *conn) readSample() []byte {
+	return c.s.current(c.s.cfg.Now(), false)
+}
+
+func (c *conn) readInterval() []byte {
+	return appendU16(nil, uint16(c.s.timeline().interval/time.Second))
+}
+
+func (c *conn) readSince() []byte {
+	return appendU16(nil, uint16(c.s.timeline().since(c.s.cfg.Now())/time.Second))
+}
+
+func (c *conn) readTotal() []byte {
+	_, n := c.s.timeline().window(c.s.cfg.Now())
+	return appendU16(nil, uint16(n))
+}
+
+func (c *conn) readSettings() []byte {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	var flags byte
+	if c.s.integrations {
+		flags |= settingsIntegrations
+	}
+	if c.s.extended {
+		flags |= settingsRangeExtended
+	}
+	return []byte{flags}
+}
+
+func (c *conn) readBattery() []byte {
+	return []byte{uint8(c.s.cfg.Battery)}
+}
+
+func (c *conn) writeCmd(p []byte) error {
+	if len(p) < 1 {
+		return fmt.Errorf("emu: empty command")
+	}
+	switch p[0] {
+	case cmdHistory:
+		if len(p) != 8 {
+			return fmt.Errorf("emu: invalid history command length (%d)", len(p))
+		}
+		switch p[1] {
+		case paramT, paramH, paramP, paramCO2:
+		default:
+			return fmt.Errorf("emu: invalid history parameter 0x%x", p[1])
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.req = append([]byte(nil), p...)
+		c.start()
+		return nil
+	case cmdSetInterval:
+		if len(p) != 2 {
+			return fmt.Errorf("emu: invalid interval command length (%d)", len(p))
+		}
+		switch p[1] {
+		case 1, 2, 5, 10:
+		default:
+			return fmt.Errorf("emu: invalid interval (%d min)", p[1])
+		}
+		c.s.setInterval(time.Duration(p[1]) * time.Minute)
+		return nil
+	case cmdSetIntegrations, cmdSetRange:
+		if len(p) != 2 {
+			return fmt.Errorf("emu: invalid command 0x%x length (%d)", p[0], len(p))
+		}
+		if p[1] > 1 {
+			return fmt.Errorf("emu: invalid command 0x%x value (%d)", p[0], p[1])
+		}
+		if p[0] == cmdSetIntegrations {
+			c.s.setIntegrations(p[1] == 1)
+		} else {
+			c.s.setExtendedRange(p[1] == 1)
+		}
+		return nil
+	default:
+		return fmt.Errorf("emu: unknown command 0x%x", p[0])
+	}
+}
+
+func (c *conn) subscribe(cb func(p []byte)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.notify = cb
+	if cb == nil && c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.start()
+	return nil
+}
+
+// start starts streaming the pending history request, if any, to
+// the subscribed client, if any.
+// start must be called with c.mu held.
+func (c *conn) start() {
+	if c.req == nil || c.notify == nil {
+		return
+	}
+	if c.stop != nil {
+		close(c.stop)
+	}
+
+	var (
+		req  = c.req
+		cb   = c.notify
+		stop = make(chan struct{})
+	)
+	c.req = nil
+	c.stop = stop
+
+	chunks := c.s.history(req, c.s.cfg.MTU)
+	go func() {
+		for _, p := range chunks {
+			select {
+			case <-stop:
+				return
+			default:
+				cb(p)
+			}
+		}
+	}()
+}
+
+// current returns the encoded latest measurement, with the measurement
+// interval and the elapsed time since that measurement if full is true.
+func (s *Sensor) current(now time.Time, full bool) []byte {
+	var (
+		tl     = s.timeline()
+		beg, n = tl.window(now)
+		cur    = s.sample(tl, beg+n-1)
+	)
+
+	p := make([]byte, 0, 13)
+	p = appendCO2(p, cur)
+	p = appendT(p, cur)
+	p = appendP(p, cur)
+	p = append(p, uint8(cur.H), uint8(cur.Battery), uint8(cur.Status))
+	if full {
+		p = appendU16(p, uint16(tl.interval/time.Second))
+		p = appendU16(p, uint16(tl.since(now)/time.Second))
+	}
+	return p
+}
+
+// history returns the notification payloads answering the provided
+// history request.
+func (s *Sensor) history(req []byte, mtu int) [][]byte {
+	var (
+		id   = req[1]
+		from = int(binary.LittleEndian.Uint16(req[4:]))
+		to   = int(binary.LittleEndian.Uint16(req[6:]))
+	)
+	tl := s.timeline()
+	beg, n := tl.window(s.cfg.Now())
+	if from < 1 {
+		from = 1
+	}
+	if to > n {
+		to = n
+	}
+
+	size := 2
+	if id == paramH {
+		size = 1
+	}
+	max := (mtu - 4) / size
+	if max > 255 {
+		max = 255
+	}
+
+	var out [][]byte
+	for i := from; i <= to; i += max {
+		cnt := to - i + 1
+		if cnt > max {
+			cnt = max
+		}
+		p := make([]byte, 4, 4+cnt*size)
+		p[0] = id
+		binary.LittleEndian.PutUint16(p[1:], uint16(i))
+		p[3] = uint8(cnt)
+		for j := i; j < i+cnt; j++ {
+			v := s.sample(tl, beg+j-1)
+			switch id {
+			case paramT:
+				p = appendT(p, v)
+			case paramH:
+				p = append(p, uint8(v.H))
+			case paramP:
+				p = appendP(p, v)
+			case paramCO2:
+				p = appendCO2(p, v)
+			}
+		}
+		out = append(out, p)
+	}
+
+	// end-of-stream marker.
+	p := []byte{id, 0, 0, 0}
+	binary.LittleEndian.PutUint16(p[1:], uint16(to+1))
+	out = append(out, p)
+	return out
+}
+
+type service struct {
+	c     *conn
+	uuid  string
+	chars map[string]*characteristic
+}
+
+func (svc *service) UUID() string { return svc.uuid }
+
+func (svc *service) DiscoverCharacteristic(uuid string) (aranet4.Characteristic, error) {
+	char, ok := svc.chars[uuid]
+	if !ok {
+		return nil, fmt.Errorf("emu: could not find characteristic %q", uuid)
+	}
+	return char, nil
+}
+
+type characteristic struct {
+	c    *conn
+	uuid string
+
+	read      func() []byte
+	write     func(p []byte) error
+	subscribe func(cb func(p []byte)) error
+}
+
+func (char *characteristic) UUID() string { return char.uuid }
+
+func (char *characteristic) connected() error {
+	char.c.mu.Lock()
+	defer char.c.mu.Unlock()
+	if char.c.closed {
+		return errDisconnected
+	}
+	return nil
+}
+
+func (char *characteristic) Read(p []byte) (int, error) {
+	if err := char.connected(); err != nil {
+		return 0, err
+	}
+	if char.read == nil {
+		return 0, fmt.Errorf("emu: characteristic %q is not readable", char.uuid)
+	}
+	return copy(p, char.read()), nil
+}
+
+func (char *characteristic) WriteWithoutResponse(p []byte) (int, error) {
+	if err := char.connected(); err != nil {
+		return 0, err
+	}
+	if char.write == nil {
+		return 0, fmt.Errorf("emu: characteristic %q is not writable", char.uuid)
+	}
+	err := char.write(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (char *characteristic) EnableNotifications(cb func(p []byte)) error {
+	if err := char.connected(); err != nil {
+		return err
+	}
+	if char.subscribe == nil {
+		return fmt.Errorf("emu: characteristic %q does not support notifications", char.uuid)
+	}
+	return char.subscribe(cb)
+}
+
+// appendT appends the encoded temperature of v to p, or the "not
+// available" marker if it is missing.
+func appendT(p []byte, v aranet4.Data) []byte {
+	if !v.Valid(aranet4.FieldT) {
+		return appendU16(p, 0x4000)
+	}
+	return appendU16(p, uint16(int16(math.Round(v.T*20))))
+}
+
+// appendP appends the encoded pressure of v to p.
+func appendP(p []byte, v aranet4.Data) []byte {
+	if !v.Valid(aranet4.FieldP) {
+		return appendU16(p, 0x8000)
+	}
+	return appendU16(p, uint16(math.Round(v.P*10)))
+}
+
+// appendCO2 appends the encoded CO2 level of v to p.
+func appendCO2(p []byte, v aranet4.Data) []byte {
+	if !v.Valid(aranet4.FieldCO2) {
+		return appendU16(p, 0x8000)
+	}
+	return appendU16(p, uint16(v.CO2))
+}
+
+func appendU16(p []byte, v uint16) []byte {
+	return append(p, byte(v), byte(v>>8))
+}
+
+var (
+	_ aranet4.Transport      = (*conn)(nil)
+	_ aranet4.Service        = (*service)(nil)
+	_ aranet4.Characteristic = (*characteristic)(nil)
+)
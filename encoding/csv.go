@@ -0,0 +1,133 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encoding
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+
+	"sbinet.org/x/aranet4"
+)
+
+// CSVWriter encodes data samples as comma separated values.
+// The first row holds the names of the columns.
+// Missing and unavailable values are left empty.
+type CSVWriter struct {
+	w      *csv.Writer
+	header bool // whether the header row was written
+	row    []string
+}
+
+// NewCSVWriter returns a CSV writer encoding data samples to w.
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{
+		w:   csv.NewWriter(w),
+		row: make([]string, len(columns)),
+	}
+}
+
+// Write encodes a data sample.
+func (w *CSVWriter) Write(data aranet4.Data) error {
+	if !w.header {
+		for i, col := range columns {
+			w.row[i] = col.key
+		}
+		err := w.w.Write(w.row)
+		if err != nil {
+			return fmt.Errorf("encoding: could not write CSV header: %w", err)
+		}
+		w.header = true
+	}
+
+	for i, col := range columns {
+		v, ok, err := col.get(&data)
+		if err != nil {
+			return fmt.Errorf("encoding: could not encode data sample: %w", err)
+		}
+		if !ok {
+			v = ""
+		}
+		w.row[i] = v
+	}
+	err := w.w.Write(w.row)
+	if err != nil {
+		return fmt.Errorf("encoding: could not write CSV row: %w", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *CSVWriter) Flush() error {
+	w.w.Flush()
+	err := w.w.Error()
+	if err != nil {
+		return fmt.Errorf("encoding: could not flush CSV writer: %w", err)
+	}
+	return nil
+}
+
+// CSVReader decodes data samples from comma separated values.
+// The first row must hold the names of the columns; columns may come in
+// any order and unknown columns are ignored.
+type CSVReader struct {
+	r    *csv.Reader
+	cols []*column // column of each field of a row, nil if unknown
+}
+
+// NewCSVReader returns a CSV reader decoding data samples from r.
+func NewCSVReader(r io.Reader) *CSVReader {
+	rr := csv.NewReader(r)
+	rr.ReuseRecord = true
+	return &CSVReader{r: rr}
+}
+
+// Read decodes the next data sample.
+func (r *CSVReader) Read() (aranet4.Data, error) {
+	if r.cols == nil {
+		hdr, err := r.r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return aranet4.Data{}, io.EOF
+			}
+			return aranet4.Data{}, fmt.Errorf("encoding: could not read CSV header: %w", err)
+		}
+		r.cols = make([]*column, len(hdr))
+		for i, name := range hdr {
+			for j := range columns {
+				if columns[j].key == name {
+					r.cols[i] = &columns[j]
+					break
+				}
+			}
+		}
+	}
+
+	row, err := r.r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return aranet4.Data{}, io.EOF
+		}
+		return aranet4.Data{}, fmt.Errorf("encoding: could not read CSV row: %w", err)
+	}
+
+	var s sample
+	for i, v := range row {
+		col := r.cols[i]
+		if col == nil || v == "" {
+			continue
+		}
+		err := s.set(col, v)
+		if err != nil {
+			return aranet4.Data{}, err
+		}
+	}
+	return s.finish(), nil
+}
+
+var (
+	_ Writer = (*CSVWriter)(nil)
+	_ Reader = (*CSVReader)(nil)
+)
@@ -0,0 +1,308 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package encoding provides machine-readable encodings for the data
+// samples of Aranet devices: CSV, newline delimited JSON and the InfluxDB
+// line protocol.
+//
+// All encodings use the same names and units for the measured quantities
+// as the JSON encoding of aranet4.Data, e.g. "co2_ppm" or "temperature_c".
+// Time-stamps are encoded with RFC 3339 (or as nanoseconds since the Unix
+// epoch, for the InfluxDB line protocol).
+package encoding // import "sbinet.org/x/aranet4/encoding"
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+// Format is an encoding of data samples.
+type Format int
+
+const (
+	CSV    Format = iota // comma separated values, with a header row
+	NDJSON               // newline delimited JSON
+	Influx               // InfluxDB line protocol
+)
+
+func (f Format) String() string {
+	switch f {
+	case CSV:
+		return "csv"
+	case NDJSON:
+		return "ndjson"
+	case Influx:
+		return "influx"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// ParseFormat returns the format with the provided name, as returned
+// by Format.String.
+func ParseFormat(name string) (Format, error) {
+	for _, f := range []Format{CSV, NDJSON, Influx} {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("encoding: unknown format %q", name)
+}
+
+// Writer encodes data samples.
+type Writer interface {
+	// Write encodes a data sample.
+	// As with aranet4.Data.MarshalJSON, non-finite values are rejected.
+	Write(data aranet4.Data) error
+
+	// Flush writes any buffered data to the underlying io.Writer.
+	Flush() error
+}
+
+// Reader decodes data samples.
+type Reader interface {
+	// Read decodes the next data sample.
+	// Read returns io.EOF when no more samples are available.
+	Read() (aranet4.Data, error)
+}
+
+// NewWriter returns a writer encoding data samples to w, in the provided
+// format.
+func NewWriter(w io.Writer, f Format) (Writer, error) {
+	switch f {
+	case CSV:
+		return NewCSVWriter(w), nil
+	case NDJSON:
+		return NewNDJSONWriter(w), nil
+	case Influx:
+		return NewInfluxWriter(w), nil
+	default:
+		return nil, fmt.Errorf("encoding: unknown format %v", f)
+	}
+}
+
+// NewReader returns a reader decoding data samples from r, in the
+// provided format.
+func NewReader(r io.Reader, f Format) (Reader, error) {
+	switch f {
+	case CSV:
+		return NewCSVReader(r), nil
+	case NDJSON:
+		return NewNDJSONReader(r), nil
+	case Influx:
+		return NewInfluxReader(r), nil
+	default:
+		return nil, fmt.Errorf("encoding: unknown format %v", f)
+	}
+}
+
+// WriteAll encodes all the provided data samples to w, in the provided
+// format.
+func WriteAll(w io.Writer, f Format, vs []aranet4.Data) error {
+	enc, err := NewWriter(w, f)
+	if err != nil {
+		return err
+	}
+	for _, v := range vs {
+		err = enc.Write(v)
+		if err != nil {
+			return err
+		}
+	}
+	return enc.Flush()
+}
+
+// ReadAll decodes all the data samples from r, in the provided format.
+func ReadAll(r io.Reader, f Format) ([]aranet4.Data, error) {
+	dec, err := NewReader(r, f)
+	if err != nil {
+		return nil, err
+	}
+	var vs []aranet4.Data
+	for {
+		v, err := dec.Read()
+		if err == io.EOF {
+			return vs, nil
+		}
+		if err != nil {
+			return vs, err
+		}
+		vs = append(vs, v)
+	}
+}
+
+// kind is the type of the values of a column.
+type kind uint8
+
+const (
+	kindFloat kind = iota
+	kindInt
+	kindString
+)
+
+// column describes how a property of a data sample is encoded.
+type column struct {
+	key  string
+	kind kind
+
+	// get returns the value of the property, and whether it is available.
+	// get fails if the value can not be encoded.
+	get func(data *aranet4.Data) (string, bool, error)
+	// set decodes the value of the property.
+	set func(data *aranet4.Data, v string) error
+
+	// field is the measured quantity of the column, if any.
+	field aranet4.Field
+}
+
+// columns lists the encoded properties of a data sample, in order.
+// The measured quantities follow aranet4.Quantities.
+var columns = func() []column {
+	cols := []column{timeColumn, modelColumn}
+	for _, q := range aranet4.Quantities() {
+		cols = append(cols, quantityColumn(q))
+	}
+	return append(cols, qualityColumn, batteryColumn, intervalColumn)
+}()
+
+var (
+	timeColumn = column{
+		key:  "time",
+		kind: kindString,
+		get: func(data *aranet4.Data) (string, bool, error) {
+			return data.Time.Format(time.RFC3339Nano), true, nil
+		},
+		set: func(data *aranet4.Data, v string) (err error) {
+			data.Time, err = time.Parse(time.RFC3339Nano, v)
+			return err
+		},
+	}
+	modelColumn = column{
+		key:  "model",
+		kind: kindString,
+		get: func(data *aranet4.Data) (string, bool, error) {
+			return data.Model.String(), true, nil
+		},
+		set: func(data *aranet4.Data, v string) error {
+			return data.Model.UnmarshalText([]byte(v))
+		},
+	}
+	qualityColumn = column{
+		key:  "quality",
+		kind: kindString,
+		get: func(data *aranet4.Data) (string, bool, error) {
+			if data.Quality == 0 || data.Model.Fields()&(aranet4.FieldCO2|aranet4.FieldRadon) == 0 {
+				return "", false, nil
+			}
+			return data.Quality.String(), true, nil
+		},
+		set: func(data *aranet4.Data, v string) error {
+			return data.Quality.UnmarshalText([]byte(v))
+		},
+	}
+	batteryColumn = column{
+		key:  "battery_pct",
+		kind: kindInt,
+		get: func(data *aranet4.Data) (string, bool, error) {
+			if data.Battery < 0 {
+				return "", false, nil
+			}
+			return strconv.Itoa(data.Battery), true, nil
+		},
+		set: func(data *aranet4.Data, v string) (err error) {
+			data.Battery, err = strconv.Atoi(v)
+			return err
+		},
+	}
+	intervalColumn = column{
+		key:  "interval_s",
+		kind: kindFloat,
+		get: func(data *aranet4.Data) (string, bool, error) {
+			return strconv.FormatFloat(data.Interval.Seconds(), 'g', -1, 64), true, nil
+		},
+		set: func(data *aranet4.Data, v string) error {
+			sec, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			data.Interval = time.Duration(math.Round(sec * float64(time.Second)))
+			return nil
+		},
+	}
+)
+
+// quantityColumn returns the column of a measured quantity.
+func quantityColumn(q aranet4.Quantity) column {
+	col := column{
+		key:   q.Key,
+		kind:  kindFloat,
+		field: q.Field,
+		get: func(data *aranet4.Data) (string, bool, error) {
+			if !data.Valid(q.Field) {
+				return "", false, nil
+			}
+			v, err := q.Value(*data)
+			if err != nil {
+				return "", false, err
+			}
+			return strconv.FormatFloat(v, 'g', -1, 64), true, nil
+		},
+		set: func(data *aranet4.Data, v string) error {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			q.SetValue(data, f)
+			return nil
+		},
+	}
+	if q.Int {
+		col.kind = kindInt
+		col.set = func(data *aranet4.Data, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			q.SetValue(data, float64(n))
+			return nil
+		}
+	}
+	return col
+}
+
+// sample accumulates the decoded properties of a data sample.
+type sample struct {
+	data aranet4.Data
+	seen aranet4.Field // measured quantities that were decoded
+	batt bool          // whether the battery level was decoded
+}
+
+func (s *sample) set(col *column, v string) error {
+	err := col.set(&s.data, v)
+	if err != nil {
+		return fmt.Errorf("encoding: could not decode %s value %q: %w", col.key, v, err)
+	}
+	s.seen |= col.field
+	if col.key == "battery_pct" {
+		s.batt = true
+	}
+	return nil
+}
+
+// finish returns the decoded sample.
+// Quantities measured by the model of the device that were not decoded
+// are flagged as missing, and an absent battery level is unknown.
+func (s *sample) finish() aranet4.Data {
+	data := s.data
+	data.Missing = data.Model.Fields() &^ s.seen
+	if !s.batt {
+		data.Battery = -1
+	}
+	return data
+}
@@ -0,0 +1,209 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encoding
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+var samples = []aranet4.Data{
+	{
+		H: 29, P: 980.5, T: 19.85,
+		CO2:      547,
+		Battery:  96,
+		Quality:  1,
+		Model:    aranet4.ModelAranet4,
+		Interval: 5 * time.Minute,
+		Time:     time.Date(2022, 1, 20, 15, 48, 28, 0, time.UTC),
+	},
+	{
+		H:        30,
+		Battery:  -1,
+		Model:    aranet4.ModelAranet4,
+		Interval: 5 * time.Minute,
+		Time:     time.Date(2022, 1, 20, 15, 53, 28, 500, time.UTC),
+		Missing:  aranet4.FieldT | aranet4.FieldP | aranet4.FieldCO2,
+	},
+	{
+		T: -3.5, H: 61.2,
+		Battery:  80,
+		Model:    aranet4.ModelAranet2,
+		Interval: 2 * time.Minute,
+		Time:     time.Date(2022, 1, 20, 16, 0, 0, 0, time.UTC),
+	},
+	{
+		DoseRate: 0.09, Dose: 0.012345,
+		Battery:  100,
+		Model:    aranet4.ModelAranetRadiation,
+		Interval: 10 * time.Minute,
+		Time:     time.Date(2022, 1, 20, 16, 0, 0, 0, time.UTC),
+	},
+	{
+		T: 21, P: 1013.2, H: 40,
+		Radon:    42,
+		Battery:  -1,
+		Quality:  1,
+		Model:    aranet4.ModelAranetRadon,
+		Interval: time.Hour,
+		Time:     time.Date(2022, 1, 20, 17, 0, 0, 0, time.UTC),
+	},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, f := range []Format{CSV, NDJSON, Influx} {
+		t.Run(f.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WriteAll(&buf, f, samples)
+			if err != nil {
+				t.Fatalf("could not write samples: %+v", err)
+			}
+
+			got, err := ReadAll(&buf, f)
+			if err != nil {
+				t.Fatalf("could not read samples: %+v", err)
+			}
+			if !reflect.DeepEqual(got, samples) {
+				t.Fatalf("invalid round-trip:\ngot= %+v\nwant=%+v", got, samples)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	for _, tc := range []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: CSV,
+			want: `time,model,co2_ppm,radon_bqm3,dose_rate_usvh,dose_msv,temperature_c,pressure_hpa,humidity_pct,quality,battery_pct,interval_s
+2022-01-20T15:48:28Z,Aranet4,547,,,,19.85,980.5,29,green,96,300
+2022-01-20T15:53:28.0000005Z,Aranet4,,,,,,,30,,,300
+`,
+		},
+		{
+			format: NDJSON,
+			want: `{"time":"2022-01-20T15:48:28Z","model":"Aranet4","co2_ppm":547,"temperature_c":19.85,"pressure_hpa":980.5,"humidity_pct":29,"quality":"green","battery_pct":96,"interval_s":300}
+{"time":"2022-01-20T15:53:28.0000005Z","model":"Aranet4","co2_ppm":null,"temperature_c":null,"pressure_hpa":null,"humidity_pct":30,"quality":null,"battery_pct":null,"interval_s":300}
+`,
+		},
+		{
+			format: Influx,
+			want: `aranet4,model=Aranet4 co2_ppm=547i,temperature_c=19.85,pressure_hpa=980.5,humidity_pct=29,quality="green",battery_pct=96i,interval_s=300 1642693708000000000
+aranet4,model=Aranet4 humidity_pct=30,interval_s=300 1642694008000000500
+`,
+		},
+	} {
+		t.Run(tc.format.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WriteAll(&buf, tc.format, samples[:2])
+			if err != nil {
+				t.Fatalf("could not write samples: %+v", err)
+			}
+			if got, want := buf.String(), tc.want; got != want {
+				t.Fatalf("invalid output:\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteNonFinite(t *testing.T) {
+	for _, data := range []aranet4.Data{
+		{Model: aranet4.ModelAranet4, T: math.NaN(), Battery: -1},
+		{Model: aranet4.ModelAranetRadiation, DoseRate: math.Inf(+1), Battery: -1},
+	} {
+		for _, f := range []Format{CSV, NDJSON, Influx} {
+			t.Run(f.String(), func(t *testing.T) {
+				var buf bytes.Buffer
+				err := WriteAll(&buf, f, []aranet4.Data{data})
+				if err == nil {
+					t.Fatalf("expected an error encoding %#v", data)
+				}
+			})
+		}
+	}
+}
+
+func TestReadInflux(t *testing.T) {
+	const input = `# comment
+cpu,host=a usage=1 1642693708000000000
+
+aranet4,model=Aranet\ Radiation,room=lab dose_rate_usvh=0.09,dose_msv=0.012345,battery_pct=100i,interval_s=600,extra="a b" 1642694400000000000
+`
+	got, err := ReadAll(strings.NewReader(input), Influx)
+	if err != nil {
+		t.Fatalf("could not read samples: %+v", err)
+	}
+	if want := samples[3:4]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid samples:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	_, err = ReadAll(strings.NewReader("aranet4,model=Aranet4 co2_ppm=abc 0\n"), Influx)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestColumnsMatchJSON(t *testing.T) {
+	var keys []string
+	for _, data := range samples {
+		p, err := json.Marshal(data)
+		if err != nil {
+			t.Fatalf("could not marshal sample: %+v", err)
+		}
+		var m map[string]interface{}
+		err = json.Unmarshal(p, &m)
+		if err != nil {
+			t.Fatalf("could not unmarshal sample: %+v", err)
+		}
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		seen[k] = true
+	}
+	keys = keys[:0]
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var cols []string
+	for _, col := range columns {
+		cols = append(cols, col.key)
+	}
+	sort.Strings(cols)
+
+	if !reflect.DeepEqual(keys, cols) {
+		t.Fatalf("invalid columns:\ngot= %q\nwant=%q", cols, keys)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range []Format{CSV, NDJSON, Influx} {
+		got, err := ParseFormat(f.String())
+		if err != nil {
+			t.Fatalf("could not parse format %q: %+v", f, err)
+		}
+		if got != f {
+			t.Fatalf("invalid format: got=%v, want=%v", got, f)
+		}
+	}
+	_, err := ParseFormat("xml")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encoding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"sbinet.org/x/aranet4"
+)
+
+// Measurement is the name of the InfluxDB measurement of data samples.
+const Measurement = "aranet4"
+
+// InfluxWriter encodes data samples with the InfluxDB line protocol.
+//
+// Each sample is encoded as a point of the "aranet4" measurement, tagged
+// with the model of the device, and time-stamped with nanoseconds
+// since the Unix epoch.
+// Missing and unavailable values are omitted.
+type InfluxWriter struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+// NewInfluxWriter returns an InfluxDB line protocol writer encoding data
+// samples to w.
+func NewInfluxWriter(w io.Writer) *InfluxWriter {
+	return &InfluxWriter{w: bufio.NewWriter(w)}
+}
+
+// Write encodes a data sample.
+func (w *InfluxWriter) Write(data aranet4.Data) error {
+	buf := append(w.buf[:0], Measurement...)
+	buf = append(buf, ",model="...)
+	buf = append(buf, influxTagEscaper.Replace(data.Model.String())...)
+
+	sep := byte(' ')
+	for i := range columns {
+		col := &columns[i]
+		switch col.key {
+		case "time", "model":
+			continue
+		}
+		v, ok, err := col.get(&data)
+		if err != nil {
+			return fmt.Errorf("encoding: could not encode data sample: %w", err)
+		}
+		if !ok {
+			continue
+		}
+		buf = append(buf, sep)
+		buf = append(buf, col.key...)
+		buf = append(buf, '=')
+		switch col.kind {
+		case kindInt:
+			buf = append(buf, v...)
+			buf = append(buf, 'i')
+		case kindString:
+			buf = append(buf, '"')
+			buf = append(buf, influxStringEscaper.Replace(v)...)
+			buf = append(buf, '"')
+		default:
+			buf = append(buf, v...)
+		}
+		sep = ','
+	}
+	if sep == ' ' {
+		return fmt.Errorf("encoding: data sample without any field")
+	}
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, data.Time.UnixNano(), 10)
+	buf = append(buf, '\n')
+	w.buf = buf
+
+	_, err := w.w.Write(buf)
+	if err != nil {
+		return fmt.Errorf("encoding: could not write data sample: %w", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *InfluxWriter) Flush() error {
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("encoding: could not flush InfluxDB writer: %w", err)
+	}
+	return nil
+}
+
+var (
+	influxTagEscaper    = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+	influxStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// InfluxReader decodes data samples from the InfluxDB line protocol, as
+// written by InfluxWriter.
+// Points of other measurements, empty lines and comments are skipped.
+type InfluxReader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+// NewInfluxReader returns an InfluxDB line protocol reader decoding data
+// samples from r.
+func NewInfluxReader(r io.Reader) *InfluxReader {
+	return &InfluxReader{sc: bufio.NewScanner(r)}
+}
+
+// Read decodes the next data sample.
+func (r *InfluxReader) Read() (aranet4.Data, error) {
+	for r.sc.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		data, ok, err := r.parse(line)
+		if err != nil {
+			return aranet4.Data{}, fmt.Errorf("encoding: could not decode line %d: %w", r.line, err)
+		}
+		if !ok {
+			continue
+		}
+		return data, nil
+	}
+	err := r.sc.Err()
+	if err != nil {
+		return aranet4.Data{}, fmt.Errorf("encoding: could not read InfluxDB line protocol: %w", err)
+	}
+	return aranet4.Data{}, io.EOF
+}
+
+// parse decodes a line of the InfluxDB line protocol.
+// parse returns false if the line is a point of another measurement.
+func (r *InfluxReader) parse(line string) (aranet4.Data, bool, error) {
+	secs := splitInflux(line, ' ')
+	if len(secs) != 3 {
+		return aranet4.Data{}, false, fmt.Errorf("invalid number of sections (got=%d, want=3)", len(secs))
+	}
+
+	series := splitInflux(secs[0], ',')
+	if unescapeInflux(series[0]) != Measurement {
+		return aranet4.Data{}, false, nil
+	}
+
+	var s sample
+	for _, tag := range series[1:] {
+		kv := splitInflux(tag, '=')
+		if len(kv) != 2 {
+			return aranet4.Data{}, false, fmt.Errorf("invalid tag %q", tag)
+		}
+		if unescapeInflux(kv[0]) != "model" {
+			continue
+		}
+		err := s.set(lookup("model"), unescapeInflux(kv[1]))
+		if err != nil {
+			return aranet4.Data{}, false, err
+		}
+	}
+
+	for _, field := range splitInflux(secs[1], ',') {
+		kv := splitInflux(field, '=')
+		if len(kv) != 2 {
+			return aranet4.Data{}, false, fmt.Errorf("invalid field %q", field)
+		}
+		col := lookup(unescapeInflux(kv[0]))
+		if col == nil {
+			continue
+		}
+		v := kv[1]
+		switch {
+		case strings.HasPrefix(v, `"`):
+			if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+				return aranet4.Data{}, false, fmt.Errorf("invalid string field %q", field)
+			}
+			v = unescapeInflux(v[1 : len(v)-1])
+		case strings.HasSuffix(v, "i"):
+			v = v[:len(v)-1]
+		}
+		err := s.set(col, v)
+		if err != nil {
+			return aranet4.Data{}, false, err
+		}
+	}
+
+	ns, err := strconv.ParseInt(secs[2], 10, 64)
+	if err != nil {
+		return aranet4.Data{}, false, fmt.Errorf("invalid time-stamp %q: %w", secs[2], err)
+	}
+	s.data.Time = time.Unix(0, ns).UTC()
+
+	return s.finish(), true, nil
+}
+
+// lookup returns the column with the provided key, or nil.
+func lookup(key string) *column {
+	for i := range columns {
+		if columns[i].key == key {
+			return &columns[i]
+		}
+	}
+	return nil
+}
+
+// splitInflux splits s around each occurrence of sep that is neither
+// escaped nor within a double-quoted string.
+func splitInflux(s string, sep byte) []string {
+	var (
+		out   []string
+		beg   int
+		quote bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			quote = !quote
+		case c == sep && !quote:
+			out = append(out, s[beg:i])
+			beg = i + 1
+		}
+	}
+	return append(out, s[beg:])
+}
+
+// unescapeInflux removes the backslash escapes of s.
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var o strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		o.WriteByte(s[i])
+	}
+	return o.String()
+}
+
+var (
+	_ Writer = (*InfluxWriter)(nil)
+	_ Reader = (*InfluxReader)(nil)
+)
@@ -0,0 +1,75 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encoding
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"sbinet.org/x/aranet4"
+)
+
+// NDJSONWriter encodes data samples as newline delimited JSON objects,
+// as returned by aranet4.Data.MarshalJSON.
+type NDJSONWriter struct {
+	w *bufio.Writer
+}
+
+// NewNDJSONWriter returns a NDJSON writer encoding data samples to w.
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{w: bufio.NewWriter(w)}
+}
+
+// Write encodes a data sample.
+func (w *NDJSONWriter) Write(data aranet4.Data) error {
+	p, err := data.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("encoding: could not encode data sample: %w", err)
+	}
+	_, err = w.w.Write(append(p, '\n'))
+	if err != nil {
+		return fmt.Errorf("encoding: could not write data sample: %w", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *NDJSONWriter) Flush() error {
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("encoding: could not flush NDJSON writer: %w", err)
+	}
+	return nil
+}
+
+// NDJSONReader decodes data samples from newline delimited JSON objects.
+type NDJSONReader struct {
+	dec *json.Decoder
+}
+
+// NewNDJSONReader returns a NDJSON reader decoding data samples from r.
+func NewNDJSONReader(r io.Reader) *NDJSONReader {
+	return &NDJSONReader{dec: json.NewDecoder(r)}
+}
+
+// Read decodes the next data sample.
+func (r *NDJSONReader) Read() (aranet4.Data, error) {
+	var data aranet4.Data
+	err := r.dec.Decode(&data)
+	if err != nil {
+		if err == io.EOF {
+			return data, io.EOF
+		}
+		return data, fmt.Errorf("encoding: could not decode data sample: %w", err)
+	}
+	return data, nil
+}
+
+var (
+	_ Writer = (*NDJSONWriter)(nil)
+	_ Reader = (*NDJSONReader)(nil)
+)
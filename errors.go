@@ -0,0 +1,91 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDeviceNotFound indicates that a device could not be found
+	// before the deadline passed, e.g. because it is out of range.
+	// It is usually a transient failure.
+	ErrDeviceNotFound = errors.New("aranet4: device not found")
+
+	// ErrTimeout indicates that a device did not complete an operation
+	// before the deadline passed.
+	// It is usually a transient failure.
+	ErrTimeout = errors.New("aranet4: timeout")
+
+	// ErrNotConnected indicates that the link with a device was lost,
+	// and has not been re-established yet.
+	// It is usually a transient failure.
+	ErrNotConnected = errors.New("aranet4: device not connected")
+
+	// ErrClosed indicates an operation on a closed device or manager.
+	ErrClosed = errors.New("aranet4: device closed")
+)
+
+// ProtocolError describes data sent by a device that does not follow the
+// Aranet protocol.
+type ProtocolError struct {
+	Op   string // operation that failed, e.g. "decode current readings"
+	Data []byte // offending bytes
+	Err  error  // underlying error
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("aranet4: could not %s (data=%x): %v", e.Op, e.Data, e.Err)
+}
+
+func (e *ProtocolError) Unwrap() error { return e.Err }
+
+// protocolError returns a ProtocolError holding a copy of p.
+func protocolError(op string, p []byte, err error) *ProtocolError {
+	return &ProtocolError{
+		Op:   op,
+		Data: append([]byte(nil), p...),
+		Err:  err,
+	}
+}
+
+// CharacteristicError describes a failure to access a GATT characteristic
+// of a device, e.g. a characteristic missing from older firmwares.
+type CharacteristicError struct {
+	Op      string // operation that failed: "discover", "read", "write" or "notify"
+	Service string // UUID of the service of the characteristic
+	UUID    string // UUID of the characteristic
+	Err     error  // underlying error
+}
+
+func (e *CharacteristicError) Error() string {
+	return fmt.Sprintf("aranet4: could not %s characteristic %s: %v", e.Op, e.UUID, e.Err)
+}
+
+func (e *CharacteristicError) Unwrap() error { return e.Err }
+
+// kindError is an error matching one of the sentinel errors of the package,
+// while wrapping the error that caused it.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string        { return e.kind.Error() + ": " + e.err.Error() }
+func (e *kindError) Unwrap() error        { return e.err }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+// ctxError returns the error of the done context ctx.
+// An expired deadline is reported as matching ErrTimeout, in addition to
+// context.DeadlineExceeded.
+func ctxError(ctx context.Context) error {
+	err := ctx.Err()
+	if err == context.DeadlineExceeded {
+		return &kindError{kind: ErrTimeout, err: err}
+	}
+	return err
+}
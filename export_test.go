@@ -0,0 +1,13 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import "time"
+
+// SetSlack sets the delay between a measurement and its pick up by
+// subscriptions, so tests need not wait for too long.
+func SetSlack(dev *Device, d time.Duration) {
+	dev.slack = d
+}
@@ -0,0 +1,344 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FleetOptions configures a Fleet.
+type FleetOptions struct {
+	// Timeout is the maximum duration of one operation on a device,
+	// including the connection to the device.
+	// The default is one minute.
+	Timeout time.Duration
+
+	// Now returns the current time (default: time.Now).
+	// See Device.SetClock for details.
+	Now func() time.Time
+
+	// Policy assesses the air quality of the samples read from the
+	// devices.
+	// The zero Policy selects DefaultPolicy.
+	Policy Policy
+
+	// KeepConnected keeps the connections to the devices open between
+	// operations.
+	// By default, a device is disconnected once its operation completes,
+	// as a Bluetooth adapter can only sustain a few connections at once.
+	KeepConnected bool
+}
+
+// Fleet schedules the operations on a set of devices sharing a single
+// Bluetooth adapter.
+//
+// Operations run one at a time, in the order they were requested, so
+// that every device eventually gets its turn on the adapter.
+// Each operation is bounded by a per-device timeout, so that an
+// unreachable device can not hold the adapter for long.
+type Fleet struct {
+	opts  FleetOptions
+	addrs []string // addresses of the devices, in scheduling order
+
+	mu    sync.Mutex
+	devs  map[string]*fleetDevice
+	busy  bool            // whether an operation is running
+	queue []chan struct{} // operations waiting for the adapter, in order
+	next  int             // index of the device polled first, at the next round
+}
+
+type fleetDevice struct {
+	mgr  *Manager
+	stat DeviceStatus
+	busy bool      // whether an operation on the device is running
+	last time.Time // time-stamp of the last sample delivered by Poll
+}
+
+// DeviceState describes what a device of a fleet is doing.
+type DeviceState uint8
+
+const (
+	StateIdle    DeviceState = iota // no pending operation
+	StateWaiting                    // operations waiting for the adapter
+	StateBusy                       // operation running
+)
+
+func (s DeviceState) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateWaiting:
+		return "waiting"
+	case StateBusy:
+		return "busy"
+	default:
+		return fmt.Sprintf("DeviceState(%d)", uint8(s))
+	}
+}
+
+// DeviceStatus describes the status of a device of a fleet.
+type DeviceStatus struct {
+	Addr    string      // address of the device
+	State   DeviceState // current activity of the device
+	Pending int         // number of operations waiting for the adapter
+	Last    time.Time   // time of the last successful operation
+	Fails   int         // number of consecutive failed operations
+	Err     error       // error of the last operation, if it failed
+}
+
+// NewFleet returns a fleet of the Aranet devices with the provided MAC
+// addresses, using the default Bluetooth adapter.
+func NewFleet(addrs []string, opts FleetOptions) *Fleet {
+	var (
+		order = make([]string, 0, len(addrs))
+		dials = make(map[string]Dialer, len(addrs))
+	)
+	for _, addr := range addrs {
+		if _, dup := dials[addr]; dup {
+			continue
+		}
+		addr := addr
+		order = append(order, addr)
+		dials[addr] = func(ctx context.Context) (Transport, error) {
+			return dialBluetooth(ctx, addr)
+		}
+	}
+	fl := NewFleetWithDialers(dials, opts)
+	// keep the order requested by the user.
+	fl.addrs = order
+	return fl
+}
+
+// NewFleetWithDialers returns a fleet of devices, identified by the keys
+// of dials and reached through the transports established by the
+// corresponding dialer.
+// Devices are scheduled in the order of their identifiers.
+func NewFleetWithDialers(dials map[string]Dialer, opts FleetOptions) *Fleet {
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Minute
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Policy == (Policy{}) {
+		opts.Policy = DefaultPolicy()
+	}
+	fl := &Fleet{
+		opts:  opts,
+		addrs: make([]string, 0, len(dials)),
+		devs:  make(map[string]*fleetDevice, len(dials)),
+	}
+	for addr, dial := range dials {
+		mgr := NewManagerWithDialer(dial)
+		mgr.SetClock(opts.Now)
+		mgr.SetPolicy(opts.Policy)
+		fl.addrs = append(fl.addrs, addr)
+		fl.devs[addr] = &fleetDevice{
+			mgr:  mgr,
+			stat: DeviceStatus{Addr: addr},
+		}
+	}
+	sort.Strings(fl.addrs)
+	return fl
+}
+
+// Devices returns the addresses of the devices of the fleet, in
+// scheduling order.
+func (fl *Fleet) Devices() []string {
+	return append([]string(nil), fl.addrs...)
+}
+
+// Close closes the connections to all the devices of the fleet.
+// Subsequent operations fail.
+func (fl *Fleet) Close() error {
+	var err error
+	for _, addr := range fl.addrs {
+		e := fl.devs[addr].mgr.Close()
+		if e != nil && err == nil {
+			err = fmt.Errorf("could not close device %q: %w", addr, e)
+		}
+	}
+	return err
+}
+
+// Status returns the status of the devices of the fleet, in scheduling
+// order.
+func (fl *Fleet) Status() []DeviceStatus {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	out := make([]DeviceStatus, len(fl.addrs))
+	for i, addr := range fl.addrs {
+		out[i] = fl.devs[addr].stat
+	}
+	return out
+}
+
+// Do runs f with the device with the provided address, once all the
+// operations previously requested on the fleet have completed.
+//
+// f is passed a context bounded by the per-device timeout, which its
+// operations on the device should use.
+// The time spent waiting for the adapter does not count against the
+// per-device timeout.
+func (fl *Fleet) Do(ctx context.Context, addr string, f func(ctx context.Context, dev *Device) error) error {
+	d, ok := fl.devs[addr]
+	if !ok {
+		return fmt.Errorf("unknown device %q", addr)
+	}
+
+	fl.mu.Lock()
+	d.stat.Pending++
+	d.stat.State = d.state()
+	fl.mu.Unlock()
+
+	err := fl.acquire(ctx)
+
+	fl.mu.Lock()
+	d.stat.Pending--
+	d.busy = err == nil
+	d.stat.State = d.state()
+	fl.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	defer fl.release()
+
+	tctx, cancel := context.WithTimeout(ctx, fl.opts.Timeout)
+	defer cancel()
+
+	err = d.mgr.Do(tctx, func(dev *Device) error {
+		return f(tctx, dev)
+	})
+	if !fl.opts.KeepConnected {
+		_ = d.mgr.Disconnect()
+	}
+	if err != nil {
+		err = fmt.Errorf("could not run operation on device %q: %w", addr, err)
+	}
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	d.busy = false
+	d.stat.State = d.state()
+	d.stat.Err = err
+	switch err {
+	case nil:
+		d.stat.Last = fl.opts.Now().UTC()
+		d.stat.Fails = 0
+	default:
+		d.stat.Fails++
+	}
+	return err
+}
+
+// state returns the current state of the device.
+func (d *fleetDevice) state() DeviceState {
+	switch {
+	case d.busy:
+		return StateBusy
+	case d.stat.Pending > 0:
+		return StateWaiting
+	default:
+		return StateIdle
+	}
+}
+
+// acquire waits for the adapter to be available, in order of arrival.
+func (fl *Fleet) acquire(ctx context.Context) error {
+	fl.mu.Lock()
+	if !fl.busy && len(fl.queue) == 0 {
+		fl.busy = true
+		fl.mu.Unlock()
+		return nil
+	}
+	c := make(chan struct{})
+	fl.queue = append(fl.queue, c)
+	fl.mu.Unlock()
+
+	select {
+	case <-c:
+		return nil
+	case <-ctx.Done():
+		fl.mu.Lock()
+		for i, w := range fl.queue {
+			if w == c {
+				fl.queue = append(fl.queue[:i], fl.queue[i+1:]...)
+				fl.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		fl.mu.Unlock()
+		// the adapter was handed over to us in the meantime: pass it on.
+		fl.release()
+		return ctx.Err()
+	}
+}
+
+// release hands the adapter over to the next waiting operation, if any.
+func (fl *Fleet) release() {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if len(fl.queue) == 0 {
+		fl.busy = false
+		return
+	}
+	c := fl.queue[0]
+	fl.queue = fl.queue[1:]
+	close(c)
+}
+
+// Poll downloads, in turn, the samples each device of the fleet has
+// measured since the previous poll (or its whole history, on the first
+// poll), and passes them to f.
+//
+// A device that could not be reached does not prevent the others from
+// being polled: its error is reported by Status, and its samples are
+// downloaded at the next poll.
+// Poll only returns an error when the context is done.
+func (fl *Fleet) Poll(ctx context.Context, f func(addr string, data []Data)) error {
+	fl.mu.Lock()
+	beg := fl.next
+	fl.mu.Unlock()
+
+	for i := range fl.addrs {
+		j := (beg + i) % len(fl.addrs)
+		addr := fl.addrs[j]
+		d := fl.devs[addr]
+
+		fl.mu.Lock()
+		last := d.last
+		fl.mu.Unlock()
+
+		var rows []Data
+		err := fl.Do(ctx, addr, func(ctx context.Context, dev *Device) (err error) {
+			rows, err = dev.ReadAfterContext(ctx, last)
+			return err
+		})
+		if err := ctx.Err(); err != nil {
+			// start the next round with the device that missed its turn.
+			fl.mu.Lock()
+			fl.next = j
+			fl.mu.Unlock()
+			return err
+		}
+		if err != nil || len(rows) == 0 {
+			continue
+		}
+
+		fl.mu.Lock()
+		d.last = rows[len(rows)-1].Time
+		fl.mu.Unlock()
+
+		f(addr, rows)
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/emu"
+)
+
+func newTestFleet(t *testing.T, n int, opts aranet4.FleetOptions) (*aranet4.Fleet, []*emu.Sensor) {
+	t.Helper()
+
+	var (
+		sensors = make([]*emu.Sensor, n)
+		dials   = make(map[string]aranet4.Dialer, n+1)
+	)
+	for i := range sensors {
+		sensor := emu.New(emu.Config{Interval: time.Second})
+		sensors[i] = sensor
+		dials[string(rune('a'+i))] = func(context.Context) (aranet4.Transport, error) {
+			return sensor.Connect(), nil
+		}
+	}
+	// an unreachable device.
+	dials["z"] = func(ctx context.Context) (aranet4.Transport, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	fl := aranet4.NewFleetWithDialers(dials, opts)
+	t.Cleanup(func() { fl.Close() })
+	return fl, sensors
+}
+
+func TestFleetDo(t *testing.T) {
+	fl, _ := newTestFleet(t, 3, aranet4.FleetOptions{Timeout: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if got, want := len(fl.Devices()), 4; got != want {
+		t.Fatalf("invalid number of devices: got=%d, want=%d", got, want)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		busy  int
+		peak  int
+		order []string
+	)
+	for i := 0; i < 3; i++ {
+		for _, addr := range []string{"a", "b", "c"} {
+			addr := addr
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := fl.Do(ctx, addr, func(ctx context.Context, dev *aranet4.Device) error {
+					mu.Lock()
+					busy++
+					if busy > peak {
+						peak = busy
+					}
+					order = append(order, addr)
+					mu.Unlock()
+					defer func() {
+						mu.Lock()
+						busy--
+						mu.Unlock()
+					}()
+
+					_, err := dev.ReadContext(ctx)
+					return err
+				})
+				if err != nil {
+					t.Errorf("could not read device %q: %+v", addr, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if got, want := peak, 1; got != want {
+		t.Fatalf("invalid number of concurrent operations: got=%d, want=%d", got, want)
+	}
+	if got, want := len(order), 9; got != want {
+		t.Fatalf("invalid number of operations: got=%d, want=%d", got, want)
+	}
+
+	start := time.Now()
+	err := fl.Do(ctx, "z", func(context.Context, *aranet4.Device) error { return nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, context.DeadlineExceeded)
+	}
+	if dt := time.Since(start); dt > 5*time.Second {
+		t.Fatalf("per-device timeout not applied: %v", dt)
+	}
+
+	err = fl.Do(ctx, "unknown", func(context.Context, *aranet4.Device) error { return nil })
+	if err == nil {
+		t.Fatalf("expected an error for an unknown device")
+	}
+
+	for _, st := range fl.Status() {
+		if got, want := st.State, aranet4.StateIdle; got != want {
+			t.Fatalf("invalid state for %q: got=%v, want=%v", st.Addr, got, want)
+		}
+		switch st.Addr {
+		case "z":
+			if st.Err == nil || st.Fails != 1 || !st.Last.IsZero() {
+				t.Fatalf("invalid status for %q: %+v", st.Addr, st)
+			}
+		default:
+			if st.Err != nil || st.Fails != 0 || st.Last.IsZero() {
+				t.Fatalf("invalid status for %q: %+v", st.Addr, st)
+			}
+		}
+	}
+}
+
+func TestFleetFIFO(t *testing.T) {
+	fl, _ := newTestFleet(t, 2, aranet4.FleetOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		hold    = make(chan struct{})
+		started = make(chan struct{})
+		mu      sync.Mutex
+		order   []int
+		wg      sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = fl.Do(ctx, "a", func(context.Context, *aranet4.Device) error {
+			close(started)
+			<-hold
+			return nil
+		})
+	}()
+	<-started
+
+	for i := 0; i < 5; i++ {
+		i := i
+		addr := []string{"a", "b"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = fl.Do(ctx, addr, func(context.Context, *aranet4.Device) error {
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+				return nil
+			})
+		}()
+		// wait for the operation to be queued.
+		for {
+			st := fl.Status()
+			n := st[0].Pending + st[1].Pending
+			if n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	st := fl.Status()
+	close(hold)
+	wg.Wait()
+
+	if got, want := st[0].State, aranet4.StateBusy; got != want {
+		t.Fatalf("invalid state: got=%v, want=%v", got, want)
+	}
+	if got, want := st[1].State, aranet4.StateWaiting; got != want {
+		t.Fatalf("invalid state: got=%v, want=%v", got, want)
+	}
+
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("operations not run in order: %v", order)
+		}
+	}
+}
+
+func TestFleetPoll(t *testing.T) {
+	fl, sensors := newTestFleet(t, 2, aranet4.FleetOptions{Timeout: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	got := make(map[string][]aranet4.Data)
+	poll := func() {
+		t.Helper()
+		err := fl.Poll(ctx, func(addr string, data []aranet4.Data) {
+			got[addr] = append(got[addr], data...)
+		})
+		if err != nil {
+			t.Fatalf("could not poll fleet: %+v", err)
+		}
+	}
+
+	poll()
+	for i, addr := range []string{"a", "b"} {
+		if got, want := len(got[addr]), len(sensors[i].Samples()); got != want {
+			t.Fatalf("invalid number of samples for %q: got=%d, want=%d", addr, got, want)
+		}
+	}
+	if _, ok := got["z"]; ok {
+		t.Fatalf("unexpected samples from unreachable device")
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+	poll()
+	for _, addr := range []string{"a", "b"} {
+		vs := got[addr]
+		for i := 1; i < len(vs); i++ {
+			if !vs[i].Time.After(vs[i-1].Time) {
+				t.Fatalf("sample %d of %q delivered twice: %v, %v", i, addr, vs[i-1].Time, vs[i].Time)
+			}
+		}
+	}
+	for i, addr := range []string{"a", "b"} {
+		want := sensors[i].Samples()
+		if got, want := got[addr][len(got[addr])-1].CO2, want[len(want)-1].CO2; got != want {
+			t.Fatalf("invalid last sample for %q: got=%d, want=%d", addr, got, want)
+		}
+	}
+
+	st := fl.Status()
+	if got, want := st[len(st)-1].Fails, 2; got != want {
+		t.Fatalf("invalid number of failures: got=%d, want=%d", got, want)
+	}
+}
+
+// hungTransport is the link with a device that accepted the connection
+// but stopped answering.
+type hungTransport struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func (tr *hungTransport) DiscoverService(uuid string) (aranet4.Service, error) {
+	<-tr.done
+	return nil, errors.New("transport closed")
+}
+
+func (tr *hungTransport) Close() error {
+	tr.once.Do(func() { close(tr.done) })
+	return nil
+}
+
+func TestFleetTimeout(t *testing.T) {
+	sensor := emu.New(emu.Config{Interval: time.Second})
+	fl := aranet4.NewFleetWithDialers(map[string]aranet4.Dialer{
+		"a": func(context.Context) (aranet4.Transport, error) {
+			return &hungTransport{done: make(chan struct{})}, nil
+		},
+		"b": func(context.Context) (aranet4.Transport, error) {
+			return sensor.Connect(), nil
+		},
+	}, aranet4.FleetOptions{Timeout: 200 * time.Millisecond})
+	defer fl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := fl.Do(ctx, "a", func(ctx context.Context, dev *aranet4.Device) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("operation not bounded by the per-device timeout")
+		}
+		_, err := dev.ReadContext(ctx)
+		return err
+	})
+	if !errors.Is(err, aranet4.ErrTimeout) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, aranet4.ErrTimeout)
+	}
+
+	got := make(map[string][]aranet4.Data)
+	start := time.Now()
+	err = fl.Poll(ctx, func(addr string, data []aranet4.Data) {
+		got[addr] = append(got[addr], data...)
+	})
+	if err != nil {
+		t.Fatalf("could not poll fleet: %+v", err)
+	}
+	if dt := time.Since(start); dt > 5*time.Second {
+		t.Fatalf("hung device held the adapter for %v", dt)
+	}
+	if got, want := len(got["b"]), len(sensor.Samples()); got != want {
+		t.Fatalf("invalid number of samples: got=%d, want=%d", got, want)
+	}
+
+	st := fl.Status()
+	if got, want := st[0].Fails, 2; got != want {
+		t.Fatalf("invalid number of failures: got=%d, want=%d", got, want)
+	}
+	if !errors.Is(st[0].Err, aranet4.ErrTimeout) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", st[0].Err, aranet4.ErrTimeout)
+	}
+}
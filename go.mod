@@ -3,10 +3,13 @@ module github.com/saranrapjs/aranet4-tinygo
 go 1.17
 
 require (
+	github.com/godbus/dbus/v5 v5.0.6
 	github.com/muka/go-bluetooth v0.0.0-20211227071625-1c7f8793aa7e
 	go-hep.org/x/hep v0.29.2
 	go.etcd.io/bbolt v1.3.6
+	gonum.org/v1/gonum v0.9.3
 	gonum.org/v1/plot v0.10.0
+	tinygo.org/x/bluetooth v0.5.0
 )
 
 require (
@@ -17,7 +20,6 @@ require (
 	github.com/go-fonts/liberation v0.2.0 // indirect
 	github.com/go-latex/latex v0.0.0-20210823091927-c0d11ff05a81 // indirect
 	github.com/go-pdf/fpdf v0.5.0 // indirect
-	github.com/godbus/dbus/v5 v5.0.6 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/gonuts/binary v0.2.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
@@ -27,7 +29,5 @@ require (
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/sys v0.0.0-20220114195835-da31bd327af9 // indirect
 	golang.org/x/text v0.3.7 // indirect
-	gonum.org/v1/gonum v0.9.3 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
-	tinygo.org/x/bluetooth v0.5.0 // indirect
 )
@@ -0,0 +1,121 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DeviceInfo describes an Aranet device, as reported by its Device
+// Information, Generic Access and Battery services.
+type DeviceInfo struct {
+	Name         string // device name, as advertised
+	Manufacturer string
+	Model        string
+	Serial       string // serial number
+	Hardware     string // hardware revision
+	Software     string // software revision
+	Battery      int    // battery level, in percents
+}
+
+func (info DeviceInfo) String() string {
+	var o strings.Builder
+	fmt.Fprintf(&o, "name:         %s\n", info.Name)
+	fmt.Fprintf(&o, "manufacturer: %s\n", info.Manufacturer)
+	fmt.Fprintf(&o, "model:        %s\n", info.Model)
+	fmt.Fprintf(&o, "serial:       %s\n", info.Serial)
+	fmt.Fprintf(&o, "hardware:     %s\n", info.Hardware)
+	fmt.Fprintf(&o, "software:     %s\n", info.Software)
+	fmt.Fprintf(&o, "battery:      %d%%\n", info.Battery)
+	return o.String()
+}
+
+// Info returns the identification and battery level of the device.
+func (dev *Device) Info() (DeviceInfo, error) {
+	return dev.InfoContext(context.Background())
+}
+
+// InfoContext is like Info, but aborts the operation when the context
+// is done.
+func (dev *Device) InfoContext(ctx context.Context) (DeviceInfo, error) {
+	var v DeviceInfo
+	err := dev.do(ctx, func() (err error) {
+		v, err = dev.info()
+		return err
+	})
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	return v, nil
+}
+
+func (dev *Device) info() (DeviceInfo, error) {
+	var (
+		info DeviceInfo
+		err  error
+	)
+
+	for _, v := range []struct {
+		svc  string
+		uuid string
+		ptr  *string
+	}{
+		{uuidGenericService, uuidGenericReadDeviceName, &info.Name},
+		{uuidCommonService, uuidCommonReadManufacturerName, &info.Manufacturer},
+		{uuidCommonService, uuidCommonReadModelNumber, &info.Model},
+		{uuidCommonService, uuidCommonReadSerialNumber, &info.Serial},
+		{uuidCommonService, uuidCommonReadHWRevision, &info.Hardware},
+		{uuidCommonService, uuidCommonReadSWRevision, &info.Software},
+	} {
+		*v.ptr, err = dev.readString(v.svc, v.uuid)
+		if err != nil {
+			return info, err
+		}
+	}
+
+	info.Battery, err = dev.battery()
+	if err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
+
+// battery returns the battery level of the device.
+// The Battery service is tried first, then the Device Information
+// service.
+func (dev *Device) battery() (int, error) {
+	var err error
+	for _, svc := range []string{uuidBatteryService, uuidCommonService} {
+		var raw []byte
+		raw, err = dev.readValue(svc, uuidCommonReadBattery)
+		var cerr *CharacteristicError
+		if errors.As(err, &cerr) && cerr.Op == "discover" {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if len(raw) == 0 {
+			return 0, protocolError("decode battery level", raw, io.ErrUnexpectedEOF)
+		}
+		return int(raw[0]), nil
+	}
+	return 0, err
+}
+
+// readString reads the characteristic uuid of the service svc as a
+// string, without its trailing NULs.
+func (dev *Device) readString(svc, uuid string) (string, error) {
+	raw, err := dev.readValue(svc, uuid)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(raw), "\x00"), nil
+}
@@ -0,0 +1,55 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"testing"
+)
+
+func TestDeviceInfo(t *testing.T) {
+	pad := func(s string) []byte {
+		p := make([]byte, 32)
+		copy(p, s)
+		return p
+	}
+
+	tr := newMemTransport()
+	tr.add(uuidGenericService, uuidGenericReadDeviceName).value = []byte("Aranet4 0A1B2")
+	tr.add(uuidCommonService, uuidCommonReadManufacturerName).value = pad("SAF Tehnika")
+	tr.add(uuidCommonService, uuidCommonReadModelNumber).value = []byte("Aranet4")
+	tr.add(uuidCommonService, uuidCommonReadSerialNumber).value = []byte("1234567890")
+	tr.add(uuidCommonService, uuidCommonReadHWRevision).value = []byte("12")
+	tr.add(uuidCommonService, uuidCommonReadSWRevision).value = pad("v1.2.0")
+	tr.add(uuidCommonService, uuidCommonReadBattery).value = []byte{87}
+
+	dev := NewWithTransport(tr)
+	defer dev.Close()
+
+	info, err := dev.Info()
+	if err != nil {
+		t.Fatalf("could not read device info: %+v", err)
+	}
+
+	want := DeviceInfo{
+		Name:         "Aranet4 0A1B2",
+		Manufacturer: "SAF Tehnika",
+		Model:        "Aranet4",
+		Serial:       "1234567890",
+		Hardware:     "12",
+		Software:     "v1.2.0",
+		Battery:      87,
+	}
+	if got := info; got != want {
+		t.Fatalf("invalid device info:\ngot= %#v\nwant=%#v", got, want)
+	}
+
+	vers, err := dev.Version()
+	if err != nil {
+		t.Fatalf("could not read version: %+v", err)
+	}
+	if got, want := vers, "v1.2.0"; got != want {
+		t.Fatalf("invalid version: got=%q, want=%q", got, want)
+	}
+}
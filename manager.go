@@ -0,0 +1,336 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Manager owns a persistent connection to a device.
+//
+// The connection is established on first use, and re-established with
+// an exponential backoff when the link with the device is lost.
+// The discovered service and characteristics of the device are kept
+// for as long as the connection lives.
+//
+// Concurrent callers are serialized onto the single link with the device.
+type Manager struct {
+	dial Dialer
+
+	link   chan struct{} // 1-slot semaphore serializing callers onto the link
+	dev    *Device
+	fails  int // number of consecutive failed connection attempts
+	closed bool
+
+	quit chan struct{} // closed by Close, to abort the on-going operation
+	stop sync.Once
+
+	clk    sync.Mutex       // guards now, grid and policy
+	now    func() time.Time // clock of the host
+	grid   time.Time        // measurement grid of the device, across connections
+	policy Policy           // assessment of the air quality of the samples
+
+	slack time.Duration // delay between a measurement and its pick up by subscriptions
+
+	sub    sync.Mutex // guards subErr
+	subErr error      // error that ended the last subscription
+}
+
+// NewManager returns a manager for the Aranet device with the provided
+// MAC address, using the default Bluetooth adapter.
+func NewManager(addr string) *Manager {
+	return NewManagerWithDialer(func(ctx context.Context) (Transport, error) {
+		return dialBluetooth(ctx, addr)
+	})
+}
+
+// NewManagerWithDialer returns a manager for the device reached through
+// the transports established by dial.
+func NewManagerWithDialer(dial Dialer) *Manager {
+	return &Manager{
+		dial:   dial,
+		link:   make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+		slack:  time.Second,
+		now:    time.Now,
+		policy: DefaultPolicy(),
+	}
+}
+
+// SetClock sets the function returning the current time, for the
+// devices connected by the manager.
+// A nil function resets the clock to time.Now.
+//
+// See Device.SetClock for details.
+func (m *Manager) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	m.clk.Lock()
+	m.now = now
+	dev := m.dev
+	m.clk.Unlock()
+	if dev != nil {
+		dev.SetClock(now)
+	}
+}
+
+// SetPolicy sets the policy assessing the air quality of the samples
+// read from the device.
+//
+// See Device.SetPolicy for details.
+func (m *Manager) SetPolicy(p Policy) {
+	m.clk.Lock()
+	m.policy = p
+	dev := m.dev
+	m.clk.Unlock()
+	if dev != nil {
+		dev.SetPolicy(p)
+	}
+}
+
+func (m *Manager) clock() time.Time {
+	m.clk.Lock()
+	now := m.now
+	m.clk.Unlock()
+	return now().UTC()
+}
+
+// Close closes the connection to the device, if any.
+// The on-going call to Do, if any, is aborted, and subsequent calls to Do
+// fail.
+func (m *Manager) Close() error {
+	m.stop.Do(func() { close(m.quit) })
+
+	// abort the on-going operation, so it releases the link.
+	m.clk.Lock()
+	dev := m.dev
+	m.clk.Unlock()
+	if dev != nil {
+		_ = dev.Close()
+	}
+
+	m.link <- struct{}{}
+	defer m.unlock()
+
+	m.closed = true
+	return m.drop()
+}
+
+// Disconnect closes the connection to the device, if any.
+// The connection is re-established by the next call to Do.
+func (m *Manager) Disconnect() error {
+	m.link <- struct{}{}
+	defer m.unlock()
+	return m.drop()
+}
+
+func (m *Manager) drop() error {
+	if m.dev == nil {
+		return nil
+	}
+	dev := m.dev
+	dev.mu.Lock()
+	grid := dev.grid
+	dev.mu.Unlock()
+
+	m.clk.Lock()
+	m.dev = nil
+	m.grid = grid
+	m.clk.Unlock()
+	return dev.Close()
+}
+
+// Do runs f with the connected device, once every other call to Do has
+// completed.
+// Do fails with an error matching ErrTimeout if the deadline of the
+// context expires while waiting for the other calls.
+//
+// If f fails because the link with the device was lost, the connection
+// is re-established and f is run one more time.
+// Do returns ErrClosed if the manager is closed in the meantime.
+func (m *Manager) Do(ctx context.Context, f func(dev *Device) error) error {
+	ctx, cancel := m.bind(ctx)
+	defer cancel()
+
+	err := m.lock(ctx)
+	if err != nil {
+		if m.closing() {
+			return ErrClosed
+		}
+		return err
+	}
+	defer m.unlock()
+
+	err = m.do(ctx, f)
+	if err != nil && m.closing() {
+		return ErrClosed
+	}
+	return err
+}
+
+func (m *Manager) do(ctx context.Context, f func(dev *Device) error) error {
+	var err error
+	for i := 0; i < 2; i++ {
+		var dev *Device
+		dev, err = m.connect(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = f(dev)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() == nil && m.alive(dev) {
+			// the device is still reachable: not a link loss.
+			return err
+		}
+		_ = m.drop()
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// lock waits until the link is available, or the context is done.
+func (m *Manager) lock(ctx context.Context) error {
+	select {
+	case m.link <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctxError(ctx)
+	}
+}
+
+func (m *Manager) unlock() { <-m.link }
+
+// bind returns a context that is also cancelled when the manager is
+// closed.
+func (m *Manager) bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-m.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// closing returns whether Close was called.
+func (m *Manager) closing() bool {
+	select {
+	case <-m.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// alive returns whether the link with the device is still up.
+func (m *Manager) alive(dev *Device) bool {
+	_, err := dev.since()
+	return err == nil
+}
+
+// connect returns the connected device, establishing the connection if
+// needed.
+// connect must be called with the link held, and with a context bound to the
+// manager, so that Close aborts the connection attempts.
+func (m *Manager) connect(ctx context.Context) (*Device, error) {
+	if m.closed || m.closing() {
+		return nil, ErrClosed
+	}
+	if m.dev != nil {
+		return m.dev, nil
+	}
+
+	for {
+		if m.fails > 0 {
+			tmr := time.NewTimer(backoff(m.fails))
+			select {
+			case <-ctx.Done():
+				tmr.Stop()
+				return nil, ctxError(ctx)
+			case <-tmr.C:
+			}
+		}
+
+		dev, err := NewWithDialer(ctx, m.dial)
+		if err == nil {
+			m.clk.Lock()
+			// keep time-stamps on the same grid across connections.
+			dev.now = m.now
+			dev.grid = m.grid
+			dev.policy = m.policy
+			m.dev = dev
+			m.clk.Unlock()
+			m.fails = 0
+			return dev, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		m.fails++
+	}
+}
+
+// Subscribe streams the measurements of the device, as they are made.
+// The reads of the subscription are serialized with the other calls to
+// Do.
+//
+// See Device.Subscribe for details; Err reports the error that ended
+// the subscription.
+func (m *Manager) Subscribe(ctx context.Context) (<-chan Data, error) {
+	m.link <- struct{}{}
+	closed := m.closed
+	slack := m.slack
+	m.unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	m.sub.Lock()
+	m.subErr = nil
+	m.sub.Unlock()
+
+	sub := subscription{
+		read: func(ctx context.Context) (Data, error) {
+			var v Data
+			err := m.Do(ctx, func(dev *Device) (err error) {
+				v, err = dev.ReadContext(ctx)
+				return err
+			})
+			return v, err
+		},
+		// the connection is re-established by Do.
+		reconnect: func(context.Context) error { return nil },
+		now:       m.clock,
+		slack:     slack,
+	}
+
+	ch := make(chan Data)
+	go func() {
+		defer close(ch)
+		err := sub.run(ctx, ch)
+		m.sub.Lock()
+		m.subErr = err
+		m.sub.Unlock()
+	}()
+	return ch, nil
+}
+
+// Err returns the error that ended the last subscription, once its channel
+// has been closed.
+func (m *Manager) Err() error {
+	m.sub.Lock()
+	defer m.sub.Unlock()
+	return m.subErr
+}
@@ -0,0 +1,343 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/emu"
+)
+
+func TestManager(t *testing.T) {
+	sensor := emu.New(emu.Config{})
+
+	var (
+		mu    sync.Mutex
+		dials int // number of connections established
+		busy  int // number of callers running
+		peak  int // maximum number of callers running concurrently
+	)
+	mgr := aranet4.NewManagerWithDialer(func(context.Context) (aranet4.Transport, error) {
+		mu.Lock()
+		dials++
+		mu.Unlock()
+		return sensor.Connect(), nil
+	})
+	defer mgr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, 10)
+		devs = make(chan *aranet4.Device, 10)
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- mgr.Do(ctx, func(dev *aranet4.Device) error {
+				mu.Lock()
+				busy++
+				if busy > peak {
+					peak = busy
+				}
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					busy--
+					mu.Unlock()
+				}()
+
+				devs <- dev
+				_, err := dev.ReadContext(ctx)
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	close(devs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("could not read measurement: %+v", err)
+		}
+	}
+	if got, want := peak, 1; got != want {
+		t.Fatalf("invalid number of concurrent callers: got=%d, want=%d", got, want)
+	}
+	first := <-devs
+	for dev := range devs {
+		if dev != first {
+			t.Fatalf("connection not reused")
+		}
+	}
+	if got, want := dials, 1; got != want {
+		t.Fatalf("invalid number of dials: got=%d, want=%d", got, want)
+	}
+
+	// not a link loss: no reconnection.
+	err := mgr.Do(ctx, func(dev *aranet4.Device) error {
+		return dev.SetIntervalContext(ctx, 3*time.Minute)
+	})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := dials, 1; got != want {
+		t.Fatalf("invalid number of dials: got=%d, want=%d", got, want)
+	}
+
+	sensor.Disconnect() // link loss.
+
+	err = mgr.Do(ctx, func(dev *aranet4.Device) error {
+		_, err := dev.ReadContext(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("could not read measurement after link loss: %+v", err)
+	}
+	if got, want := dials, 2; got != want {
+		t.Fatalf("invalid number of dials: got=%d, want=%d", got, want)
+	}
+
+	err = mgr.Close()
+	if err != nil {
+		t.Fatalf("could not close manager: %+v", err)
+	}
+
+	err = mgr.Do(ctx, func(dev *aranet4.Device) error { return nil })
+	if err == nil {
+		t.Fatalf("expected an error after close")
+	}
+}
+
+func TestManagerSubscribe(t *testing.T) {
+	sensor := emu.New(emu.Config{Interval: time.Second})
+	mgr := aranet4.NewManagerWithDialer(func(context.Context) (aranet4.Transport, error) {
+		return sensor.Connect(), nil
+	})
+	defer mgr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ch, err := mgr.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("could not subscribe: %+v", err)
+	}
+
+	n := 0
+	for range ch {
+		n++
+		switch n {
+		case 1:
+			sensor.Disconnect() // link loss.
+		case 2:
+			cancel()
+		}
+	}
+	if got, want := n, 2; got < want {
+		t.Fatalf("invalid number of measurements: got=%d, want=%d", got, want)
+	}
+}
+
+func TestManagerClock(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		t0  = time.Date(2022, 1, 20, 15, 50, 0, 0, time.UTC)
+		now = t0.Add(30 * time.Second)
+	)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	setClock := func(t time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = t
+	}
+
+	sensor := emu.New(emu.Config{
+		Interval: time.Minute,
+		Capacity: 10,
+		Start:    t0.Add(-9 * time.Minute),
+		Now:      clock,
+	})
+	mgr := aranet4.NewManagerWithDialer(func(context.Context) (aranet4.Transport, error) {
+		return sensor.Connect(), nil
+	})
+	defer mgr.Close()
+	mgr.SetClock(clock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	readAll := func() []aranet4.Data {
+		t.Helper()
+		var vs []aranet4.Data
+		err := mgr.Do(ctx, func(dev *aranet4.Device) (err error) {
+			vs, err = dev.ReadAllContext(ctx)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("could not read history: %+v", err)
+		}
+		return vs
+	}
+
+	want := sensor.Samples()
+	got := readAll()
+	if len(got) != len(want) {
+		t.Fatalf("invalid number of samples: got=%d, want=%d", len(got), len(want))
+	}
+	for i := range got {
+		if got, want := got[i].Time, want[i].Time; !got.Equal(want) {
+			t.Fatalf("invalid time-stamp for sample %d: got=%v, want=%v", i, got, want)
+		}
+	}
+
+	// the device reports elapsed times with a one second resolution:
+	// time-stamps stay on the same grid, across connections.
+	_ = mgr.Disconnect()
+	setClock(t0.Add(30*time.Second + 700*time.Millisecond))
+
+	got = readAll()
+	for i := range got {
+		if got, want := got[i].Time, want[i].Time; !got.Equal(want) {
+			t.Fatalf("invalid time-stamp for sample %d: got=%v, want=%v", i, got, want)
+		}
+	}
+}
+
+func TestManagerCloseConnecting(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		dial aranet4.Dialer
+	}{
+		{
+			// the device is out of range: connections fail and are retried
+			// with a backoff.
+			name: "backoff",
+			dial: func(context.Context) (aranet4.Transport, error) {
+				return nil, errors.New("out of range")
+			},
+		},
+		{
+			// the connection never completes.
+			name: "dial",
+			dial: func(ctx context.Context) (aranet4.Transport, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				dials int
+			)
+			mgr := aranet4.NewManagerWithDialer(func(ctx context.Context) (aranet4.Transport, error) {
+				mu.Lock()
+				dials++
+				mu.Unlock()
+				return tc.dial(ctx)
+			})
+
+			errc := make(chan error, 1)
+			go func() {
+				errc <- mgr.Do(context.Background(), func(dev *aranet4.Device) error {
+					return nil
+				})
+			}()
+			// wait for the connection attempts to start.
+			for {
+				mu.Lock()
+				n := dials
+				mu.Unlock()
+				if n > 0 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			done := make(chan error, 1)
+			go func() { done <- mgr.Close() }()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("could not close manager: %+v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("close blocked by connection attempts")
+			}
+
+			if err := <-errc; !errors.Is(err, aranet4.ErrClosed) {
+				t.Fatalf("invalid error: got=%+v, want=%+v", err, aranet4.ErrClosed)
+			}
+		})
+	}
+}
+
+func TestManagerDoTimeout(t *testing.T) {
+	sensor := emu.New(emu.Config{})
+	mgr := aranet4.NewManagerWithDialer(func(context.Context) (aranet4.Transport, error) {
+		return sensor.Connect(), nil
+	})
+	defer mgr.Close()
+
+	var (
+		busy = make(chan struct{})
+		done = make(chan struct{})
+		errc = make(chan error, 1)
+	)
+	go func() {
+		errc <- mgr.Do(context.Background(), func(dev *aranet4.Device) error {
+			close(busy)
+			<-done
+			return nil
+		})
+	}()
+	<-busy
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	res := make(chan error, 1)
+	go func() {
+		res <- mgr.Do(ctx, func(dev *aranet4.Device) error {
+			t.Errorf("operation run while the link is held")
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-res:
+		if !errors.Is(err, aranet4.ErrTimeout) {
+			t.Fatalf("invalid error: got=%+v, want=%+v", err, aranet4.ErrTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("call blocked past its deadline")
+	}
+
+	close(done)
+	if err := <-errc; err != nil {
+		t.Fatalf("could not run first operation: %+v", err)
+	}
+
+	err := mgr.Do(context.Background(), func(dev *aranet4.Device) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not run operation once the link is released: %+v", err)
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Quantity describes a measured quantity of a data sample, as encoded
+// by the machine-readable encodings.
+type Quantity struct {
+	Field Field  // field of the quantity
+	Key   string // key of the quantity, carrying its unit, e.g. "co2_ppm"
+	Int   bool   // whether the quantity takes integer values
+
+	get func(data *Data) float64
+	set func(data *Data, v float64)
+}
+
+// Value returns the value of the quantity in the provided sample.
+// Value fails if the value is not finite, as it could not be encoded.
+func (q Quantity) Value(data Data) (float64, error) {
+	return q.value(&data)
+}
+
+// SetValue sets the value of the quantity in the provided sample.
+func (q Quantity) SetValue(data *Data, v float64) {
+	q.set(data, v)
+}
+
+// Quantities returns the measured quantities of data samples, in the
+// order of the machine-readable encodings.
+func Quantities() []Quantity {
+	return append([]Quantity(nil), quantities...)
+}
+
+var quantities = []Quantity{
+	{
+		FieldCO2, "co2_ppm", true,
+		func(data *Data) float64 { return float64(data.CO2) },
+		func(data *Data, v float64) { data.CO2 = int(v) },
+	},
+	{
+		FieldRadon, "radon_bqm3", true,
+		func(data *Data) float64 { return float64(data.Radon) },
+		func(data *Data, v float64) { data.Radon = int(v) },
+	},
+	{
+		FieldDoseRate, "dose_rate_usvh", false,
+		func(data *Data) float64 { return data.DoseRate },
+		func(data *Data, v float64) { data.DoseRate = v },
+	},
+	{
+		FieldDose, "dose_msv", false,
+		func(data *Data) float64 { return data.Dose },
+		func(data *Data, v float64) { data.Dose = v },
+	},
+	{
+		FieldT, "temperature_c", false,
+		func(data *Data) float64 { return data.T },
+		func(data *Data, v float64) { data.T = v },
+	},
+	{
+		FieldP, "pressure_hpa", false,
+		func(data *Data) float64 { return data.P },
+		func(data *Data, v float64) { data.P = v },
+	},
+	{
+		FieldH, "humidity_pct", false,
+		func(data *Data) float64 { return data.H },
+		func(data *Data, v float64) { data.H = v },
+	},
+}
+
+// value returns the value of the quantity in the provided sample, if it
+// can be encoded.
+func (q Quantity) value(data *Data) (float64, error) {
+	v := q.get(data)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("aranet4: invalid %s value %v", q.Key, v)
+	}
+	return v, nil
+}
+
+// hasQuality returns whether samples of the model carry an air quality
+// assessment.
+func (m Model) hasQuality() bool {
+	return m.Fields()&(FieldCO2|FieldRadon) != 0
+}
+
+// MarshalJSON encodes the sample as a JSON object.
+//
+// Keys carry the unit of their value, e.g. "co2_ppm" or "temperature_c".
+// Only the quantities measured by the model of the device are encoded,
+// and missing ones are encoded as null.
+// The time-stamp is encoded with RFC 3339, and the measurement interval
+// in seconds.
+// An unknown battery level (e.g. for samples from the history) is
+// encoded as null.
+func (data Data) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	buf.WriteString(strconv.Quote(data.Time.Format(time.RFC3339Nano)))
+	buf.WriteString(`,"model":`)
+	buf.WriteString(strconv.Quote(data.Model.String()))
+
+	fields := data.Model.Fields()
+	for _, q := range quantities {
+		if fields&q.Field == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, ",%q:", q.Key)
+		if !data.Valid(q.Field) {
+			buf.WriteString("null")
+			continue
+		}
+		v, err := q.value(&data)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+
+	if data.Model.hasQuality() {
+		buf.WriteString(`,"quality":`)
+		switch data.Quality {
+		case 0:
+			buf.WriteString("null")
+		default:
+			buf.WriteString(strconv.Quote(data.Quality.String()))
+		}
+	}
+	buf.WriteString(`,"battery_pct":`)
+	switch {
+	case data.Battery < 0:
+		buf.WriteString("null")
+	default:
+		buf.WriteString(strconv.Itoa(data.Battery))
+	}
+	buf.WriteString(`,"interval_s":`)
+	buf.WriteString(strconv.FormatFloat(data.Interval.Seconds(), 'g', -1, 64))
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes a sample encoded with MarshalJSON.
+// Quantities measured by the model of the device that are null or absent
+// are recorded in the Missing field.
+func (data *Data) UnmarshalJSON(p []byte) error {
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal(p, &raw)
+	if err != nil {
+		return fmt.Errorf("aranet4: could not decode data sample: %w", err)
+	}
+	isNull := func(key string) bool {
+		v, ok := raw[key]
+		return !ok || string(v) == "null"
+	}
+
+	var v Data
+	if !isNull("time") {
+		err = json.Unmarshal(raw["time"], &v.Time)
+		if err != nil {
+			return fmt.Errorf("aranet4: could not decode time-stamp: %w", err)
+		}
+	}
+	if !isNull("model") {
+		err = json.Unmarshal(raw["model"], &v.Model)
+		if err != nil {
+			return fmt.Errorf("aranet4: could not decode model: %w", err)
+		}
+	}
+
+	fields := v.Model.Fields()
+	for _, q := range quantities {
+		if fields&q.Field == 0 {
+			continue
+		}
+		if isNull(q.Key) {
+			v.Missing |= q.Field
+			continue
+		}
+		var f float64
+		err = json.Unmarshal(raw[q.Key], &f)
+		if err != nil {
+			return fmt.Errorf("aranet4: could not decode %s: %w", q.Key, err)
+		}
+		q.set(&v, f)
+	}
+
+	if !isNull("quality") {
+		err = json.Unmarshal(raw["quality"], &v.Quality)
+		if err != nil {
+			return fmt.Errorf("aranet4: could not decode quality: %w", err)
+		}
+	}
+	v.Battery = -1
+	if !isNull("battery_pct") {
+		err = json.Unmarshal(raw["battery_pct"], &v.Battery)
+		if err != nil {
+			return fmt.Errorf("aranet4: could not decode battery level: %w", err)
+		}
+	}
+	if !isNull("interval_s") {
+		var sec float64
+		err = json.Unmarshal(raw["interval_s"], &sec)
+		if err != nil {
+			return fmt.Errorf("aranet4: could not decode interval: %w", err)
+		}
+		v.Interval = time.Duration(math.Round(sec * float64(time.Second)))
+	}
+
+	*data = v
+	return nil
+}
+
+// MarshalText encodes the sample as a single line of space separated
+// key=value pairs, with the same keys and units than MarshalJSON.
+// Missing quantities are encoded as "n/a".
+// As with MarshalJSON, non-finite values are rejected.
+func (data Data) MarshalText() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("time=")
+	buf.WriteString(data.Time.Format(time.RFC3339Nano))
+	buf.WriteString(" model=")
+	buf.WriteString(textValue(data.Model.String()))
+
+	fields := data.Model.Fields()
+	for _, q := range quantities {
+		if fields&q.Field == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, " %s=", q.Key)
+		if !data.Valid(q.Field) {
+			buf.WriteString("n/a")
+			continue
+		}
+		v, err := q.value(&data)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+
+	if data.Model.hasQuality() {
+		buf.WriteString(" quality=")
+		switch data.Quality {
+		case 0:
+			buf.WriteString("n/a")
+		default:
+			buf.WriteString(textValue(data.Quality.String()))
+		}
+	}
+	buf.WriteString(" battery_pct=")
+	switch {
+	case data.Battery < 0:
+		buf.WriteString("n/a")
+	default:
+		buf.WriteString(strconv.Itoa(data.Battery))
+	}
+	buf.WriteString(" interval_s=")
+	buf.WriteString(strconv.FormatFloat(data.Interval.Seconds(), 'g', -1, 64))
+	return buf.Bytes(), nil
+}
+
+// textValue quotes v if it holds spaces, quotes or equal signs.
+func textValue(v string) string {
+	if bytes.ContainsAny([]byte(v), " \"=") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// MarshalText returns the name of the model.
+func (m Model) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText decodes the name of a model, as returned by String.
+func (m *Model) UnmarshalText(p []byte) error {
+	for _, v := range []Model{
+		ModelUnknown, ModelAranet4, ModelAranet2,
+		ModelAranetRadiation, ModelAranetRadon,
+	} {
+		if string(p) == v.String() {
+			*m = v
+			return nil
+		}
+	}
+	var v int
+	_, err := fmt.Sscanf(string(p), "Model(%d)", &v)
+	if err != nil {
+		return fmt.Errorf("aranet4: invalid model %q", p)
+	}
+	*m = Model(v)
+	return nil
+}
+
+// MarshalText returns the name of the air quality assessment.
+func (st Quality) MarshalText() ([]byte, error) {
+	return []byte(st.String()), nil
+}
+
+// UnmarshalText decodes the name of an air quality assessment, as
+// returned by String.
+func (st *Quality) UnmarshalText(p []byte) error {
+	for _, v := range []Quality{1, 2, 3} {
+		if string(p) == v.String() {
+			*st = v
+			return nil
+		}
+	}
+	var v int
+	_, err := fmt.Sscanf(string(p), "Quality(%d)", &v)
+	if err != nil {
+		return fmt.Errorf("aranet4: invalid quality %q", p)
+	}
+	*st = Quality(v)
+	return nil
+}
+
+var (
+	_ json.Marshaler   = Data{}
+	_ json.Unmarshaler = (*Data)(nil)
+)
@@ -0,0 +1,127 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDataMarshal(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data Data
+		json string
+		text string
+	}{
+		{
+			name: "aranet4",
+			data: Data{
+				H: 29, P: 980.5, T: 19.85,
+				CO2:      547,
+				Battery:  96,
+				Quality:  1,
+				Model:    ModelAranet4,
+				Interval: 5 * time.Minute,
+				Time:     time.Date(2022, 1, 20, 15, 48, 28, 0, time.UTC),
+			},
+			json: `{"time":"2022-01-20T15:48:28Z","model":"Aranet4","co2_ppm":547,"temperature_c":19.85,"pressure_hpa":980.5,"humidity_pct":29,"quality":"green","battery_pct":96,"interval_s":300}`,
+			text: `time=2022-01-20T15:48:28Z model=Aranet4 co2_ppm=547 temperature_c=19.85 pressure_hpa=980.5 humidity_pct=29 quality=green battery_pct=96 interval_s=300`,
+		},
+		{
+			name: "missing",
+			data: Data{
+				H:        29,
+				Battery:  -1,
+				Model:    ModelAranet4,
+				Interval: time.Minute,
+				Time:     time.Date(2022, 1, 20, 15, 48, 28, 0, time.UTC),
+				Missing:  FieldT | FieldP | FieldCO2,
+			},
+			json: `{"time":"2022-01-20T15:48:28Z","model":"Aranet4","co2_ppm":null,"temperature_c":null,"pressure_hpa":null,"humidity_pct":29,"quality":null,"battery_pct":null,"interval_s":60}`,
+			text: `time=2022-01-20T15:48:28Z model=Aranet4 co2_ppm=n/a temperature_c=n/a pressure_hpa=n/a humidity_pct=29 quality=n/a battery_pct=n/a interval_s=60`,
+		},
+		{
+			name: "radiation",
+			data: Data{
+				DoseRate: 0.09, Dose: 0.012345,
+				Battery:  100,
+				Model:    ModelAranetRadiation,
+				Interval: 10 * time.Minute,
+				Time:     time.Date(2022, 1, 20, 16, 0, 0, 0, time.UTC),
+			},
+			json: `{"time":"2022-01-20T16:00:00Z","model":"Aranet Radiation","dose_rate_usvh":0.09,"dose_msv":0.012345,"battery_pct":100,"interval_s":600}`,
+			text: `time=2022-01-20T16:00:00Z model="Aranet Radiation" dose_rate_usvh=0.09 dose_msv=0.012345 battery_pct=100 interval_s=600`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := json.Marshal(tc.data)
+			if err != nil {
+				t.Fatalf("could not marshal to JSON: %+v", err)
+			}
+			if got, want := string(p), tc.json; got != want {
+				t.Fatalf("invalid JSON:\ngot= %s\nwant=%s", got, want)
+			}
+
+			var got Data
+			err = json.Unmarshal(p, &got)
+			if err != nil {
+				t.Fatalf("could not unmarshal from JSON: %+v", err)
+			}
+			if got != tc.data {
+				t.Fatalf("invalid JSON round-trip:\ngot= %#v\nwant=%#v", got, tc.data)
+			}
+
+			p, err = tc.data.MarshalText()
+			if err != nil {
+				t.Fatalf("could not marshal to text: %+v", err)
+			}
+			if got, want := string(p), tc.text; got != want {
+				t.Fatalf("invalid text:\ngot= %s\nwant=%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDataMarshalNonFinite(t *testing.T) {
+	for _, data := range []Data{
+		{Model: ModelAranet4, T: math.NaN()},
+		{Model: ModelAranetRadiation, DoseRate: math.Inf(+1)},
+	} {
+		_, err := data.MarshalJSON()
+		if err == nil {
+			t.Fatalf("expected an error encoding %#v to JSON", data)
+		}
+		_, err = data.MarshalText()
+		if err == nil {
+			t.Fatalf("expected an error encoding %#v to text", data)
+		}
+	}
+
+	// missing quantities are not encoded.
+	data := Data{Model: ModelAranet4, T: math.NaN(), Missing: FieldT}
+	_, err := data.MarshalText()
+	if err != nil {
+		t.Fatalf("could not encode sample with missing NaN value: %+v", err)
+	}
+}
+
+func TestDataUnmarshalJSONErrors(t *testing.T) {
+	for _, raw := range []string{
+		`[]`,
+		`{"time":"yesterday"}`,
+		`{"model":"Aranet5"}`,
+		`{"model":"Aranet4","co2_ppm":"high"}`,
+		`{"model":"Aranet4","quality":"blue"}`,
+	} {
+		var data Data
+		err := json.Unmarshal([]byte(raw), &data)
+		if err == nil {
+			t.Fatalf("expected an error for %s", raw)
+		}
+	}
+}
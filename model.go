@@ -0,0 +1,100 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Model identifies a model of Aranet device.
+type Model int
+
+const (
+	ModelUnknown Model = iota
+	ModelAranet4
+	ModelAranet2         // temperature and humidity
+	ModelAranetRadiation // radiation dose rate and total dose
+	ModelAranetRadon     // radon concentration, temperature, pressure and humidity
+)
+
+func (m Model) String() string {
+	switch m {
+	case ModelUnknown:
+		return "unknown"
+	case ModelAranet4:
+		return "Aranet4"
+	case ModelAranet2:
+		return "Aranet2"
+	case ModelAranetRadiation:
+		return "Aranet Radiation"
+	case ModelAranetRadon:
+		return "Aranet Radon Plus"
+	default:
+		return fmt.Sprintf("Model(%d)", int(m))
+	}
+}
+
+// Fields returns the quantities measured by the model.
+// Data samples of an unknown model are assumed to come from an Aranet4.
+func (m Model) Fields() Field {
+	switch m {
+	case ModelUnknown, ModelAranet4:
+		return FieldT | FieldH | FieldP | FieldCO2
+	case ModelAranet2:
+		return FieldT | FieldH
+	case ModelAranetRadiation:
+		return FieldDoseRate | FieldDose
+	case ModelAranetRadon:
+		return FieldT | FieldH | FieldP | FieldRadon
+	default:
+		return 0
+	}
+}
+
+// params returns the history parameters recorded by the model.
+func (m Model) params() []Param {
+	switch m {
+	case ModelAranet2:
+		return []Param{ParamT, ParamH2}
+	case ModelAranetRadiation:
+		return []Param{ParamDoseRate, ParamDose}
+	case ModelAranetRadon:
+		return []Param{ParamT, ParamH2, ParamP, ParamRadon}
+	default:
+		return []Param{ParamT, ParamH, ParamP, ParamCO2}
+	}
+}
+
+// modelFromType returns the model of a device from the device type it
+// reports in its detailed readings and advertisements.
+func modelFromType(typ int) Model {
+	switch typ {
+	case 1:
+		return ModelAranet2
+	case 2:
+		return ModelAranetRadiation
+	case 3:
+		return ModelAranetRadon
+	default:
+		return ModelUnknown
+	}
+}
+
+// modelFromName returns the model of a device from its advertised name.
+func modelFromName(name string) Model {
+	switch {
+	case strings.HasPrefix(name, "Aranet4"):
+		return ModelAranet4
+	case strings.HasPrefix(name, "Aranet2"):
+		return ModelAranet2
+	case strings.HasPrefix(name, "Aranet☢"), strings.HasPrefix(name, "Aranet Radiation"):
+		return ModelAranetRadiation
+	case strings.HasPrefix(name, "AranetRn+"), strings.HasPrefix(name, "Aranet Radon"):
+		return ModelAranetRadon
+	default:
+		return ModelUnknown
+	}
+}
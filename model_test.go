@@ -0,0 +1,200 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModelFromName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want Model
+	}{
+		{"Aranet4 1234A", ModelAranet4},
+		{"Aranet2 0042B", ModelAranet2},
+		{"Aranet☢ 1A2B3", ModelAranetRadiation},
+		{"AranetRn+ 0A1B2", ModelAranetRadon},
+		{"Aranet", ModelUnknown},
+		{"headphones", ModelUnknown},
+		{"", ModelUnknown},
+	} {
+		if got := modelFromName(tc.name); got != tc.want {
+			t.Fatalf("invalid model for %q: got=%v, want=%v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// detailed current readings of Aranet2, Aranet Radiation and Aranet Radon
+// devices.
+var (
+	aranet2Payload = []byte{
+		0x01, 0x00, // type: Aranet2
+		0x3c, 0x00, // interval: 60s
+		0x0a, 0x00, // ago: 10s
+		0x5a,       // battery: 90%
+		0x8f, 0x01, // T: 19.95°C
+		0xc3, 0x01, // H: 45.1%
+		0x00, // status
+	}
+	radiationPayload = []byte{
+		0x02, 0x00, // type: Aranet Radiation
+		0x2c, 0x01, // interval: 300s
+		0x0a, 0x00, // ago: 10s
+		0x5a,                   // battery: 90%
+		0x6e, 0x00, 0x00, 0x00, // dose rate: 110 nSv/h
+		0x40, 0x4b, 0x4c, 0x00, 0x00, 0x00, 0x00, 0x00, // dose: 5 000 000 nSv
+		0x10, 0x0e, 0x00, 0x00, // duration: 3600s
+		0x00, // status
+	}
+	radonPayload = []byte{
+		0x03, 0x00, // type: Aranet Radon
+		0x58, 0x02, // interval: 600s
+		0x0a, 0x00, // ago: 10s
+		0x5a,       // battery: 90%
+		0x8f, 0x01, // T: 19.95°C
+		0x4d, 0x26, // P: 980.5 hPa
+		0xc3, 0x01, // H: 45.1%
+		0x96, 0x00, 0x00, 0x00, // radon: 150 Bq/m³
+		0x02, // status: yellow
+	}
+)
+
+func TestDecodeCurrentDetailed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		want Data
+	}{
+		{
+			name: "aranet2",
+			raw:  aranet2Payload,
+			want: Data{
+				T: 19.95, H: 45.1,
+				Battery:  90,
+				Interval: time.Minute,
+				Model:    ModelAranet2,
+			},
+		},
+		{
+			name: "radiation",
+			raw:  radiationPayload,
+			want: Data{
+				DoseRate: 0.11,
+				Dose:     5,
+				Battery:  90,
+				Interval: 5 * time.Minute,
+				Model:    ModelAranetRadiation,
+			},
+		},
+		{
+			name: "radon",
+			raw:  radonPayload,
+			want: Data{
+				T: 19.95, P: 980.5, H: 45.1,
+				Radon:    150,
+				Battery:  90,
+				Status:   2,
+				Interval: 10 * time.Minute,
+				Model:    ModelAranetRadon,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeCurrentDetailed(tc.raw)
+			if err != nil {
+				t.Fatalf("could not decode: %+v", err)
+			}
+			got.Time = time.Time{}
+			if got != tc.want {
+				t.Fatalf("invalid data:\ngot= %#v\nwant=%#v", got, tc.want)
+			}
+			if got, want := got.Valid(FieldCO2), false; got != want {
+				t.Fatalf("invalid CO2 validity: got=%v, want=%v", got, want)
+			}
+
+			_, err = DecodeCurrentDetailed(tc.raw[:len(tc.raw)-1])
+			if err == nil {
+				t.Fatalf("expected an error decoding a short payload")
+			}
+		})
+	}
+
+	_, err := DecodeCurrentDetailed([]byte{0x07, 0x00, 0x3c, 0x00, 0x0a, 0x00, 0x5a})
+	if err == nil {
+		t.Fatalf("expected an error decoding an unknown device type")
+	}
+}
+
+func TestDeviceReadModel(t *testing.T) {
+	hist := []Data{
+		{T: 19.95, H: 45.1, Radon: 150, P: 980.5},
+		{T: 20.05, H: 45.3, Radon: 163, P: 980.4},
+		{T: 20.10, H: 45.2, Radon: 171, P: 980.6},
+	}
+
+	tr := newMemTransport()
+	tr.add(uuidGenericService, uuidGenericReadDeviceName).value = []byte("AranetRn+ 0A1B2")
+	tr.add(uuidDeviceService, uuidReadAllDetailed).value = radonPayload
+	tr.add(uuidDeviceService, uuidReadInterval).value = []byte{0x58, 0x02}
+	tr.add(uuidDeviceService, uuidReadSecondsSinceUpdate).value = []byte{0x0a, 0x00}
+	tr.add(uuidDeviceService, uuidReadTotalReadings).value = []byte{byte(len(hist)), 0x00}
+	ts := tr.add(uuidDeviceService, uuidReadTimeSeries)
+	tr.add(uuidDeviceService, uuidWriteCmd).write = func(p []byte) {
+		id := Param(p[1])
+		chunk := []byte{byte(id), 0x01, 0x00, byte(len(hist))}
+		for _, v := range hist {
+			switch id {
+			case ParamT:
+				chunk = appendU16(chunk, uint16(v.T*20+0.5))
+			case ParamH2:
+				chunk = appendU16(chunk, uint16(v.H*10+0.5))
+			case ParamP:
+				chunk = appendU16(chunk, uint16(v.P*10+0.5))
+			case ParamRadon:
+				chunk = appendU16(chunk, uint16(v.Radon))
+				chunk = appendU16(chunk, uint16(v.Radon>>16))
+			default:
+				t.Errorf("unexpected history request for %v", id)
+				return
+			}
+		}
+		go ts.notify(chunk)
+	}
+
+	dev := NewWithTransport(tr)
+	defer dev.Close()
+
+	model, err := dev.Model()
+	if err != nil {
+		t.Fatalf("could not identify model: %+v", err)
+	}
+	if got, want := model, ModelAranetRadon; got != want {
+		t.Fatalf("invalid model: got=%v, want=%v", got, want)
+	}
+
+	cur, err := dev.Read()
+	if err != nil {
+		t.Fatalf("could not read data: %+v", err)
+	}
+	if got, want := cur.Radon, 150; got != want {
+		t.Fatalf("invalid radon: got=%d, want=%d", got, want)
+	}
+
+	vs, err := dev.ReadAll()
+	if err != nil {
+		t.Fatalf("could not read history: %+v", err)
+	}
+	for i, v := range vs {
+		want := hist[i]
+		if v.T != want.T || v.H != want.H || v.P != want.P || v.Radon != want.Radon {
+			t.Fatalf("invalid sample %d:\ngot= %#v\nwant=%#v", i, v, want)
+		}
+		if got, want := v.Model, ModelAranetRadon; got != want {
+			t.Fatalf("invalid model for sample %d: got=%v, want=%v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+// Policy classifies data samples into levels of air quality.
+//
+// Each metric of a policy yields a level of quality for a sample, and the
+// sample is assigned the worst of these levels.
+// Metrics that are disabled, not measured by the model of the device or
+// missing from the sample are ignored.
+// Samples without any classified metric get the zero (unknown) Quality.
+type Policy struct {
+	CO2   Thresholds // CO2 concentration, in ppm
+	Radon Thresholds // radon concentration, in Bq/m³
+	T     Band       // temperature, in °C
+	H     Band       // relative humidity, in %
+}
+
+// DefaultPolicy returns the policy of Aranet devices:
+//   - green:  [   0 - 1000) ppm of CO2
+//   - yellow: [1000 - 1400) ppm of CO2
+//   - red:    [1400 -  ...) ppm of CO2
+func DefaultPolicy() Policy {
+	return Policy{
+		CO2: Thresholds{Yellow: 1000, Red: 1400},
+	}
+}
+
+// Thresholds classifies a metric that degrades the air quality as it
+// increases.
+// A zero threshold is disabled.
+type Thresholds struct {
+	Yellow float64 // value from which the quality is yellow
+	Red    float64 // value from which the quality is red
+
+	// Hysteresis is the distance by which the value must fall below a
+	// threshold to leave its level, once reached.
+	// Hysteresis is only applied by Classifier.
+	Hysteresis float64
+}
+
+func (th Thresholds) enabled() bool {
+	return th.Yellow > 0 || th.Red > 0
+}
+
+func (th Thresholds) level(v float64, prev Quality) Quality {
+	var (
+		yellow = th.Yellow
+		red    = th.Red
+	)
+	switch prev {
+	case QualityRed:
+		red -= th.Hysteresis
+		yellow -= th.Hysteresis
+	case QualityYellow:
+		yellow -= th.Hysteresis
+	}
+
+	switch {
+	case th.Red > 0 && v >= red:
+		return QualityRed
+	case th.Yellow > 0 && v >= yellow:
+		return QualityYellow
+	default:
+		return QualityGreen
+	}
+}
+
+// Band classifies a metric with a comfort band: the quality is green
+// within the band, yellow outside of it, and red farther than Margin
+// outside of it.
+// A band with Lo >= Hi is disabled.
+type Band struct {
+	Lo, Hi float64 // bounds of the comfort band
+	Margin float64 // distance to the band beyond which the quality is red (0: never red)
+
+	// Hysteresis is the distance by which the value must come back
+	// toward the band to leave a level, once reached.
+	// Hysteresis is only applied by Classifier.
+	Hysteresis float64
+}
+
+func (b Band) enabled() bool {
+	return b.Lo < b.Hi
+}
+
+func (b Band) level(v float64, prev Quality) Quality {
+	// signed distance to the band, negative within the band.
+	d := b.Lo - v
+	if v-b.Hi > d {
+		d = v - b.Hi
+	}
+
+	var (
+		yellow = 0.0
+		red    = b.Margin
+	)
+	switch prev {
+	case QualityRed:
+		red -= b.Hysteresis
+		yellow -= b.Hysteresis
+	case QualityYellow:
+		yellow -= b.Hysteresis
+	}
+
+	switch {
+	case b.Margin > 0 && d > red:
+		return QualityRed
+	case d > yellow:
+		return QualityYellow
+	default:
+		return QualityGreen
+	}
+}
+
+// metrics of a policy.
+const (
+	metricCO2 = iota
+	metricRadon
+	metricT
+	metricH
+	nmetrics
+)
+
+// levels classifies each metric of the sample, given the levels of the
+// previous sample.
+func (p Policy) levels(data Data, prev [nmetrics]Quality) [nmetrics]Quality {
+	var out [nmetrics]Quality
+	if p.CO2.enabled() && data.Valid(FieldCO2) {
+		out[metricCO2] = p.CO2.level(float64(data.CO2), prev[metricCO2])
+	}
+	if p.Radon.enabled() && data.Valid(FieldRadon) {
+		out[metricRadon] = p.Radon.level(float64(data.Radon), prev[metricRadon])
+	}
+	if p.T.enabled() && data.Valid(FieldT) {
+		out[metricT] = p.T.level(data.T, prev[metricT])
+	}
+	if p.H.enabled() && data.Valid(FieldH) {
+		out[metricH] = p.H.level(data.H, prev[metricH])
+	}
+	return out
+}
+
+func worst(levels [nmetrics]Quality) Quality {
+	var q Quality
+	for _, v := range levels {
+		if v > q {
+			q = v
+		}
+	}
+	return q
+}
+
+// Classify returns the quality of the sample, without hysteresis.
+func (p Policy) Classify(data Data) Quality {
+	return worst(p.levels(data, [nmetrics]Quality{}))
+}
+
+// Classifier classifies a time series of samples with a policy, applying
+// the hysteresis of its metrics.
+//
+// A Classifier is not safe for concurrent use.
+type Classifier struct {
+	policy Policy
+	prev   [nmetrics]Quality // levels of the previous sample
+}
+
+// NewClassifier returns a classifier for the provided policy.
+func NewClassifier(p Policy) *Classifier {
+	return &Classifier{policy: p}
+}
+
+// Classify returns the quality of the sample, following the previously
+// classified samples of the series.
+// Metrics missing from the sample keep their previous level.
+func (c *Classifier) Classify(data Data) Quality {
+	cur := c.policy.levels(data, c.prev)
+	for i, v := range cur {
+		if v == 0 {
+			continue
+		}
+		c.prev[i] = v
+	}
+	return worst(cur)
+}
+
+// Fill sets the quality of the samples of the series, sorted by time.
+func (c *Classifier) Fill(data []Data) {
+	for i := range data {
+		data[i].Quality = c.Classify(data[i])
+	}
+}
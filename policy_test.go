@@ -0,0 +1,164 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPolicyClassify(t *testing.T) {
+	comfort := Policy{
+		CO2: Thresholds{Yellow: 800, Red: 1400},
+		T:   Band{Lo: 19, Hi: 26, Margin: 3},
+		H:   Band{Lo: 30, Hi: 60},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		data   Data
+		want   Quality
+	}{
+		{"default-green", DefaultPolicy(), Data{CO2: 999}, QualityGreen},
+		{"default-yellow", DefaultPolicy(), Data{CO2: 1000}, QualityYellow},
+		{"default-red", DefaultPolicy(), Data{CO2: 1400}, QualityRed},
+		{"default-missing", DefaultPolicy(), Data{CO2: 1400, Missing: FieldCO2}, 0},
+		{"default-aranet2", DefaultPolicy(), Data{Model: ModelAranet2, T: 40}, 0},
+		{"zero-policy", Policy{}, Data{CO2: 2000}, 0},
+		{"comfort-green", comfort, Data{CO2: 600, T: 21, H: 40}, QualityGreen},
+		{"comfort-co2", comfort, Data{CO2: 800, T: 21, H: 40}, QualityYellow},
+		{"comfort-cold", comfort, Data{CO2: 600, T: 18, H: 40}, QualityYellow},
+		{"comfort-too-cold", comfort, Data{CO2: 600, T: 15.5, H: 40}, QualityRed},
+		{"comfort-hot", comfort, Data{CO2: 600, T: 29, H: 40}, QualityYellow},
+		{"comfort-dry", comfort, Data{CO2: 600, T: 21, H: 10}, QualityYellow},
+		{"comfort-worst", comfort, Data{CO2: 1500, T: 21, H: 70}, QualityRed},
+		{"comfort-missing-co2", comfort, Data{T: 21, H: 70, Missing: FieldCO2}, QualityYellow},
+		{
+			"radon",
+			Policy{Radon: Thresholds{Yellow: 100, Red: 300}},
+			Data{Model: ModelAranetRadon, Radon: 150},
+			QualityYellow,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.policy.Classify(tc.data)
+			if got != tc.want {
+				t.Fatalf("invalid quality: got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClassifierHysteresis(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		data   []Data
+		want   []Quality
+	}{
+		{
+			name:   "co2-no-hysteresis",
+			policy: Policy{CO2: Thresholds{Yellow: 800, Red: 1400}},
+			data: []Data{
+				{CO2: 700}, {CO2: 810}, {CO2: 790}, {CO2: 1450}, {CO2: 1390}, {CO2: 750},
+			},
+			want: []Quality{1, 2, 1, 3, 2, 1},
+		},
+		{
+			name:   "co2",
+			policy: Policy{CO2: Thresholds{Yellow: 800, Red: 1400, Hysteresis: 50}},
+			data: []Data{
+				{CO2: 700}, {CO2: 810}, {CO2: 790}, {CO2: 740}, {CO2: 1450}, {CO2: 1390},
+				{CO2: 1340}, {CO2: 760}, {CO2: 749},
+			},
+			want: []Quality{1, 2, 2, 1, 3, 3, 2, 2, 1},
+		},
+		{
+			name:   "co2-missing",
+			policy: Policy{CO2: Thresholds{Yellow: 800, Red: 1400, Hysteresis: 50}},
+			data: []Data{
+				{CO2: 900}, {Missing: FieldCO2}, {CO2: 790},
+			},
+			want: []Quality{2, 0, 2},
+		},
+		{
+			name:   "band",
+			policy: Policy{T: Band{Lo: 19, Hi: 26, Margin: 3, Hysteresis: 0.5}},
+			data: []Data{
+				{T: 21}, {T: 26.5}, {T: 25.8}, {T: 25.4}, {T: 29.5}, {T: 28.8}, {T: 28.4},
+				{T: 18.9}, {T: 19.4}, {T: 19.6},
+			},
+			want: []Quality{1, 2, 2, 1, 3, 3, 2, 2, 2, 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				c   = NewClassifier(tc.policy)
+				got = make([]Quality, len(tc.data))
+			)
+			for i, v := range tc.data {
+				got[i] = c.Classify(v)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid quality:\ngot= %v\nwant=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClassifierFill(t *testing.T) {
+	data := []Data{
+		{CO2: 900},
+		{CO2: 1500, Quality: QualityYellow, Status: QualityYellow}, // reported by the device
+		{CO2: 500},
+	}
+	NewClassifier(DefaultPolicy()).Fill(data)
+
+	got := []Quality{data[0].Quality, data[1].Quality, data[2].Quality}
+	want := []Quality{QualityGreen, QualityRed, QualityGreen}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid quality:\ngot= %v\nwant=%v", got, want)
+	}
+}
+
+func TestDataStringQuality(t *testing.T) {
+	schools := Policy{CO2: Thresholds{Yellow: 800, Red: 1400}}
+	for _, tc := range []struct {
+		name string
+		data Data
+		desc func(Data) string
+		want string
+	}{
+		{"string", Data{CO2: 1200, Quality: QualityRed}, Data.String, "quality:     red\n"},
+		{"string-unknown", Data{CO2: 1200}, Data.String, "quality:     n/a\n"},
+		{
+			"default",
+			Data{CO2: 900, Quality: QualityRed, Status: QualityRed},
+			func(data Data) string { return data.Describe(DefaultPolicy()) },
+			"quality:     green\n",
+		},
+		{
+			"schools",
+			Data{CO2: 900, Status: QualityGreen},
+			func(data Data) string { return data.Describe(schools) },
+			"quality:     yellow\n",
+		},
+		{
+			"missing",
+			Data{Missing: FieldCO2},
+			func(data Data) string { return data.Describe(DefaultPolicy()) },
+			"quality:     n/a\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.desc(tc.data)
+			if !strings.Contains(got, tc.want) {
+				t.Fatalf("invalid string:\ngot:\n%s\nwant: %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// companyID is the Bluetooth SIG company identifier of SAF Tehnika,
+// the manufacturer of Aranet devices.
+const companyID = 0x0702
+
+// ScanResult describes an Aranet device seen during a scan.
+type ScanResult struct {
+	Addr  string // MAC address of the device
+	Name  string // advertised local name of the device
+	RSSI  int    // received signal strength of the last advertisement, in dBm
+	Model Model  // model of the device
+
+	FirstSeen time.Time // time of the first advertisement received
+	LastSeen  time.Time // time of the last advertisement received
+}
+
+// ScanOptions configures a scan for Aranet devices.
+type ScanOptions struct {
+	// Func, if not nil, is called each time an advertisement from an
+	// Aranet device is received.
+	Func func(ScanResult)
+}
+
+// Scan scans for nearby Aranet devices, using the default Bluetooth
+// adapter.
+//
+// Scan runs until the context is done, and then returns all the devices
+// it has seen, sorted by address.
+// Devices may be streamed as they are seen via ScanOptions.Func.
+func Scan(ctx context.Context, opts ScanOptions) ([]ScanResult, error) {
+	return scan(ctx, btScanner{}, opts, time.Now)
+}
+
+// advertisement is a BLE advertisement.
+type advertisement struct {
+	addr   string
+	name   string
+	rssi   int
+	aranet bool   // whether the Aranet4 service UUID is advertised
+	mfr    []byte // SAF Tehnika manufacturer data, without the company ID
+}
+
+// scanner scans for BLE advertisements, until the context is done.
+type scanner interface {
+	scan(ctx context.Context, f func(adv advertisement)) error
+}
+
+func scan(ctx context.Context, sc scanner, opts ScanOptions, now func() time.Time) ([]ScanResult, error) {
+	seen := make(map[string]*ScanResult)
+	err := sc.scan(ctx, func(adv advertisement) {
+		res, ok := seen[adv.addr]
+		if !ok && !isAranet(adv) {
+			return
+		}
+		t := now().UTC()
+		if !ok {
+			res = &ScanResult{
+				Addr:      adv.addr,
+				FirstSeen: t,
+			}
+			seen[adv.addr] = res
+		}
+		if adv.name != "" {
+			res.Name = adv.name
+		}
+		res.RSSI = adv.rssi
+		res.LastSeen = t
+		if m := modelOf(adv); m != ModelUnknown {
+			res.Model = m
+		}
+		if opts.Func != nil {
+			opts.Func(*res)
+		}
+	})
+	if err != nil && err != ctx.Err() {
+		return nil, fmt.Errorf("could not scan for devices: %w", err)
+	}
+
+	out := make([]ScanResult, 0, len(seen))
+	for _, res := range seen {
+		out = append(out, *res)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Addr < out[j].Addr
+	})
+	return out, nil
+}
+
+func isAranet(adv advertisement) bool {
+	return adv.aranet || adv.mfr != nil || strings.HasPrefix(adv.name, "Aranet")
+}
+
+func modelOf(adv advertisement) Model {
+	if m := modelFromName(adv.name); m != ModelUnknown {
+		return m
+	}
+	if adv.aranet {
+		return ModelAranet4
+	}
+	return modelFromManufacturerData(adv.mfr)
+}
+
+// modelFromManufacturerData returns the model of a device from its
+// SAF Tehnika manufacturer data.
+// Aranet4 devices advertise a payload of fixed size, starting with their
+// flags; the payload of the other models starts with their device type.
+func modelFromManufacturerData(p []byte) Model {
+	switch len(p) {
+	case 0:
+		return ModelUnknown
+	case advHeaderSize, advHeaderSize + advDataSize, advHeaderSize + advDataSize + 1:
+		return ModelAranet4
+	}
+	return modelFromType(int(p[0]))
+}
+
+// manufacturerData returns the SAF Tehnika manufacturer specific data
+// (without the company ID) held in the raw advertisement packet p,
+// or nil if there is none.
+func manufacturerData(p []byte) []byte {
+	for len(p) >= 2 {
+		n := int(p[0])
+		if n == 0 || n+1 > len(p) {
+			return nil
+		}
+		if typ := p[1]; typ == 0xff && n >= 3 {
+			v := p[2 : n+1]
+			if int(v[0])|int(v[1])<<8 == companyID {
+				return v[2:]
+			}
+		}
+		p = p[n+1:]
+	}
+	return nil
+}
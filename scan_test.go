@@ -0,0 +1,123 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// replayScanner replays a list of advertisements.
+type replayScanner []advertisement
+
+func (sc replayScanner) scan(ctx context.Context, f func(adv advertisement)) error {
+	for _, adv := range sc {
+		f(adv)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestScan(t *testing.T) {
+	var (
+		t0    = time.Date(2022, time.January, 20, 15, 0, 0, 0, time.UTC)
+		clock = t0
+		now   = func() time.Time {
+			clock = clock.Add(time.Second)
+			return clock
+		}
+		sc = replayScanner{
+			{addr: "F5:6C:BE:D5:61:47", name: "Aranet4 1234A", rssi: -80},
+			{addr: "00:11:22:33:44:55", name: "headphones", rssi: -40},
+			{addr: "C0:FF:EE:00:00:01", rssi: -90, aranet: true},
+			{addr: "F5:6C:BE:D5:61:47", rssi: -70},
+			{addr: "C0:FF:EE:00:00:02", rssi: -60, mfr: []byte{0x21, 0x01}},
+			{addr: "C0:FF:EE:00:00:01", name: "Aranet4 0042B", rssi: -85},
+			// devices only advertising their manufacturer data.
+			{addr: "C0:FF:EE:00:00:03", rssi: -50, mfr: []byte{0x02, 0x21, 0x04, 0x01, 0x00}},
+			{addr: "C0:FF:EE:00:00:04", rssi: -55, mfr: advPayload},
+		}
+		streamed int
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	got, err := scan(ctx, sc, ScanOptions{
+		Func: func(ScanResult) { streamed++ },
+	}, now)
+	if err != nil {
+		t.Fatalf("could not scan: %+v", err)
+	}
+
+	at := func(i int) time.Time { return t0.Add(time.Duration(i) * time.Second) }
+	want := []ScanResult{
+		{
+			Addr: "C0:FF:EE:00:00:01", Name: "Aranet4 0042B", RSSI: -85,
+			Model: ModelAranet4, FirstSeen: at(2), LastSeen: at(5),
+		},
+		{
+			Addr: "C0:FF:EE:00:00:02", RSSI: -60,
+			Model: ModelUnknown, FirstSeen: at(4), LastSeen: at(4),
+		},
+		{
+			Addr: "C0:FF:EE:00:00:03", RSSI: -50,
+			Model: ModelAranetRadiation, FirstSeen: at(6), LastSeen: at(6),
+		},
+		{
+			Addr: "C0:FF:EE:00:00:04", RSSI: -55,
+			Model: ModelAranet4, FirstSeen: at(7), LastSeen: at(7),
+		},
+		{
+			Addr: "F5:6C:BE:D5:61:47", Name: "Aranet4 1234A", RSSI: -70,
+			Model: ModelAranet4, FirstSeen: at(1), LastSeen: at(3),
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid scan results:\ngot= %+v\nwant=%+v", got, want)
+	}
+	if got, want := streamed, 7; got != want {
+		t.Fatalf("invalid number of streamed results: got=%d, want=%d", got, want)
+	}
+}
+
+func TestManufacturerData(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		want []byte
+	}{
+		{
+			name: "flags+name+mfr",
+			raw: []byte{
+				0x02, 0x01, 0x06,
+				0x04, 0x09, 'A', 'r', 'a',
+				0x05, 0xff, 0x02, 0x07, 0x21, 0x01,
+			},
+			want: []byte{0x21, 0x01},
+		},
+		{
+			name: "other-company",
+			raw:  []byte{0x05, 0xff, 0x4c, 0x00, 0x21, 0x01},
+		},
+		{
+			name: "truncated",
+			raw:  []byte{0x02, 0x01, 0x06, 0x09, 0xff, 0x02, 0x07},
+		},
+		{
+			name: "empty",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := manufacturerData(tc.raw)
+			if !bytes.Equal(got, tc.want) || (got == nil) != (tc.want == nil) {
+				t.Fatalf("invalid manufacturer data: got=%x, want=%x", got, tc.want)
+			}
+		})
+	}
+}
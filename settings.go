@@ -0,0 +1,219 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	cmdSetInterval     = 0x90
+	cmdSetIntegrations = 0x91
+	cmdSetRange        = 0x92
+)
+
+// flags of the sensor settings characteristic.
+const (
+	settingsIntegrations  = 1 << 5
+	settingsRangeExtended = 1 << 6
+)
+
+// BluetoothRange is the transmission range of the Bluetooth radio of
+// a device.
+type BluetoothRange uint8
+
+const (
+	RangeStandard BluetoothRange = 0
+	RangeExtended BluetoothRange = 1 // longer range, at the expense of battery life
+)
+
+func (r BluetoothRange) String() string {
+	switch r {
+	case RangeStandard:
+		return "standard"
+	case RangeExtended:
+		return "extended"
+	default:
+		return fmt.Sprintf("BluetoothRange(%d)", uint8(r))
+	}
+}
+
+// intervals are the measurement intervals supported by Aranet4 devices.
+var intervals = []time.Duration{
+	1 * time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+}
+
+// SetInterval changes the measurement interval of the device.
+// Valid intervals are 1, 2, 5 and 10 minutes.
+//
+// Changing the measurement interval clears the history of the device.
+func (dev *Device) SetInterval(d time.Duration) error {
+	return dev.SetIntervalContext(context.Background(), d)
+}
+
+// SetIntervalContext is like SetInterval, but aborts the operation when
+// the context is done.
+func (dev *Device) SetIntervalContext(ctx context.Context, d time.Duration) error {
+	return dev.do(ctx, func() error {
+		return dev.setInterval(d)
+	})
+}
+
+func (dev *Device) setInterval(d time.Duration) error {
+	valid := false
+	for _, v := range intervals {
+		if d == v {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid measurement interval %v (valid: %v)", d, intervals)
+	}
+
+	err := dev.writeCmd([]byte{cmdSetInterval, uint8(d / time.Minute)})
+	if err != nil {
+		return fmt.Errorf("could not set measurement interval: %w", err)
+	}
+
+	got, err := dev.interval()
+	if err != nil {
+		return fmt.Errorf("could not read back measurement interval: %w", err)
+	}
+	if got != d {
+		return fmt.Errorf("measurement interval not applied (got=%v, want=%v)", got, d)
+	}
+	return nil
+}
+
+// Integrations returns whether the Smart Home integrations of the device
+// are enabled.
+// When enabled, the device broadcasts its measurements in its Bluetooth
+// advertisements (see Listen).
+func (dev *Device) Integrations() (bool, error) {
+	return dev.IntegrationsContext(context.Background())
+}
+
+// IntegrationsContext is like Integrations, but aborts the operation
+// when the context is done.
+func (dev *Device) IntegrationsContext(ctx context.Context) (bool, error) {
+	var v byte
+	err := dev.do(ctx, func() (err error) {
+		v, err = dev.settings()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return v&settingsIntegrations != 0, nil
+}
+
+// SetIntegrations enables or disables the Smart Home integrations of
+// the device.
+func (dev *Device) SetIntegrations(enable bool) error {
+	return dev.SetIntegrationsContext(context.Background(), enable)
+}
+
+// SetIntegrationsContext is like SetIntegrations, but aborts the
+// operation when the context is done.
+func (dev *Device) SetIntegrationsContext(ctx context.Context, enable bool) error {
+	return dev.do(ctx, func() error {
+		return dev.setFlag(cmdSetIntegrations, settingsIntegrations, enable)
+	})
+}
+
+// BluetoothRange returns the transmission range of the device.
+func (dev *Device) BluetoothRange() (BluetoothRange, error) {
+	return dev.BluetoothRangeContext(context.Background())
+}
+
+// BluetoothRangeContext is like BluetoothRange, but aborts the operation
+// when the context is done.
+func (dev *Device) BluetoothRangeContext(ctx context.Context) (BluetoothRange, error) {
+	var v byte
+	err := dev.do(ctx, func() (err error) {
+		v, err = dev.settings()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if v&settingsRangeExtended != 0 {
+		return RangeExtended, nil
+	}
+	return RangeStandard, nil
+}
+
+// SetBluetoothRange changes the transmission range of the device.
+func (dev *Device) SetBluetoothRange(r BluetoothRange) error {
+	return dev.SetBluetoothRangeContext(context.Background(), r)
+}
+
+// SetBluetoothRangeContext is like SetBluetoothRange, but aborts the
+// operation when the context is done.
+func (dev *Device) SetBluetoothRangeContext(ctx context.Context, r BluetoothRange) error {
+	switch r {
+	case RangeStandard, RangeExtended:
+	default:
+		return fmt.Errorf("invalid Bluetooth range %v", r)
+	}
+	return dev.do(ctx, func() error {
+		return dev.setFlag(cmdSetRange, settingsRangeExtended, r == RangeExtended)
+	})
+}
+
+// setFlag sends the command cmd to turn a setting on or off, and checks
+// the corresponding flag of the sensor settings reflects the change.
+func (dev *Device) setFlag(cmd, flag byte, on bool) error {
+	v := byte(0)
+	if on {
+		v = 1
+	}
+	err := dev.writeCmd([]byte{cmd, v})
+	if err != nil {
+		return fmt.Errorf("could not write setting: %w", err)
+	}
+
+	flags, err := dev.settings()
+	if err != nil {
+		return fmt.Errorf("could not read back setting: %w", err)
+	}
+	if got := flags&flag != 0; got != on {
+		return fmt.Errorf("setting not applied (got=%v, want=%v)", got, on)
+	}
+	return nil
+}
+
+// settings returns the flags of the sensor settings characteristic.
+func (dev *Device) settings() (byte, error) {
+	raw, err := dev.readValue(uuidDeviceService, uuidReadSettings)
+	if err != nil {
+		return 0, err
+	}
+	if len(raw) == 0 {
+		return 0, protocolError("decode settings", raw, io.ErrUnexpectedEOF)
+	}
+	return raw[0], nil
+}
+
+// writeCmd sends a command to the device.
+func (dev *Device) writeCmd(cmd []byte) error {
+	c, err := dev.getCharByUUID(uuidWriteCmd)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.WriteWithoutResponse(cmd)
+	if err != nil {
+		return &CharacteristicError{Op: "write", Service: uuidDeviceService, UUID: uuidWriteCmd, Err: err}
+	}
+	return nil
+}
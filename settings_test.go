@@ -0,0 +1,109 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeviceSettings(t *testing.T) {
+	var cmds [][]byte
+	tr := newMemTransport()
+	tr.add(uuidDeviceService, uuidReadInterval).value = []byte{0x2c, 0x01}
+	tr.add(uuidDeviceService, uuidReadSettings).value = []byte{settingsIntegrations}
+	tr.add(uuidDeviceService, uuidWriteCmd).write = func(p []byte) {
+		cmds = append(cmds, p)
+	}
+
+	dev := NewWithTransport(tr)
+	defer dev.Close()
+
+	on, err := dev.Integrations()
+	if err != nil {
+		t.Fatalf("could not read integrations: %+v", err)
+	}
+	if got, want := on, true; got != want {
+		t.Fatalf("invalid integrations: got=%v, want=%v", got, want)
+	}
+
+	r, err := dev.BluetoothRange()
+	if err != nil {
+		t.Fatalf("could not read bluetooth range: %+v", err)
+	}
+	if got, want := r, RangeStandard; got != want {
+		t.Fatalf("invalid bluetooth range: got=%v, want=%v", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		set  func() error
+		cmd  []byte
+		err  string
+	}{
+		{
+			name: "interval-same",
+			set:  func() error { return dev.SetInterval(5 * time.Minute) },
+			cmd:  []byte{0x90, 5},
+		},
+		{
+			name: "interval-ignored",
+			set:  func() error { return dev.SetInterval(2 * time.Minute) },
+			cmd:  []byte{0x90, 2},
+			err:  "measurement interval not applied (got=5m0s, want=2m0s)",
+		},
+		{
+			name: "interval-invalid",
+			set:  func() error { return dev.SetInterval(3 * time.Minute) },
+			err:  "invalid measurement interval 3m0s",
+		},
+		{
+			name: "integrations-same",
+			set:  func() error { return dev.SetIntegrations(true) },
+			cmd:  []byte{0x91, 1},
+		},
+		{
+			name: "integrations-ignored",
+			set:  func() error { return dev.SetIntegrations(false) },
+			cmd:  []byte{0x91, 0},
+			err:  "setting not applied (got=true, want=false)",
+		},
+		{
+			name: "range-ignored",
+			set:  func() error { return dev.SetBluetoothRange(RangeExtended) },
+			cmd:  []byte{0x92, 1},
+			err:  "setting not applied (got=false, want=true)",
+		},
+		{
+			name: "range-invalid",
+			set:  func() error { return dev.SetBluetoothRange(2) },
+			err:  "invalid Bluetooth range BluetoothRange(2)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmds = nil
+			err := tc.set()
+			switch {
+			case err == nil && tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			case err != nil && tc.err == "":
+				t.Fatalf("could not apply setting: %+v", err)
+			case err != nil && !strings.Contains(err.Error(), tc.err):
+				t.Fatalf("invalid error:\ngot= %v\nwant=%v", err, tc.err)
+			}
+
+			switch {
+			case tc.cmd == nil && len(cmds) != 0:
+				t.Fatalf("unexpected commands: %x", cmds)
+			case tc.cmd != nil && len(cmds) != 1:
+				t.Fatalf("invalid number of commands: got=%d, want=1", len(cmds))
+			case tc.cmd != nil && !bytes.Equal(cmds[0], tc.cmd):
+				t.Fatalf("invalid command: got=%x, want=%x", cmds[0], tc.cmd)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// subscribeRetries is the number of consecutive failed attempts at
+	// reading from, or reconnecting to, a device after which a subscription
+	// gives up.
+	subscribeRetries = 5
+
+	// subscribeBackoff is the maximum delay between two reconnection
+	// attempts.
+	subscribeBackoff = time.Minute
+)
+
+// Subscribe streams the measurements of the device, as they are made.
+//
+// Subscribe reads the current measurement of the device and then sleeps
+// until right after the next one is due, as given by the measurement
+// interval and the elapsed time since the last measurement.
+// Each measurement is delivered exactly once.
+//
+// If the link with the device is lost and the device was created with
+// NewContext or NewWithDialer, the connection is transparently
+// re-established.
+//
+// The returned channel is closed when the context is done, or when the
+// device could not be reached after a few attempts.
+// Err then reports the error that ended the subscription.
+func (dev *Device) Subscribe(ctx context.Context) (<-chan Data, error) {
+	dev.mu.Lock()
+	closed := dev.closed
+	dev.subErr = nil
+	dev.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	sub := subscription{
+		read:  dev.ReadContext,
+		now:   dev.clock,
+		slack: dev.slack,
+	}
+	if dev.dial != nil {
+		sub.reconnect = dev.reconnect
+	}
+
+	ch := make(chan Data)
+	go func() {
+		defer close(ch)
+		err := sub.run(ctx, ch)
+		dev.mu.Lock()
+		dev.subErr = err
+		dev.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+// Err returns the error that ended the last subscription to the device,
+// once its channel has been closed.
+func (dev *Device) Err() error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.subErr
+}
+
+// subscription polls a device for new measurements.
+type subscription struct {
+	read      func(ctx context.Context) (Data, error) // reads the current measurement
+	reconnect func(ctx context.Context) error         // re-establishes the link, if possible
+	now       func() time.Time                        // returns the current time
+	slack     time.Duration                           // delay between a measurement and its pick up
+}
+
+func (sub subscription) run(ctx context.Context, ch chan<- Data) error {
+	var (
+		last  Data // last delivered measurement
+		fails int  // number of consecutive failures
+		wait  time.Duration
+	)
+	for {
+		if wait > 0 {
+			tmr := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				tmr.Stop()
+				return ctx.Err()
+			case <-tmr.C:
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := sub.read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if sub.reconnect == nil || errors.Is(err, ErrClosed) {
+				return fmt.Errorf("could not read measurement: %w", err)
+			}
+			fails++
+			if fails >= subscribeRetries {
+				return fmt.Errorf("could not read measurement after %d attempts: %w", fails, err)
+			}
+			wait = backoff(fails)
+			if e := sub.reconnect(ctx); e == nil {
+				wait = 0
+			}
+			continue
+		}
+		fails = 0
+
+		if !last.Time.IsZero() && !isNewMeasurement(last, data) {
+			// woke up before the device took its next measurement.
+			wait = sub.slack
+			continue
+		}
+
+		select {
+		case ch <- data:
+			last = data
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		interval := data.Interval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		wait = data.Time.Add(interval).Sub(sub.now()) + sub.slack
+	}
+}
+
+// backoff returns the delay before the n-th attempt at reconnecting.
+func backoff(n int) time.Duration {
+	d := time.Second << uint(n-1)
+	if d > subscribeBackoff || d <= 0 {
+		d = subscribeBackoff
+	}
+	return d
+}
@@ -0,0 +1,78 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sbinet.org/x/aranet4"
+	"sbinet.org/x/aranet4/emu"
+)
+
+func TestSubscribe(t *testing.T) {
+	sensor := emu.New(emu.Config{Interval: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dev, err := aranet4.NewWithDialer(ctx, func(context.Context) (aranet4.Transport, error) {
+		return sensor.Connect(), nil
+	})
+	if err != nil {
+		t.Fatalf("could not connect: %+v", err)
+	}
+	defer dev.Close()
+	aranet4.SetSlack(dev, 100*time.Millisecond)
+
+	ch, err := dev.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("could not subscribe: %+v", err)
+	}
+
+	var vs []aranet4.Data
+	for data := range ch {
+		vs = append(vs, data)
+		switch len(vs) {
+		case 1:
+			sensor.Disconnect() // link loss.
+		case 3:
+			cancel()
+		}
+	}
+	if got, want := dev.Err(), context.Canceled; !errors.Is(got, want) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", got, want)
+	}
+	if got, want := len(vs), 3; got < want {
+		t.Fatalf("invalid number of measurements: got=%d, want=%d", got, want)
+	}
+	for i := 1; i < len(vs); i++ {
+		dt := vs[i].Time.Sub(vs[i-1].Time)
+		if dt < 500*time.Millisecond || dt > 1900*time.Millisecond {
+			t.Fatalf("invalid delay between measurements %d and %d: %v", i-1, i, dt)
+		}
+	}
+}
+
+func TestSubscribeLinkLoss(t *testing.T) {
+	sensor := emu.New(emu.Config{Interval: time.Second})
+	dev := aranet4.NewWithTransport(sensor.Connect())
+	defer dev.Close()
+
+	ch, err := dev.Subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("could not subscribe: %+v", err)
+	}
+	<-ch
+	sensor.Disconnect()
+
+	for range ch {
+	}
+	if dev.Err() == nil {
+		t.Fatalf("expected an error after link loss")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+// Transport is a GATT client connection to an Aranet4 device.
+//
+// A Transport is used by a Device to discover the remote services and
+// characteristics it needs.
+// The default implementation, used by New, relies on the
+// tinygo.org/x/bluetooth package.
+// Other implementations may be provided with NewWithTransport, e.g. to
+// exercise a Device against an in-memory sensor.
+type Transport interface {
+	// DiscoverService returns the remote service identified by
+	// the provided UUID.
+	DiscoverService(uuid string) (Service, error)
+
+	// Close disconnects from the remote device.
+	Close() error
+}
+
+// Service is a remote GATT service.
+type Service interface {
+	// UUID returns the UUID of this service.
+	UUID() string
+
+	// DiscoverCharacteristic returns the characteristic of this service
+	// identified by the provided UUID.
+	DiscoverCharacteristic(uuid string) (Characteristic, error)
+}
+
+// Characteristic is a remote GATT characteristic.
+type Characteristic interface {
+	// UUID returns the UUID of this characteristic.
+	UUID() string
+
+	// Read reads the current value of the characteristic into p.
+	// Read returns the number of bytes of the value.
+	Read(p []byte) (int, error)
+
+	// WriteWithoutResponse writes p to the characteristic, without
+	// waiting for an acknowledgement from the remote device.
+	WriteWithoutResponse(p []byte) (int, error)
+
+	// EnableNotifications enables notifications for this characteristic.
+	// The provided callback is invoked with the new value of the
+	// characteristic, each time the remote device notifies it.
+	// Calling EnableNotifications again replaces the callback.
+	// A nil callback disables notifications.
+	EnableNotifications(cb func(p []byte)) error
+}
@@ -0,0 +1,108 @@
+// Copyright ©2022 The aranet4 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aranet4
+
+import (
+	"fmt"
+	"sync"
+)
+
+// memTransport is an in-memory Transport.
+type memTransport struct {
+	svcs   map[string]*memService
+	closed chan struct{}
+}
+
+func newMemTransport() *memTransport {
+	return &memTransport{
+		svcs:   make(map[string]*memService),
+		closed: make(chan struct{}),
+	}
+}
+
+func (tr *memTransport) add(svc, uuid string) *memChar {
+	s, ok := tr.svcs[svc]
+	if !ok {
+		s = &memService{uuid: svc, chars: make(map[string]*memChar)}
+		tr.svcs[svc] = s
+	}
+	c := &memChar{uuid: uuid, tr: tr}
+	s.chars[uuid] = c
+	return c
+}
+
+func (tr *memTransport) DiscoverService(uuid string) (Service, error) {
+	svc, ok := tr.svcs[uuid]
+	if !ok {
+		return nil, fmt.Errorf("no such service %q", uuid)
+	}
+	return svc, nil
+}
+
+func (tr *memTransport) Close() error {
+	close(tr.closed)
+	return nil
+}
+
+type memService struct {
+	uuid  string
+	chars map[string]*memChar
+}
+
+func (svc *memService) UUID() string { return svc.uuid }
+
+func (svc *memService) DiscoverCharacteristic(uuid string) (Characteristic, error) {
+	c, ok := svc.chars[uuid]
+	if !ok {
+		return nil, fmt.Errorf("no such characteristic %q", uuid)
+	}
+	return c, nil
+}
+
+type memChar struct {
+	tr    *memTransport
+	uuid  string
+	value []byte
+	hang  bool // whether reads block until the transport is closed
+	write func(p []byte)
+
+	mu sync.Mutex
+	cb func(p []byte)
+}
+
+func (c *memChar) UUID() string { return c.uuid }
+
+func (c *memChar) Read(p []byte) (int, error) {
+	if c.hang {
+		<-c.tr.closed
+		return 0, fmt.Errorf("transport closed")
+	}
+	return copy(p, c.value), nil
+}
+
+func (c *memChar) WriteWithoutResponse(p []byte) (int, error) {
+	if c.write == nil {
+		return 0, fmt.Errorf("characteristic %q is read-only", c.uuid)
+	}
+	c.write(append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (c *memChar) EnableNotifications(cb func(p []byte)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cb = cb
+	return nil
+}
+
+func (c *memChar) notify(p []byte) {
+	c.mu.Lock()
+	cb := c.cb
+	c.mu.Unlock()
+
+	if cb != nil {
+		cb(p)
+	}
+}